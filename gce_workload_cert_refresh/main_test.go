@@ -296,10 +296,26 @@ func (mds *mdsTestClient) Watch(ctx context.Context) (*metadata.Descriptor, erro
 	return nil, fmt.Errorf("Watch() not yet implemented")
 }
 
+func (mds *mdsTestClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	return "", "", fmt.Errorf("WatchKey() not yet implemented")
+}
+
 func (mds *mdsTestClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
 	return fmt.Errorf("WriteGuestattributes() not yet implemented")
 }
 
+func (mds *mdsTestClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsTestClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsTestClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
 func TestRefreshCreds(t *testing.T) {
 	ctx := context.Background()
 	tmp := t.TempDir()
@@ -139,6 +139,34 @@ func getMetadataAttributes(ctx context.Context, metadataKey string) (*attributes
 	return &a, nil
 }
 
+// getCachedAttributes returns the instance and project attributes of the last known
+// good metadata descriptor cached by the guest agent. The cached descriptor must be
+// verified to belong to the instance instanceID, the SSH keys of another instance
+// (i.e. the one the disk was cloned from) are never returned.
+func getCachedAttributes(cache *metadata.Cache, instanceID string) (*attributes, *attributes, error) {
+	cached, err := cache.Read(instanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !cached.Verified {
+		return nil, nil, fmt.Errorf("cached metadata can't be verified to belong to this instance")
+	}
+
+	logger.Warningf("Using cached metadata fetched %s ago (at %s, etag %s)",
+		cached.Age().Round(time.Second), cached.Timestamp.Format(time.RFC3339), cached.Etag)
+
+	toAttributes := func(a metadata.Attributes) *attributes {
+		return &attributes{
+			EnableWindowsSSH:    a.EnableWindowsSSH,
+			BlockProjectSSHKeys: a.BlockProjectKeys,
+			SSHKeys:             a.SSHKeys,
+		}
+	}
+
+	return toAttributes(cached.Descriptor.Instance.Attributes), toAttributes(cached.Descriptor.Project.Attributes), nil
+}
+
 func main() {
 	ctx := context.Background()
 	username := os.Args[1]
@@ -163,15 +191,30 @@ func main() {
 	// Try flushing logs before exiting, if not flushed logs could go missing.
 	defer logger.Close()
 
+	var projectAttributes *attributes
 	instanceAttributes, err := getMetadataAttributes(ctx, "instance/attributes/")
 	if err != nil {
 		logger.Errorf("Cannot read instance metadata attributes: %v", err)
-		os.Exit(1)
+	} else {
+		projectAttributes, err = getMetadataAttributes(ctx, "project/attributes/")
+		if err != nil {
+			logger.Errorf("Cannot read project metadata attributes: %v", err)
+		}
 	}
-	projectAttributes, err := getMetadataAttributes(ctx, "project/attributes/")
+
+	// Metadata server is unreachable, fallback to the last known good metadata cached by the guest agent.
 	if err != nil {
-		logger.Errorf("Cannot read project metadata attributes: %v", err)
-		os.Exit(1)
+		// Without the instance ID the cache can't be verified and is refused.
+		instanceID, idErr := client.GetKey(ctx, "instance/id", nil)
+		if idErr != nil {
+			logger.Errorf("Cannot read instance ID: %v", idErr)
+		}
+
+		instanceAttributes, projectAttributes, err = getCachedAttributes(metadata.NewCache(""), strings.TrimSpace(instanceID))
+		if err != nil {
+			logger.Errorf("Cannot read cached metadata attributes: %v", err)
+			os.Exit(1)
+		}
 	}
 
 	if runtime.GOOS == "windows" && !checkWinSSHEnabled(instanceAttributes, projectAttributes) {
@@ -17,6 +17,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -286,6 +288,58 @@ func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
 	return nil, fmt.Errorf("Watch() not yet implemented")
 }
 
+func (mds *mdsClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	return "", "", fmt.Errorf("WatchKey() not yet implemented")
+}
+
 func (mds *mdsClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
 	return fmt.Errorf("WriteGuestattributes() not yet implemented")
 }
+
+func (mds *mdsClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
+func TestGetCachedAttributes(t *testing.T) {
+	cacheFile := path.Join(t.TempDir(), "metadata-cache.json")
+	cache := metadata.NewCache(cacheFile)
+
+	if _, _, err := getCachedAttributes(cache, "123"); err == nil {
+		t.Errorf("getCachedAttributes(%s) succeeded for missing cache, want error", cacheFile)
+	}
+
+	cached := `{"timestamp":"2023-01-01T00:00:00Z","etag":"foo","instanceId":"123","descriptor":{"instance":{"attributes":{"enable-windows-ssh":"true","ssh-keys":"name:ssh-rsa [KEY] instance1","block-project-ssh-keys":"true"}},"project":{"attributes":{"ssh-keys":"name:ssh-rsa [KEY] project1"}}}}`
+	if err := os.WriteFile(cacheFile, []byte(cached), 0600); err != nil {
+		t.Fatalf("Failed to write cache file %q: %v", cacheFile, err)
+	}
+
+	// The cache of another instance, or of an instance that can't be verified, is
+	// never used.
+	for _, instanceID := range []string{"456", ""} {
+		if _, _, err := getCachedAttributes(cache, instanceID); err == nil {
+			t.Errorf("getCachedAttributes(%s, %q) succeeded for the cache of instance 123, want error", cacheFile, instanceID)
+		}
+	}
+
+	instance, project, err := getCachedAttributes(cache, "123")
+	if err != nil {
+		t.Fatalf("getCachedAttributes(%s) failed unexpectedly with error: %v", cacheFile, err)
+	}
+
+	wantInstance := &attributes{EnableWindowsSSH: truebool, BlockProjectSSHKeys: true, SSHKeys: []string{"name:ssh-rsa [KEY] instance1"}}
+	wantProject := &attributes{SSHKeys: []string{"name:ssh-rsa [KEY] project1"}}
+	if !reflect.DeepEqual(instance, wantInstance) {
+		t.Errorf("getCachedAttributes(%s) returned instance attributes %+v, want %+v", cacheFile, instance, wantInstance)
+	}
+	if !reflect.DeepEqual(project, wantProject) {
+		t.Errorf("getCachedAttributes(%s) returned project attributes %+v, want %+v", cacheFile, project, wantProject)
+	}
+}
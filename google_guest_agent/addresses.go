@@ -23,10 +23,15 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	netlinkEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/netlink"
 	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
@@ -36,7 +41,113 @@ var (
 	oldWSFCEnable    bool
 )
 
-type addressMgr struct{}
+type addressMgr struct {
+	// changedMutex protects changed and relink.
+	changedMutex sync.Mutex
+	// changed are the indexes of the network interfaces affected by network
+	// changes not yet handled.
+	changed map[int]bool
+	// relink is true if a network interface was added or brought up since the
+	// network changes were last handled.
+	relink bool
+}
+
+// networkDebounceOptions coalesce the bursts of network changes, i.e. all the
+// addresses and routes of an interface going away, into a single resync.
+var networkDebounceOptions = events.DebounceOptions{
+	QuietPeriod: time.Second,
+	MaxDelay:    5 * time.Second,
+}
+
+// recordNetworkEvent is the netlink events callback recording the network changes
+// to be handled by resyncInterfaces().
+func (a *addressMgr) recordNetworkEvent(ctx context.Context, evType string, change *netlinkEvent.Change, err error) bool {
+	if err != nil {
+		logger.Errorf("Netlink event watcher failed: %v", err)
+		return true
+	}
+
+	if change != nil {
+		a.networkChanged(change)
+	}
+	return true
+}
+
+// networkChanged records the network interface affected by change, it returns
+// false if change doesn't require the interfaces to be set up again. Only
+// interfaces showing up and removed addresses and routes are handled, so the
+// changes made while handling them don't trigger a new round.
+func (a *addressMgr) networkChanged(change *netlinkEvent.Change) bool {
+	switch change.Kind {
+	case netlinkEvent.LinkAdded, netlinkEvent.LinkUp, netlinkEvent.AddressRemoved, netlinkEvent.RouteRemoved:
+	default:
+		return false
+	}
+
+	logger.Debugf("Network changed: %s", change)
+
+	a.changedMutex.Lock()
+	defer a.changedMutex.Unlock()
+
+	if a.changed == nil {
+		a.changed = make(map[int]bool)
+	}
+	a.changed[change.Index] = true
+	if change.Kind == netlinkEvent.LinkAdded || change.Kind == netlinkEvent.LinkUp {
+		a.relink = true
+	}
+
+	return true
+}
+
+// resyncInterfaces is the debounced netlink events callback, it sets up the network
+// interfaces again if any was added or brought up and restores the missing routes of
+// the interfaces affected by the network changes recorded by recordNetworkEvent().
+func (a *addressMgr) resyncInterfaces(ctx context.Context, evType string, change *netlinkEvent.Change, err error) bool {
+	a.changedMutex.Lock()
+	changed, relink := a.changed, a.relink
+	a.changed, a.relink = nil, false
+	a.changedMutex.Unlock()
+
+	if len(changed) == 0 {
+		return true
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	// Nothing to restore before the first metadata update.
+	if newMetadata == nil {
+		return true
+	}
+
+	if disabled, err := a.Disabled(ctx); err != nil || disabled {
+		return true
+	}
+
+	config := cfg.Get()
+
+	if relink {
+		logger.Infof("Network interfaces changed, setting them up again")
+		if err := network.SetupInterfaces(ctx, config, newMetadata); err != nil {
+			logger.Errorf("Failed to setup network interfaces: %v", err)
+		}
+	}
+
+	if !config.NetworkInterfaces.IPForwarding {
+		return true
+	}
+
+	for _, ni := range newMetadata.Instance.NetworkInterfaces {
+		iface, err := network.GetInterfaceByMAC(ni.Mac)
+		if err != nil || !changed[iface.Index] {
+			continue
+		}
+		a.syncForwardedIPs(ctx, config, ni)
+	}
+
+	return true
+}
 
 func (a *addressMgr) parseWSFCAddresses(config *cfg.Sections) string {
 	if config.WSFC != nil && config.WSFC.Addresses != "" {
@@ -296,127 +407,133 @@ func (a *addressMgr) Set(ctx context.Context) error {
 	logger.Debugf("Add routes for aliases, forwarded IP and target-instance IPs")
 	// Add routes for IP aliases, forwarded and target-instance IPs.
 	for _, ni := range newMetadata.Instance.NetworkInterfaces {
-		iface, err := network.GetInterfaceByMAC(ni.Mac)
+		a.syncForwardedIPs(ctx, config, ni)
+	}
+
+	return nil
+}
+
+// syncForwardedIPs adds the missing routes for the IP aliases, forwarded and
+// target-instance IPs of ni and removes the ones no longer wanted.
+func (a *addressMgr) syncForwardedIPs(ctx context.Context, config *cfg.Sections, ni metadata.NetworkInterfaces) {
+	iface, err := network.GetInterfaceByMAC(ni.Mac)
+	if err != nil {
+		if !slices.Contains(badMAC, ni.Mac) {
+			logger.Errorf("Error getting interface: %s", err)
+			badMAC = append(badMAC, ni.Mac)
+		}
+		return
+	}
+	wantIPs := ni.ForwardedIps
+	wantIPs = append(wantIPs, ni.ForwardedIpv6s...)
+	if config.IPForwarding.TargetInstanceIPs {
+		wantIPs = append(wantIPs, ni.TargetInstanceIps...)
+	}
+	// IP Aliases are not supported on windows.
+	if runtime.GOOS != "windows" && config.IPForwarding.IPAliases {
+		wantIPs = append(wantIPs, ni.IPAliases...)
+	}
+
+	var forwardedIPs []string
+	var configuredIPs []string
+	if runtime.GOOS == "windows" {
+		addrs, err := iface.Addrs()
 		if err != nil {
-			if !slices.Contains(badMAC, ni.Mac) {
-				logger.Errorf("Error getting interface: %s", err)
-				badMAC = append(badMAC, ni.Mac)
-			}
-			continue
+			logger.Errorf("Error getting addresses for interface %s: %s", iface.Name, err)
 		}
-		wantIPs := ni.ForwardedIps
-		wantIPs = append(wantIPs, ni.ForwardedIpv6s...)
-		if config.IPForwarding.TargetInstanceIPs {
-			wantIPs = append(wantIPs, ni.TargetInstanceIps...)
+		for _, addr := range addrs {
+			configuredIPs = append(configuredIPs, strings.TrimSuffix(addr.String(), "/32"))
 		}
-		// IP Aliases are not supported on windows.
-		if runtime.GOOS != "windows" && config.IPForwarding.IPAliases {
-			wantIPs = append(wantIPs, ni.IPAliases...)
+		regFwdIPs, err := getForwardsFromRegistry(ni.Mac)
+		if err != nil {
+			logger.Errorf("Error getting forwards from registry: %s", err)
+			return
 		}
-
-		var forwardedIPs []string
-		var configuredIPs []string
-		if runtime.GOOS == "windows" {
-			addrs, err := iface.Addrs()
-			if err != nil {
-				logger.Errorf("Error getting addresses for interface %s: %s", iface.Name, err)
-			}
-			for _, addr := range addrs {
-				configuredIPs = append(configuredIPs, strings.TrimSuffix(addr.String(), "/32"))
-			}
-			regFwdIPs, err := getForwardsFromRegistry(ni.Mac)
-			if err != nil {
-				logger.Errorf("Error getting forwards from registry: %s", err)
-				continue
-			}
-			for _, ip := range configuredIPs {
-				// Only add to `forwardedIPs` if it is recorded in the registry.
-				if slices.Contains(regFwdIPs, ip) {
-					forwardedIPs = append(forwardedIPs, ip)
-				}
-			}
-		} else {
-			forwardedIPs, err = getLocalRoutes(ctx, config, iface.Name)
-			if err != nil {
-				logger.Errorf("Error getting routes: %v", err)
-				continue
+		for _, ip := range configuredIPs {
+			// Only add to `forwardedIPs` if it is recorded in the registry.
+			if slices.Contains(regFwdIPs, ip) {
+				forwardedIPs = append(forwardedIPs, ip)
 			}
 		}
+	} else {
+		forwardedIPs, err = getLocalRoutes(ctx, config, iface.Name)
+		if err != nil {
+			logger.Errorf("Error getting routes: %v", err)
+			return
+		}
+	}
 
-		// Trims any '/32' suffix for consistency.
-		trimSuffix := func(entries []string) []string {
-			var res []string
-			for _, entry := range entries {
-				res = append(res, strings.TrimSuffix(entry, "/32"))
-			}
-			return res
+	// Trims any '/32' suffix for consistency.
+	trimSuffix := func(entries []string) []string {
+		var res []string
+		for _, entry := range entries {
+			res = append(res, strings.TrimSuffix(entry, "/32"))
 		}
-		forwardedIPs = trimSuffix(forwardedIPs)
-		wantIPs = trimSuffix(wantIPs)
+		return res
+	}
+	forwardedIPs = trimSuffix(forwardedIPs)
+	wantIPs = trimSuffix(wantIPs)
 
-		toAdd, toRm := compareRoutes(forwardedIPs, wantIPs)
+	toAdd, toRm := compareRoutes(forwardedIPs, wantIPs)
 
-		if len(toAdd) != 0 || len(toRm) != 0 {
-			var msg string
-			msg = fmt.Sprintf("Changing forwarded IPs for %s from %q to %q by", ni.Mac, forwardedIPs, wantIPs)
+	if len(toAdd) != 0 || len(toRm) != 0 {
+		var msg string
+		msg = fmt.Sprintf("Changing forwarded IPs for %s from %q to %q by", ni.Mac, forwardedIPs, wantIPs)
+		if len(toAdd) != 0 {
+			msg += fmt.Sprintf(" adding %q", toAdd)
+		}
+		if len(toRm) != 0 {
 			if len(toAdd) != 0 {
-				msg += fmt.Sprintf(" adding %q", toAdd)
-			}
-			if len(toRm) != 0 {
-				if len(toAdd) != 0 {
-					msg += " and"
-				}
-				msg += fmt.Sprintf(" removing %q", toRm)
+				msg += " and"
 			}
-			logger.Infof(msg)
+			msg += fmt.Sprintf(" removing %q", toRm)
 		}
+		logger.Infof(msg)
+	}
 
-		var registryEntries []string
-		for _, ip := range wantIPs {
-			// If the IP is not in toAdd, add to registry list and continue.
-			if !slices.Contains(toAdd, ip) {
-				registryEntries = append(registryEntries, ip)
-				continue
-			}
-			var err error
-			if runtime.GOOS == "windows" {
-				// Don't addAddress if this is already configured.
-				if !slices.Contains(configuredIPs, ip) {
-					err = addAddress(net.ParseIP(ip), net.IPv4Mask(255, 255, 255, 255), uint32(iface.Index))
-				}
-			} else {
-				err = addLocalRoute(ctx, config, ip, iface.Name)
-			}
-			if err == nil {
-				registryEntries = append(registryEntries, ip)
-			} else {
-				logger.Errorf("error adding route: %v", err)
-			}
+	var registryEntries []string
+	for _, ip := range wantIPs {
+		// If the IP is not in toAdd, add to registry list and continue.
+		if !slices.Contains(toAdd, ip) {
+			registryEntries = append(registryEntries, ip)
+			continue
 		}
-
-		for _, ip := range toRm {
-			var err error
-			if runtime.GOOS == "windows" {
-				if !slices.Contains(configuredIPs, ip) {
-					continue
-				}
-				err = removeAddress(net.ParseIP(ip), uint32(iface.Index))
-			} else {
-				err = removeLocalRoute(ctx, config, ip, iface.Name)
-			}
-			if err != nil {
-				logger.Errorf("error removing route: %v", err)
-				// Add IPs we fail to remove to registry to maintain accurate record.
-				registryEntries = append(registryEntries, ip)
+		var err error
+		if runtime.GOOS == "windows" {
+			// Don't addAddress if this is already configured.
+			if !slices.Contains(configuredIPs, ip) {
+				err = addAddress(net.ParseIP(ip), net.IPv4Mask(255, 255, 255, 255), uint32(iface.Index))
 			}
+		} else {
+			err = addLocalRoute(ctx, config, ip, iface.Name)
 		}
+		if err == nil {
+			registryEntries = append(registryEntries, ip)
+		} else {
+			logger.Errorf("error adding route: %v", err)
+		}
+	}
 
+	for _, ip := range toRm {
+		var err error
 		if runtime.GOOS == "windows" {
-			if err := writeRegMultiString(addressKey, ni.Mac, registryEntries); err != nil {
-				logger.Errorf("error writing registry: %s", err)
+			if !slices.Contains(configuredIPs, ip) {
+				continue
 			}
+			err = removeAddress(net.ParseIP(ip), uint32(iface.Index))
+		} else {
+			err = removeLocalRoute(ctx, config, ip, iface.Name)
+		}
+		if err != nil {
+			logger.Errorf("error removing route: %v", err)
+			// Add IPs we fail to remove to registry to maintain accurate record.
+			registryEntries = append(registryEntries, ip)
 		}
 	}
 
-	return nil
+	if runtime.GOOS == "windows" {
+		if err := writeRegMultiString(addressKey, ni.Mac, registryEntries); err != nil {
+			logger.Errorf("error writing registry: %s", err)
+		}
+	}
 }
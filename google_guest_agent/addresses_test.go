@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	netlinkEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/netlink"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 )
 
@@ -215,3 +216,38 @@ func TestWsfcFlagTriggerAddressDiff(t *testing.T) {
 		})
 	}
 }
+
+func TestNetworkChanged(t *testing.T) {
+	var tests = []struct {
+		change     netlinkEvent.Change
+		wantResync bool
+		wantRelink bool
+	}{
+		{netlinkEvent.Change{Kind: netlinkEvent.LinkAdded, Index: 3}, true, true},
+		{netlinkEvent.Change{Kind: netlinkEvent.LinkUp, Index: 3}, true, true},
+		{netlinkEvent.Change{Kind: netlinkEvent.AddressRemoved, Index: 3}, true, false},
+		{netlinkEvent.Change{Kind: netlinkEvent.RouteRemoved, Index: 3}, true, false},
+		{netlinkEvent.Change{Kind: netlinkEvent.LinkDown, Index: 3}, false, false},
+		{netlinkEvent.Change{Kind: netlinkEvent.LinkRemoved, Index: 3}, false, false},
+		{netlinkEvent.Change{Kind: netlinkEvent.AddressAdded, Index: 3}, false, false},
+		{netlinkEvent.Change{Kind: netlinkEvent.RouteAdded, Index: 3}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.change.Kind.String(), func(t *testing.T) {
+			testAddress := addressMgr{}
+
+			if got := testAddress.networkChanged(&tt.change); got != tt.wantResync {
+				t.Errorf("networkChanged(%s) = %t, want %t", tt.change, got, tt.wantResync)
+			}
+
+			if got := testAddress.changed[tt.change.Index]; got != tt.wantResync {
+				t.Errorf("networkChanged(%s) recorded interface: %t, want %t", tt.change, got, tt.wantResync)
+			}
+
+			if testAddress.relink != tt.wantRelink {
+				t.Errorf("networkChanged(%s) relink = %t, want %t", tt.change, testAddress.relink, tt.wantRelink)
+			}
+		})
+	}
+}
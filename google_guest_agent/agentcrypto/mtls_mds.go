@@ -18,11 +18,14 @@ package agentcrypto
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/uefi"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/google/go-tpm-tools/client"
 	"github.com/google/go-tpm-tools/proto/tpm"
@@ -44,6 +47,11 @@ const (
 	MTLSSchedulerID = "MTLS_MDS_Credential_Boostrapper"
 	// MTLSScheduleInterval is interval at which credential bootstrapper runs.
 	MTLSScheduleInterval = 48 * time.Hour
+	// mtlsRunTimeout bounds each credential bootstrapping attempt.
+	mtlsRunTimeout = 30 * time.Second
+	// mtlsFailureBackoff is how long after a failed bootstrapping it's run again, the
+	// delay doubles with each consecutive failure up to MTLSScheduleInterval.
+	mtlsFailureBackoff = time.Minute
 )
 
 var (
@@ -196,6 +204,18 @@ func (j *CredsJob) Interval() (time.Duration, bool) {
 	return MTLSScheduleInterval, true
 }
 
+// Options returns the job's run options. The first run is synchronous and delays
+// the agent's startup so it's a single attempt, the following ones are retried a
+// few times. A failed bootstrapping is run again after a backoff rather than waiting
+// for the next scheduled run.
+func (j *CredsJob) Options() scheduler.JobOptions {
+	return scheduler.JobOptions{
+		Timeout:        mtlsRunTimeout,
+		Retry:          retry.Policy{MaxAttempts: 3, BackoffFactor: 2, Jitter: 5 * time.Second},
+		FailureBackoff: mtlsFailureBackoff,
+	}
+}
+
 // ShouldEnable returns true if MDS endpoint for fetching credentials is available on the VM.
 // Used for identifying if we want schedule bootstrapping and enable MDS mTLS credential rotation.
 func (j *CredsJob) ShouldEnable(ctx context.Context) bool {
@@ -206,3 +226,14 @@ func (j *CredsJob) ShouldEnable(ctx context.Context) bool {
 	}
 	return true
 }
+
+// CanResume returns true if the credentials written by the last run are still in
+// place, on Linux they're lost on reboot and must be regenerated right away.
+func (j *CredsJob) CanResume(context.Context) bool {
+	for _, f := range []string{rootCACertFileName, clientCredsFileName} {
+		if _, err := os.Stat(filepath.Join(defaultCredsDir, f)); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -18,15 +18,22 @@ package cfg
 import (
 	"fmt"
 	"runtime"
+	"sync"
 
 	"github.com/go-ini/ini"
 )
 
 var (
 	// instance is the single instance of configuration sections, once loaded this package
-	// should always return it.
+	// should always return it until the configuration is reloaded.
 	instance *Sections
 
+	// instanceMutex protects instance and extraDefaults.
+	instanceMutex sync.RWMutex
+
+	// extraDefaults are the extra defaults passed to Load(), kept for Reload().
+	extraDefaults []byte
+
 	// configFile is a pointer to a function which takes the current OS name and returns
 	// an appropriate config file name. Replaceable by unit tests.
 	configFile = defaultConfigFile
@@ -56,6 +63,14 @@ accounts_daemon = true
 clock_skew_daemon = true
 network_daemon = true
 
+[Hooks]
+disk_dir = /etc/google/disk.d
+disk_timeout = 30s
+maintenance_dir = /etc/google/maintenance.d
+maintenance_timeout = 60s
+preemption_dir = /etc/google/preemption.d
+preemption_timeout = 25s
+
 [IpForwarding]
 ethernet_proto_id = 66
 ip_aliases = true
@@ -92,6 +107,9 @@ setup = true
 cert_authentication = true
 
 [MDS]
+endpoints =
+event_max_delay = 10s
+event_quiet_period = 2s
 mtls_bootstrapping_enabled = true
 
 [Snapshots]
@@ -136,6 +154,10 @@ type Sections struct {
 	// pointer is nil or not.
 	Diagnostics *Diagnostics `ini:"diagnostics,omitempty"`
 
+	// Hooks defines the event hook directories, the executables they contain are run
+	// when the matching event happens.
+	Hooks *Hooks `ini:"Hooks,omitempty"`
+
 	// IPForwarding defines the ip forwarding configuration options.
 	IPForwarding *IPForwarding `ini:"IpForwarding,omitempty"`
 
@@ -207,6 +229,25 @@ type Diagnostics struct {
 	Enable bool `ini:"enable,omitempty"`
 }
 
+// Hooks contains the configurations of Hooks section.
+type Hooks struct {
+	// DiskDir is the directory of the hooks run when a disk is attached or detached.
+	DiskDir string `ini:"disk_dir,omitempty"`
+	// DiskTimeout is the time budget of all the disk hooks of an event.
+	DiskTimeout string `ini:"disk_timeout,omitempty"`
+	// MaintenanceDir is the directory of the hooks run before and after a host
+	// maintenance, i.e. a live migration.
+	MaintenanceDir string `ini:"maintenance_dir,omitempty"`
+	// MaintenanceTimeout is the time budget of all the maintenance hooks of an event.
+	MaintenanceTimeout string `ini:"maintenance_timeout,omitempty"`
+	// PreemptionDir is the directory of the hooks run when a preemptible or spot
+	// instance is preempted.
+	PreemptionDir string `ini:"preemption_dir,omitempty"`
+	// PreemptionTimeout is the time budget of all the preemption hooks, it's capped
+	// by the preemption notice window.
+	PreemptionTimeout string `ini:"preemption_timeout,omitempty"`
+}
+
 // IPForwarding contains the configurations of IPForwarding section.
 type IPForwarding struct {
 	EthernetProtoID   string `ini:"ethernet_proto_id,omitempty"`
@@ -254,6 +295,17 @@ type OSLogin struct {
 
 // MDS contains the configurations for MDS section.
 type MDS struct {
+	// Endpoints is a comma separated list of metadata server URLs tried in order,
+	// falling back to the next one when an endpoint is unreachable. If empty the
+	// default metadata server is used. The GCE_METADATA_HOST environment variable
+	// takes precedence over this option.
+	Endpoints string `ini:"endpoints,omitempty"`
+	// EventQuietPeriod is how long metadata must remain unchanged before a change is
+	// handled, so bursts of changes are handled at once. Zero disables the debouncing.
+	EventQuietPeriod string `ini:"event_quiet_period,omitempty"`
+	// EventMaxDelay bounds how long a metadata change handling can be delayed by a
+	// continuous burst of changes.
+	EventMaxDelay string `ini:"event_max_delay,omitempty"`
 	// MTLSBootstrappingEnabled enables/disables the mTLS credential refresher.
 	MTLSBootstrappingEnabled bool `ini:"mtls_bootstrapping_enabled,omitempty"`
 }
@@ -315,30 +367,46 @@ func defaultDataSources(extraDefaults []byte) []interface{} {
 	}...)
 }
 
-// Load loads default configuration and the configuration from default config files.
-func Load(extraDefaults []byte) error {
+// load loads the configuration from the default configuration and the data sources.
+func load(defaults []byte) (*Sections, error) {
 	opts := ini.LoadOptions{
 		Loose:       true,
 		Insensitive: true,
 	}
 
-	sources := dataSources(extraDefaults)
+	sources := dataSources(defaults)
 	cfg, err := ini.LoadSources(opts, sources[0], sources[1:]...)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %+v", err)
+		return nil, fmt.Errorf("failed to load configuration: %+v", err)
 	}
 
 	sections := new(Sections)
 	if err := cfg.MapTo(sections); err != nil {
-		return fmt.Errorf("failed to map configuration to object: %+v", err)
+		return nil, fmt.Errorf("failed to map configuration to object: %+v", err)
+	}
+
+	return sections, nil
+}
+
+// Load loads default configuration and the configuration from default config files.
+func Load(defaults []byte) error {
+	sections, err := load(defaults)
+	if err != nil {
+		return err
 	}
 
+	instanceMutex.Lock()
+	defer instanceMutex.Unlock()
 	instance = sections
+	extraDefaults = defaults
 	return nil
 }
 
-// Get returns the configuration's instance previously loaded with Load().
+// Get returns the configuration's instance previously loaded with Load(). The returned
+// instance must not be modified, a Reload() replaces it instead of updating it.
 func Get() *Sections {
+	instanceMutex.RLock()
+	defer instanceMutex.RUnlock()
 	if instance == nil {
 		panic("cfg package was not initialized, Load() " +
 			"should be called in the early initialization code path")
@@ -0,0 +1,180 @@
+//  Copyright 2023 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cfg
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change describes a configuration key whose value changed on Reload().
+type Change struct {
+	// Section is the changed key's section name.
+	Section string
+	// Key is the changed key's name.
+	Key string
+	// Old is the key's value before the reload.
+	Old string
+	// New is the key's value after the reload.
+	New string
+}
+
+// String returns a human readable representation of c.
+func (c Change) String() string {
+	return fmt.Sprintf("[%s] %s: %q -> %q", c.Section, c.Key, c.Old, c.New)
+}
+
+// Files returns the configuration files, in the order they are loaded. Missing files
+// are ignored by Load() and Reload().
+func Files() []string {
+	var files []string
+	for _, source := range dataSources(nil) {
+		if file, ok := source.(string); ok {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// Reload loads the configuration again and replaces the instance returned by Get() if
+// it's valid, otherwise the current instance is kept. It returns the changed keys, the
+// instance is only replaced if there are any.
+func Reload() ([]Change, error) {
+	instanceMutex.RLock()
+	defaults := extraDefaults
+	instanceMutex.RUnlock()
+
+	sections, err := load(defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sections.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	instanceMutex.Lock()
+	defer instanceMutex.Unlock()
+
+	changes := diffSections(instance, sections)
+	if len(changes) > 0 {
+		instance = sections
+	}
+	return changes, nil
+}
+
+// validate checks the values that are parsed by their users.
+func (s *Sections) validate() error {
+	durations := map[string]string{}
+	if s.MDS != nil {
+		durations["MDS.event_quiet_period"] = s.MDS.EventQuietPeriod
+		durations["MDS.event_max_delay"] = s.MDS.EventMaxDelay
+	}
+	if s.Hooks != nil {
+		durations["Hooks.disk_timeout"] = s.Hooks.DiskTimeout
+		durations["Hooks.maintenance_timeout"] = s.Hooks.MaintenanceTimeout
+		durations["Hooks.preemption_timeout"] = s.Hooks.PreemptionTimeout
+	}
+	if s.Unstable != nil {
+		durations["Unstable.command_request_timeout"] = s.Unstable.CommandRequestTimeout
+
+		if s.Unstable.CommandPipeMode != "" {
+			if _, err := strconv.ParseInt(s.Unstable.CommandPipeMode, 8, 32); err != nil {
+				return fmt.Errorf("Unstable.command_pipe_mode %q is not a valid file mode: %w", s.Unstable.CommandPipeMode, err)
+			}
+		}
+	}
+
+	for key, value := range durations {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s %q is not a valid duration: %w", key, value, err)
+		}
+	}
+
+	return nil
+}
+
+// diffSections returns the keys that differ between a and b, sections missing on either
+// side are handled as empty sections.
+func diffSections(a, b *Sections) []Change {
+	var changes []Change
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+
+	for i := 0; i < va.NumField(); i++ {
+		field := va.Type().Field(i)
+		section := iniName(field)
+		sa, sb := va.Field(i), vb.Field(i)
+		if sa.IsNil() && sb.IsNil() {
+			continue
+		}
+
+		sectionType := field.Type.Elem()
+		for j := 0; j < sectionType.NumField(); j++ {
+			key := sectionType.Field(j)
+			old, new := keyValue(sa, j), keyValue(sb, j)
+			if old != new {
+				changes = append(changes, Change{Section: section, Key: iniName(key), Old: old, New: new})
+			}
+		}
+	}
+
+	return changes
+}
+
+// Dump returns all the keys of the current configuration and their values as
+// "[section] key = value" lines, in declaration order.
+func Dump() []string {
+	var lines []string
+	v := reflect.ValueOf(Get()).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		section := v.Field(i)
+		if section.IsNil() {
+			continue
+		}
+
+		name := iniName(v.Type().Field(i))
+		sectionType := section.Type().Elem()
+		for j := 0; j < sectionType.NumField(); j++ {
+			lines = append(lines, fmt.Sprintf("[%s] %s = %s", name, iniName(sectionType.Field(j)), keyValue(section, j)))
+		}
+	}
+
+	return lines
+}
+
+// keyValue returns the string representation of the index-th key of the section
+// pointed by section, the key's zero value if section is nil.
+func keyValue(section reflect.Value, index int) string {
+	if section.IsNil() {
+		return fmt.Sprint(reflect.Zero(section.Type().Elem().Field(index).Type).Interface())
+	}
+	return fmt.Sprint(section.Elem().Field(index).Interface())
+}
+
+// iniName returns the ini name of field.
+func iniName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("ini"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
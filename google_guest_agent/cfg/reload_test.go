@@ -0,0 +1,103 @@
+//  Copyright 2023 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cfg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "instance_configs.cfg")
+	dataSources = func(extraDefaults []byte) []interface{} {
+		return []interface{}{[]byte(defaultConfig), configPath}
+	}
+	defer func() {
+		dataSources = defaultDataSources
+	}()
+
+	if got := Files(); !reflect.DeepEqual(got, []string{configPath}) {
+		t.Errorf("Files() = %v, want [%s]", got, configPath)
+	}
+
+	if err := Load(nil); err != nil {
+		t.Fatalf("Load(nil) failed unexpectedly with error: %v", err)
+	}
+	orig := Get()
+
+	changes, err := Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed unexpectedly with error: %v", err)
+	}
+	if len(changes) != 0 || Get() != orig {
+		t.Errorf("Reload() without changes = %v, want no changes and the same instance", changes)
+	}
+
+	config := "[Unstable]\nvlan_setup_enabled = true\n\n[wsfc]\nenable = true\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	changes, err = Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed unexpectedly with error: %v", err)
+	}
+
+	want := []Change{
+		{Section: "Unstable", Key: "vlan_setup_enabled", Old: "false", New: "true"},
+		{Section: "wsfc", Key: "enable", Old: "false", New: "true"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Reload() = %v, want %v", changes, want)
+	}
+	if !Get().Unstable.VlanSetupEnabled || Get().WSFC == nil {
+		t.Errorf("Reload() didn't replace the configuration instance")
+	}
+
+	// Invalid configurations are not applied.
+	reloaded := Get()
+	config = "[Unstable]\ncommand_request_timeout = 10 seconds\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := Reload(); err == nil {
+		t.Errorf("Reload() of invalid configuration succeeded, want error")
+	}
+	if Get() != reloaded {
+		t.Errorf("Reload() of invalid configuration replaced the configuration instance")
+	}
+}
+
+func TestDump(t *testing.T) {
+	if err := Load([]byte("[Unstable]\ncommand_pipe_mode = 0700\n")); err != nil {
+		t.Fatalf("Load() failed unexpectedly with error: %v", err)
+	}
+
+	lines := Dump()
+	for _, want := range []string{"[Unstable] command_pipe_mode = 0700", "[Daemons] network_daemon = true"} {
+		found := false
+		for _, line := range lines {
+			if line == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Dump() = %v, want it to contain %q", lines, want)
+		}
+	}
+}
@@ -36,6 +36,13 @@ func Get() *Monitor {
 // passed onto the command requester.
 type Handler func([]byte) ([]byte, error)
 
+// StreamHandler functions are the business logic of commands streaming their
+// response, i.e. event notifications. They process the request like a Handler and
+// write their response to w as it becomes available, until they return or ctx is
+// done: the client closed the connection or the server is stopping. Returned errors
+// will be passed onto the command requester.
+type StreamHandler func(ctx context.Context, req []byte, w io.Writer) error
+
 // Request is the basic request structure. Command determines which handler the
 // request is routed to. Callers may set additional arbitrary fields.
 type Request struct {
@@ -92,20 +99,44 @@ func (m *Monitor) RegisterHandler(cmd string, f Handler) error {
 	if _, ok := m.handlers[cmd]; ok {
 		return fmt.Errorf("cmd %s is already handled", cmd)
 	}
+	if _, ok := m.streamHandlers[cmd]; ok {
+		return fmt.Errorf("cmd %s is already handled", cmd)
+	}
 	m.handlers[cmd] = f
 	return nil
 }
 
+// RegisterStreamHandler registers f as the stream handler for cmd, the connection
+// of a cmd request is kept open while f streams its response.
+func (m *Monitor) RegisterStreamHandler(cmd string, f StreamHandler) error {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+	if _, ok := m.handlers[cmd]; ok {
+		return fmt.Errorf("cmd %s is already handled", cmd)
+	}
+	if _, ok := m.streamHandlers[cmd]; ok {
+		return fmt.Errorf("cmd %s is already handled", cmd)
+	}
+	if m.streamHandlers == nil {
+		m.streamHandlers = make(map[string]StreamHandler)
+	}
+	m.streamHandlers[cmd] = f
+	return nil
+}
+
 // UnregisterHandler clears the handlers for cmd. If a command.Server has been
 // intialized and there are no more handlers registered, the server will be
 // signalled to stop listening for commands.
 func (m *Monitor) UnregisterHandler(cmd string) error {
 	m.handlersMu.Lock()
 	defer m.handlersMu.Unlock()
-	if _, ok := m.handlers[cmd]; !ok {
+	_, ok := m.handlers[cmd]
+	_, isStream := m.streamHandlers[cmd]
+	if !ok && !isStream {
 		return fmt.Errorf("cmd %s is not registered", cmd)
 	}
 	delete(m.handlers, cmd)
+	delete(m.streamHandlers, cmd)
 	return nil
 }
 
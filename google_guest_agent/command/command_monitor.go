@@ -25,9 +25,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -35,15 +37,27 @@ import (
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
+// srvMu serializes the management of the internally managed command server.
+var srvMu sync.Mutex
+
 var cmdMonitor *Monitor = &Monitor{
-	handlersMu: new(sync.RWMutex),
-	handlers:   make(map[string]Handler),
+	handlersMu:     new(sync.RWMutex),
+	handlers:       make(map[string]Handler),
+	streamHandlers: make(map[string]StreamHandler),
 }
 
 // Init starts an internally managed command server. The agent configuration
 // will decide the server options. Returns a reference to the internally managed
 // command monitor which the caller can Close() when appropriate.
 func Init(ctx context.Context) {
+	srvMu.Lock()
+	defer srvMu.Unlock()
+	initServer(ctx)
+}
+
+// initServer starts the internally managed command server, must be called with
+// srvMu held.
+func initServer(ctx context.Context) {
 	if cmdMonitor.srv != nil {
 		return
 	}
@@ -76,17 +90,51 @@ func Init(ctx context.Context) {
 
 // Close will close the internally managed command server, if it was initialized.
 func Close() error {
+	srvMu.Lock()
+	defer srvMu.Unlock()
 	if cmdMonitor.srv != nil {
 		return cmdMonitor.srv.Close()
 	}
 	return nil
 }
 
+// Reload restarts the internally managed command server with the current agent
+// configuration, i.e. after the pipe's path or permissions changed. The server is
+// only started again if the command monitor is still enabled. Connections already
+// accepted are not interrupted.
+func Reload(ctx context.Context) {
+	srvMu.Lock()
+	defer srvMu.Unlock()
+
+	if cmdMonitor.srv != nil {
+		if err := cmdMonitor.srv.Close(); err != nil {
+			logger.Errorf("failed to close command server: %v", err)
+		}
+		cmdMonitor.srv = nil
+	}
+
+	if cfg.Get().Unstable.CommandMonitorEnabled {
+		initServer(ctx)
+	}
+}
+
+// ConfigChanged reports whether changes affect the command monitor, which must
+// then be reloaded.
+func ConfigChanged(changes []cfg.Change) bool {
+	for _, change := range changes {
+		if change.Section == "Unstable" && strings.HasPrefix(change.Key, "command_") {
+			return true
+		}
+	}
+	return false
+}
+
 // Monitor is the structure which handles command registration and deregistration.
 type Monitor struct {
-	srv        *Server
-	handlersMu *sync.RWMutex
-	handlers   map[string]Handler
+	srv            *Server
+	handlersMu     *sync.RWMutex
+	handlers       map[string]Handler
+	streamHandlers map[string]StreamHandler
 }
 
 // Close stops the server from listening to commands.
@@ -131,7 +179,7 @@ func (c *Server) start(ctx context.Context) error {
 			}
 			conn, err := srv.Accept()
 			if err != nil {
-				if err == net.ErrClosed {
+				if errors.Is(err, net.ErrClosed) {
 					break
 				}
 				logger.Infof("error on connection to pipe %s: %v", c.pipe, err)
@@ -200,8 +248,13 @@ func (c *Server) start(ctx context.Context) error {
 					return
 				}
 				c.monitor.handlersMu.RLock()
-				defer c.monitor.handlersMu.RUnlock()
 				handler, ok := c.monitor.handlers[req.Command]
+				streamHandler, isStream := c.monitor.streamHandlers[req.Command]
+				c.monitor.handlersMu.RUnlock()
+				if isStream {
+					c.stream(ctx, conn, r, b, streamHandler)
+					return
+				}
 				if !ok {
 					if b, err := json.Marshal(CmdNotFoundError); err != nil {
 						conn.Write(internalError)
@@ -226,3 +279,48 @@ func (c *Server) start(ctx context.Context) error {
 	c.srv = srv
 	return nil
 }
+
+// stream runs the stream handler f for the request req until f returns, the client
+// closes the connection or ctx is done. r is the connection's reader.
+func (c *Server) stream(ctx context.Context, conn net.Conn, r io.Reader, req []byte, f StreamHandler) {
+	// Streams are long lived, the request timeout only applies to reading the request.
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		logger.Infof("could not clear read deadline on command stream: %v", err)
+		return
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Clients don't send anything once the stream started, the read returns when
+	// the connection is closed.
+	go func() {
+		io.Copy(io.Discard, r)
+		cancel()
+	}()
+
+	w := &deadlineWriter{conn: conn, timeout: c.timeout}
+	if err := f(sctx, req, w); err != nil {
+		re := Response{Status: HandlerError.Status, StatusMessage: err.Error()}
+		if b, err := json.Marshal(re); err != nil {
+			w.Write(internalError)
+		} else {
+			w.Write(b)
+		}
+	}
+}
+
+// deadlineWriter writes to a connection, every write must complete within timeout
+// so a client not reading its stream doesn't block the writer forever.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// Write writes b to the connection.
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return 0, err
+	}
+	return w.conn.Write(b)
+}
@@ -15,6 +15,7 @@
 package command
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -207,3 +208,110 @@ func TestListenTimeout(t *testing.T) {
 		t.Errorf("unexpected response from timed out connection, got %s but want %s", data, expect)
 	}
 }
+
+func TestStreamHandler(t *testing.T) {
+	req := []byte(`{"ArbitraryData":1234,"Command":"TestStreamHandler"}`)
+	done := make(chan struct{})
+	h := func(ctx context.Context, b []byte, w io.Writer) error {
+		defer close(done)
+		var r testRequest
+		if err := json.Unmarshal(b, &r); err != nil || r.ArbitraryData != 1234 {
+			return fmt.Errorf("unexpected request %s", b)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := fmt.Fprintf(w, "{\"Seq\":%d}\n", i); err != nil {
+				return err
+			}
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	cs := cmdServerForTest(t, 0770, "-1", 10*time.Millisecond)
+	if err := cs.monitor.RegisterStreamHandler("TestStreamHandler", h); err != nil {
+		t.Fatalf("could not register stream handler: %v", err)
+	}
+	if err := cs.monitor.RegisterHandler("TestStreamHandler", func([]byte) ([]byte, error) { return nil, nil }); err == nil {
+		t.Errorf("registering a handler for a stream handled command succeeded, want error")
+	}
+
+	conn, err := dialPipe(testctx(t), cs.pipe)
+	if err != nil {
+		t.Fatalf("could not connect to command server: %v", err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		t.Fatalf("could not write request: %v", err)
+	}
+
+	// The stream outlives the request timeout.
+	time.Sleep(50 * time.Millisecond)
+
+	r := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read stream line %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("{\"Seq\":%d}\n", i); line != want {
+			t.Errorf("unexpected stream line %d, got %q but want %q", i, line, want)
+		}
+	}
+
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("stream handler still running after the client closed the connection")
+	}
+}
+
+func TestReload(t *testing.T) {
+	cfg.Load(nil)
+	t.Cleanup(func() {
+		Close()
+		cmdMonitor.srv = nil
+	})
+
+	cfg.Get().Unstable.CommandMonitorEnabled = true
+	cfg.Get().Unstable.CommandPipePath = getTestPipePath(t)
+	Reload(testctx(t))
+	if cmdMonitor.srv == nil || cmdMonitor.srv.pipe != cfg.Get().Unstable.CommandPipePath {
+		t.Fatalf("Reload() didn't start the command server on %s", cfg.Get().Unstable.CommandPipePath)
+	}
+
+	// A new pipe path takes effect without restarting the agent.
+	newPipe := getTestPipePath(t) + "-new"
+	cfg.Get().Unstable.CommandPipePath = newPipe
+	Reload(testctx(t))
+	if cmdMonitor.srv == nil || cmdMonitor.srv.pipe != newPipe {
+		t.Fatalf("Reload() didn't restart the command server on %s", newPipe)
+	}
+
+	cfg.Get().Unstable.CommandMonitorEnabled = false
+	Reload(testctx(t))
+	if cmdMonitor.srv != nil {
+		t.Errorf("Reload() kept the command server running after it was disabled")
+	}
+}
+
+func TestConfigChanged(t *testing.T) {
+	var tests = []struct {
+		name    string
+		changes []cfg.Change
+		want    bool
+	}{
+		{"no_changes", nil, false},
+		{"other_section", []cfg.Change{{Section: "MDS", Key: "event_quiet_period"}}, false},
+		{"other_unstable_key", []cfg.Change{{Section: "Unstable", Key: "mds_mtls"}}, false},
+		{"pipe_mode", []cfg.Change{{Section: "MDS", Key: "event_max_delay"}, {Section: "Unstable", Key: "command_pipe_mode"}}, true},
+		{"monitor_enabled", []cfg.Change{{Section: "Unstable", Key: "command_monitor_enabled"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConfigChanged(tt.changes); got != tt.want {
+				t.Errorf("ConfigChanged(%v) = %t, want %t", tt.changes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// eventHistoryCommand is the command returning the event manager's recent
+	// events.
+	eventHistoryCommand = "agent.EventHistory"
+
+	// eventSubscribeCommand is the command streaming the notifications of the
+	// requested event types.
+	eventSubscribeCommand = "agent.SubscribeEvents"
+
+	// eventStreamQueueSize is the number of notifications queued for a client, the
+	// notifications are dropped while the queue is full.
+	eventStreamQueueSize = 64
+
+	// jobsListCommand is the command returning the scheduled jobs.
+	jobsListCommand = "agent.ListJobs"
+
+	// jobRunCommand is the command running a scheduled job right away.
+	jobRunCommand = "agent.RunJob"
+
+	// jobPauseCommand is the command pausing a scheduled job.
+	jobPauseCommand = "agent.PauseJob"
+
+	// jobResumeCommand is the command resuming a paused job.
+	jobResumeCommand = "agent.ResumeJob"
+)
+
+// eventHistoryRequest is the eventHistoryCommand request, EventType and Limit
+// optionally filter the returned events.
+type eventHistoryRequest struct {
+	command.Request
+	// EventType only returns the events of this type if set.
+	EventType string
+	// Limit only returns the last Limit events if greater than zero.
+	Limit int
+}
+
+// eventHistoryResponse is the eventHistoryCommand response.
+type eventHistoryResponse struct {
+	command.Response
+	// Events are the recent events, oldest first.
+	Events []events.HistoryEntry
+}
+
+// eventSubscribeRequest is the eventSubscribeCommand request.
+type eventSubscribeRequest struct {
+	command.Request
+	// EventTypes are the event types to be notified of.
+	EventTypes []string
+}
+
+// eventSubscribeResponse is the first line of the eventSubscribeCommand stream, it
+// confirms the subscription.
+type eventSubscribeResponse struct {
+	command.Response
+	// EventTypes are the subscribed event types.
+	EventTypes []string
+}
+
+// eventNotification is an event notification line of the eventSubscribeCommand stream.
+type eventNotification struct {
+	// EventType is the event's type.
+	EventType string
+	// Time is when the event was received.
+	Time time.Time
+	// Data is the event data as JSON, or as a JSON string if it can't be marshaled.
+	Data json.RawMessage `json:",omitempty"`
+	// Error is the watcher's error, if any.
+	Error string `json:",omitempty"`
+	// Dropped is the number of notifications dropped since the previous one because
+	// the client didn't keep up.
+	Dropped int64 `json:",omitempty"`
+}
+
+// jobRequest is the request of the commands controlling a scheduled job.
+type jobRequest struct {
+	command.Request
+	// JobID is the id of the controlled job.
+	JobID string
+}
+
+// jobsResponse is the response of the job commands.
+type jobsResponse struct {
+	command.Response
+	// Jobs are the status of the listed or controlled jobs.
+	Jobs []scheduler.JobStatus
+}
+
+// registerCommandHandlers registers the agent's command monitor handlers.
+func registerCommandHandlers() {
+	handlers := map[string]command.Handler{
+		eventHistoryCommand: eventHistoryHandler,
+		jobsListCommand:     jobsListHandler,
+		jobRunCommand:       jobControlHandler(jobRunCommand, (*scheduler.Scheduler).RunNow),
+		jobPauseCommand:     jobControlHandler(jobPauseCommand, (*scheduler.Scheduler).PauseJob),
+		jobResumeCommand:    jobControlHandler(jobResumeCommand, (*scheduler.Scheduler).ResumeJob),
+	}
+
+	for cmd, handler := range handlers {
+		if err := command.Get().RegisterHandler(cmd, handler); err != nil {
+			logger.Errorf("Failed to register command handler %s: %v", cmd, err)
+		}
+	}
+
+	streamHandlers := map[string]command.StreamHandler{
+		eventSubscribeCommand: eventSubscribeHandler,
+	}
+
+	for cmd, handler := range streamHandlers {
+		if err := command.Get().RegisterStreamHandler(cmd, handler); err != nil {
+			logger.Errorf("Failed to register command stream handler %s: %v", cmd, err)
+		}
+	}
+}
+
+// eventHistoryHandler returns the event manager's recent events.
+func eventHistoryHandler(b []byte) ([]byte, error) {
+	var req eventHistoryRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, fmt.Errorf("failed to parse %s request: %w", eventHistoryCommand, err)
+	}
+
+	res := eventHistoryResponse{
+		Events: filterEventHistory(events.Get().History(), req.EventType, req.Limit),
+	}
+	return json.Marshal(res)
+}
+
+// filterEventHistory returns the last limit entries of type evType, evType and
+// limit are ignored if empty or zero.
+func filterEventHistory(entries []events.HistoryEntry, evType string, limit int) []events.HistoryEntry {
+	var res []events.HistoryEntry
+	for _, entry := range entries {
+		if evType == "" || entry.EventType == evType {
+			res = append(res, entry)
+		}
+	}
+
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+
+	return res
+}
+
+// eventSubscribeHandler subscribes to the requested event types and streams their
+// notifications as JSON lines, until the client closes the connection.
+func eventSubscribeHandler(ctx context.Context, b []byte, w io.Writer) error {
+	var req eventSubscribeRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return fmt.Errorf("failed to parse %s request: %w", eventSubscribeCommand, err)
+	}
+
+	eventManager := events.Get()
+	if err := validateEventTypes(eventManager, req.EventTypes); err != nil {
+		return err
+	}
+
+	notifications := make(chan *eventNotification, eventStreamQueueSize)
+	var dropped atomic.Int64
+
+	cb := func(_ context.Context, evType string, _ interface{}, evData *events.EventData) bool {
+		select {
+		case notifications <- newEventNotification(evType, evData):
+		default:
+			dropped.Add(1)
+		}
+		return true
+	}
+
+	// The subscriptions are dropped as soon as the stream ends.
+	for _, evType := range req.EventTypes {
+		subscription := eventManager.Subscribe(evType, nil, cb)
+		defer subscription.Unsubscribe()
+	}
+
+	enc := json.NewEncoder(w)
+	res := eventSubscribeResponse{EventTypes: req.EventTypes}
+	if err := enc.Encode(res); err != nil {
+		return nil
+	}
+
+	logger.Debugf("Streaming events %v to a command monitor client", req.EventTypes)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-notifications:
+			notification.Dropped = dropped.Swap(0)
+			// A failed write means the client is gone.
+			if err := enc.Encode(notification); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// validateEventTypes checks that evTypes are all emitted by eventManager's watchers.
+func validateEventTypes(eventManager *events.Manager, evTypes []string) error {
+	if len(evTypes) == 0 {
+		return fmt.Errorf("no event types requested")
+	}
+
+	known := map[string]bool{events.WatcherHealthEvent: true}
+	for _, watcher := range eventManager.Watchers() {
+		for _, evType := range watcher.Events() {
+			known[evType] = true
+		}
+	}
+
+	for _, evType := range evTypes {
+		if !known[evType] {
+			var available []string
+			for curr := range known {
+				available = append(available, curr)
+			}
+			sort.Strings(available)
+			return fmt.Errorf("unknown event type %q, available event types: %v", evType, available)
+		}
+	}
+
+	return nil
+}
+
+// newEventNotification returns the notification of evType's event evData.
+func newEventNotification(evType string, evData *events.EventData) *eventNotification {
+	notification := &eventNotification{EventType: evType, Time: time.Now()}
+
+	if evData.Data != nil {
+		data, err := json.Marshal(evData.Data)
+		if err != nil {
+			// Fallback to a printable representation, i.e. for data holding files.
+			data, _ = json.Marshal(fmt.Sprintf("%+v", evData.Data))
+		}
+		notification.Data = data
+	}
+
+	if evData.Error != nil {
+		notification.Error = evData.Error.Error()
+	}
+
+	return notification
+}
+
+// jobsListHandler returns the scheduled jobs.
+func jobsListHandler(b []byte) ([]byte, error) {
+	return json.Marshal(jobsResponse{Jobs: scheduler.Get().Jobs()})
+}
+
+// jobControlHandler returns the handler of the command cmd, applying action to the
+// requested job and returning its status.
+func jobControlHandler(cmd string, action func(*scheduler.Scheduler, string) error) command.Handler {
+	return func(b []byte) ([]byte, error) {
+		var req jobRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			return nil, fmt.Errorf("failed to parse %s request: %w", cmd, err)
+		}
+
+		if req.JobID == "" {
+			return nil, fmt.Errorf("%s request is missing the JobID", cmd)
+		}
+
+		sched := scheduler.Get()
+		if err := action(sched, req.JobID); err != nil {
+			return nil, err
+		}
+
+		var res jobsResponse
+		for _, job := range sched.Jobs() {
+			if job.ID == req.JobID {
+				res.Jobs = append(res.Jobs, job)
+			}
+		}
+		return json.Marshal(res)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+)
+
+func TestFilterEventHistory(t *testing.T) {
+	entries := []events.HistoryEntry{
+		{EventType: "a", Data: "1"},
+		{EventType: "b", Data: "2"},
+		{EventType: "a", Data: "3"},
+		{EventType: "a", Data: "4"},
+	}
+
+	var tests = []struct {
+		name   string
+		evType string
+		limit  int
+		want   string
+	}{
+		{"all", "", 0, "1,2,3,4"},
+		{"by_type", "a", 0, "1,3,4"},
+		{"limit", "", 2, "3,4"},
+		{"by_type_and_limit", "a", 1, "4"},
+		{"large_limit", "b", 10, "2"},
+		{"unknown_type", "c", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, entry := range filterEventHistory(entries, tt.evType, tt.limit) {
+				got = append(got, entry.Data)
+			}
+
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("filterEventHistory(%q, %d) = %v, want %s", tt.evType, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventHistoryHandler(t *testing.T) {
+	if _, err := eventHistoryHandler([]byte("{")); err == nil {
+		t.Errorf("eventHistoryHandler() succeeded with invalid json, want error")
+	}
+
+	b, err := eventHistoryHandler([]byte(`{"Command":"agent.EventHistory"}`))
+	if err != nil {
+		t.Fatalf("eventHistoryHandler() failed: %v", err)
+	}
+
+	var res eventHistoryResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatalf("eventHistoryHandler() returned invalid json %s: %v", b, err)
+	}
+
+	if res.Status != 0 {
+		t.Errorf("eventHistoryHandler() status = %d, want 0", res.Status)
+	}
+}
+
+func TestNewEventNotification(t *testing.T) {
+	var tests = []struct {
+		name      string
+		data      interface{}
+		err       error
+		wantData  string
+		wantError string
+	}{
+		{"no_data", nil, nil, "", ""},
+		{"json", map[string]int{"a": 1}, nil, `{"a":1}`, ""},
+		{"not_marshalable", func() {}, nil, "", ""},
+		{"error", nil, fmt.Errorf("watcher failed"), "", "watcher failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newEventNotification("test,event", &events.EventData{Data: tt.data, Error: tt.err})
+
+			if got.EventType != "test,event" {
+				t.Errorf("newEventNotification() event type = %q, want test,event", got.EventType)
+			}
+
+			if got.Error != tt.wantError {
+				t.Errorf("newEventNotification() error = %q, want %q", got.Error, tt.wantError)
+			}
+
+			if tt.data == nil {
+				if got.Data != nil {
+					t.Errorf("newEventNotification() data = %s, want empty", got.Data)
+				}
+				return
+			}
+
+			if !json.Valid(got.Data) {
+				t.Fatalf("newEventNotification() data = %s, want valid json", got.Data)
+			}
+
+			if tt.wantData != "" && string(got.Data) != tt.wantData {
+				t.Errorf("newEventNotification() data = %s, want %s", got.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestEventSubscribeHandler(t *testing.T) {
+	var tests = []struct {
+		name    string
+		req     string
+		wantErr bool
+	}{
+		{"invalid_json", "{", true},
+		{"no_event_types", `{"Command":"agent.SubscribeEvents"}`, true},
+		{"unknown_event_type", `{"Command":"agent.SubscribeEvents","EventTypes":["unknown,event"]}`, true},
+		{"valid", fmt.Sprintf(`{"Command":"agent.SubscribeEvents","EventTypes":[%q]}`, events.WatcherHealthEvent), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			// The stream ends as soon as the subscription is confirmed.
+			cancel()
+
+			subscribers := len(events.Get().Subscribers()[events.WatcherHealthEvent])
+
+			var buf bytes.Buffer
+			err := eventSubscribeHandler(ctx, []byte(tt.req), &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("eventSubscribeHandler(%s) = %v, want error: %t", tt.req, err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if buf.Len() != 0 {
+					t.Errorf("eventSubscribeHandler(%s) wrote %q, want nothing written on error", tt.req, buf.String())
+				}
+				return
+			}
+
+			var res eventSubscribeResponse
+			if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+				t.Fatalf("eventSubscribeHandler(%s) wrote invalid json %q: %v", tt.req, buf.String(), err)
+			}
+
+			if res.Status != 0 || len(res.EventTypes) != 1 || res.EventTypes[0] != events.WatcherHealthEvent {
+				t.Errorf("eventSubscribeHandler(%s) response = %+v, want status 0 and event types [%s]", tt.req, res, events.WatcherHealthEvent)
+			}
+
+			if got := len(events.Get().Subscribers()[events.WatcherHealthEvent]); got != subscribers {
+				t.Errorf("eventSubscribeHandler(%s) left %d subscribers, want %d", tt.req, got, subscribers)
+			}
+		})
+	}
+}
+
+func TestJobsListHandler(t *testing.T) {
+	b, err := jobsListHandler([]byte(`{"Command":"agent.ListJobs"}`))
+	if err != nil {
+		t.Fatalf("jobsListHandler() failed: %v", err)
+	}
+
+	var res jobsResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatalf("jobsListHandler() returned invalid json %s: %v", b, err)
+	}
+
+	if res.Status != 0 {
+		t.Errorf("jobsListHandler() status = %d, want 0", res.Status)
+	}
+}
+
+func TestJobControlHandler(t *testing.T) {
+	var called string
+	action := func(_ *scheduler.Scheduler, jobID string) error {
+		called = jobID
+		if jobID == "failing" {
+			return fmt.Errorf("job %q failed", jobID)
+		}
+		return nil
+	}
+	handler := jobControlHandler("agent.TestJob", action)
+
+	var tests = []struct {
+		name       string
+		req        string
+		wantCalled string
+		wantErr    bool
+	}{
+		{"invalid_json", "{", "", true},
+		{"missing_job_id", `{"Command":"agent.TestJob"}`, "", true},
+		{"failing_action", `{"Command":"agent.TestJob","JobID":"failing"}`, "failing", true},
+		{"success", `{"Command":"agent.TestJob","JobID":"job"}`, "job", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = ""
+			b, err := handler([]byte(tt.req))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler(%s) = %v, want error: %t", tt.req, err, tt.wantErr)
+			}
+
+			if called != tt.wantCalled {
+				t.Errorf("handler(%s) applied the action to %q, want %q", tt.req, called, tt.wantCalled)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			var res jobsResponse
+			if err := json.Unmarshal(b, &res); err != nil {
+				t.Fatalf("handler(%s) returned invalid json %s: %v", tt.req, b, err)
+			}
+
+			// The job isn't scheduled, its status can't be listed.
+			if res.Status != 0 || len(res.Jobs) != 0 {
+				t.Errorf("handler(%s) = %+v, want status 0 and no jobs", tt.req, res)
+			}
+		})
+	}
+}
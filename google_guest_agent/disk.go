@@ -0,0 +1,61 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	diskEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/disk"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/hooks"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// defaultDiskTimeout is the disk hooks time budget used when the configured one is
+// invalid.
+const defaultDiskTimeout = 30 * time.Second
+
+// subscribeDiskHooks runs the disk hooks on the disk watcher's events. The hooks are
+// run from the ordered changes so a disk's detach hooks, i.e. unmounting it, complete
+// before the hooks of its next attach.
+func subscribeDiskHooks(eventManager *events.Manager) {
+	if _, err := events.Subscribe(eventManager, diskEvent.Changed, runDiskHooks); err != nil {
+		logger.Errorf("Failed to subscribe to disk events: %v", err)
+	}
+}
+
+// runDiskHooks runs the disk hooks for the change's event type, the hooks get the
+// disk's device name, device path and mode as extra arguments.
+func runDiskHooks(ctx context.Context, evType string, change *diskEvent.Change, err error) bool {
+	if err != nil {
+		logger.Errorf("Disk event watcher failed, ignoring: %v", err)
+		return true
+	}
+
+	config := cfg.Get().Hooks
+	if config == nil || config.DiskDir == "" {
+		return true
+	}
+
+	disk := change.Disk
+	timeout := hooksTimeout("disk", config.DiskTimeout, defaultDiskTimeout)
+	if _, err := hooks.Run(ctx, config.DiskDir, change.Event, timeout, disk.DeviceName, disk.Path, disk.Mode); err != nil {
+		logger.Errorf("Failed to run disk hooks: %v", err)
+	}
+
+	return true
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config implements the configuration file events watcher.
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the config watcher's ID.
+	WatcherID = "config-watcher"
+	// ChangedEvent is the configuration changed event type ID, its event data is the
+	// []cfg.Change list of the changed keys.
+	ChangedEvent = "config-watcher,changed"
+)
+
+var (
+	// Changed describes ChangedEvent.
+	Changed = typed.New[[]cfg.Change](ChangedEvent)
+
+	// pollInterval is how often the configuration files are checked for changes.
+	pollInterval = 5 * time.Second
+)
+
+// fileState identifies a version of a configuration file.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// Watcher is the configuration file event watcher implementation, it reloads the
+// configuration when any of the configuration files change.
+type Watcher struct {
+	// files returns the watched files, defaults to cfg.Files.
+	files func() []string
+	// reload reloads the configuration, defaults to cfg.Reload.
+	reload func() ([]cfg.Change, error)
+	// states are the last known states of the watched files.
+	states map[string]fileState
+}
+
+// New allocates and initializes a new Watcher, changes are detected from the files'
+// state at this point.
+func New() *Watcher {
+	watcher := &Watcher{
+		files:  cfg.Files,
+		reload: cfg.Reload,
+	}
+	watcher.states = watcher.currentStates()
+	return watcher
+}
+
+// ID returns the config event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{ChangedEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Changed}
+}
+
+// currentStates returns the current state of the watched files.
+func (w *Watcher) currentStates() map[string]fileState {
+	states := make(map[string]fileState)
+	for _, file := range w.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			states[file] = fileState{}
+			continue
+		}
+		states[file] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+	}
+	return states
+}
+
+// changed returns true if any watched file changed since the last call.
+func (w *Watcher) changed() bool {
+	states := w.currentStates()
+	changed := false
+	for file, state := range states {
+		if w.states[file] != state {
+			logger.Debugf("Configuration file %s changed", file)
+			changed = true
+		}
+	}
+	w.states = states
+	return changed
+}
+
+// Run polls the configuration files and reloads the configuration once they change,
+// it reports back the changed keys. Invalid configurations are reported as errors,
+// the previous configuration remains in use.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		if !w.changed() {
+			continue
+		}
+
+		changes, err := w.reload()
+		if err != nil {
+			logger.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+			return true, nil, err
+		}
+
+		if len(changes) == 0 {
+			logger.Debugf("Configuration files changed but no configuration key changed")
+			continue
+		}
+
+		return true, changes, nil
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+)
+
+func TestWatcherAPI(t *testing.T) {
+	watcher := New()
+	expectedEvents := []string{ChangedEvent}
+	if !reflect.DeepEqual(watcher.Events(), expectedEvents) {
+		t.Fatalf("watcher.Events() returned: %+v, expected: %+v.", watcher.Events(), expectedEvents)
+	}
+
+	if watcher.ID() != WatcherID {
+		t.Errorf("watcher.ID() returned: %s, expected: %s.", watcher.ID(), WatcherID)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	configPath := filepath.Join(t.TempDir(), "instance_configs.cfg")
+
+	reloads := 0
+	var reloadErr error
+	watcher := &Watcher{
+		files: func() []string { return []string{configPath} },
+		reload: func() ([]cfg.Change, error) {
+			reloads++
+			if reloadErr != nil {
+				return nil, reloadErr
+			}
+			return []cfg.Change{{Section: "Unstable", Key: "vlan_setup_enabled", Old: "false", New: "true"}}, nil
+		},
+	}
+	watcher.states = watcher.currentStates()
+
+	// Nothing changed, should keep polling until canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if renew, _, err := watcher.Run(ctx, ChangedEvent); renew || err == nil {
+		t.Errorf("watcher.Run(%s) = (%t, %v), expected (false, error) without changes.", ChangedEvent, renew, err)
+	}
+	if reloads != 0 {
+		t.Errorf("watcher.Run(%s) reloaded the configuration %d times without changes, expected 0.", ChangedEvent, reloads)
+	}
+
+	if err := os.WriteFile(configPath, []byte("[Unstable]\nvlan_setup_enabled = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	renew, evData, err := watcher.Run(context.Background(), ChangedEvent)
+	if !renew || err != nil {
+		t.Fatalf("watcher.Run(%s) = (%t, %v), expected (true, nil).", ChangedEvent, renew, err)
+	}
+	if changes, ok := evData.([]cfg.Change); !ok || len(changes) != 1 {
+		t.Errorf("watcher.Run(%s) returned %+v, expected the changed keys.", ChangedEvent, evData)
+	}
+
+	// Reload errors are reported.
+	reloadErr = fmt.Errorf("invalid configuration")
+	if err := os.Remove(configPath); err != nil {
+		t.Fatalf("Failed to remove config file: %v", err)
+	}
+
+	renew, _, err = watcher.Run(context.Background(), ChangedEvent)
+	if !renew || err != reloadErr {
+		t.Errorf("watcher.Run(%s) = (%t, %v), expected (true, %v).", ChangedEvent, renew, err, reloadErr)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// DebounceOptions configures how a subscriber's bursts of events are coalesced.
+type DebounceOptions struct {
+	// QuietPeriod is how long no new event must arrive before the callback is called.
+	// Zero disables debouncing, events are passed through right away.
+	QuietPeriod time.Duration
+	// MaxDelay bounds how long an event can be held back by a continuous burst of events,
+	// counted from the first event of the burst. Zero means no bound.
+	MaxDelay time.Duration
+}
+
+// debounced returns true if the subscriber was registered with debouncing options.
+func (sub *eventSubscriber) debounced() bool {
+	return sub.opts.DebounceFunc != nil || sub.opts.Debounce.QuietPeriod > 0
+}
+
+// debounceOptions returns the subscriber's current debouncing options.
+func (sub *eventSubscriber) debounceOptions() DebounceOptions {
+	if sub.opts.DebounceFunc != nil {
+		return sub.opts.DebounceFunc()
+	}
+	return sub.opts.Debounce
+}
+
+// runDebouncedSubscriber is the worker of a debounced subscriber. The callback is
+// called with the latest event once no new event arrived for the quiet period, or
+// once the max delay elapsed since the first held back event. Events arriving while
+// the callback runs are coalesced into the next call. A failed event (see
+// EventData.Error) never replaces a held back successful one.
+//
+// A held back event is handed to the subscribers with a lower priority right away,
+// they don't wait for the quiet period. It's still pending, so the manager waits for
+// it before leaving.
+func (mngr *Manager) runDebouncedSubscriber(ctx context.Context, sub *eventSubscriber) {
+	var held *subscriberEvent
+	var first time.Time
+	var timer *time.Timer
+	var fire <-chan time.Time
+	done := ctx.Done()
+
+	// release lets go of the held back event without calling the callback.
+	release := func() {
+		if held != nil {
+			mngr.pending.Done()
+			held = nil
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		fire = nil
+	}
+	defer release()
+
+	for {
+		select {
+		case ev, ok := <-sub.queue:
+			if !ok {
+				return
+			}
+
+			opts := sub.debounceOptions()
+			discarded := sub.stopped.Load() || ctx.Err() != nil
+			if discarded || (held == nil && opts.QuietPeriod <= 0) {
+				mngr.handle(ctx, sub, ev)
+				continue
+			}
+
+			mngr.handled(ev)
+			switch {
+			case held == nil:
+				held, first = ev, time.Now()
+			case ev.data == nil || ev.data.Error == nil || (held.data != nil && held.data.Error != nil):
+				logger.Debugf("Coalescing event %q with a previously held back event", ev.evType)
+				mngr.coalesce(sub, held)
+				held = ev
+			default:
+				mngr.coalesce(sub, ev)
+			}
+
+			// Disabling debouncing while an event is held back fires it right away.
+			delay := opts.QuietPeriod
+			if opts.MaxDelay > 0 {
+				if untilMax := time.Until(first.Add(opts.MaxDelay)); untilMax < delay {
+					delay = untilMax
+				}
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(delay)
+			fire = timer.C
+		case <-fire:
+			ev := held
+			held, fire = nil, nil
+			mngr.call(ctx, sub, ev)
+			mngr.pending.Done()
+		case <-done:
+			release()
+			done = nil
+		}
+	}
+}
+
+// coalesce records that ev was replaced by another event of the debounced subscriber
+// sub, its callback is not called for ev.
+func (mngr *Manager) coalesce(sub *eventSubscriber, ev *subscriberEvent) {
+	mngr.history.record(ev.entry, SubscriberRecord{Name: sub.name, Renew: true, Coalesced: true})
+	mngr.pending.Done()
+}
@@ -0,0 +1,290 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const debounceTestEvent = "debounce-watcher,test-event"
+
+// debounceRecorder records the calls of a debounced callback.
+type debounceRecorder struct {
+	mu      sync.Mutex
+	calls   []interface{}
+	running int
+	overlap bool
+	delay   time.Duration
+	renew   bool
+}
+
+func (r *debounceRecorder) cb(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+	r.mu.Lock()
+	r.running++
+	if r.running > 1 {
+		r.overlap = true
+	}
+	r.calls = append(r.calls, evData.Data)
+	r.mu.Unlock()
+
+	time.Sleep(r.delay)
+
+	r.mu.Lock()
+	r.running--
+	r.mu.Unlock()
+	return r.renew
+}
+
+func (r *debounceRecorder) get() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]interface{}{}, r.calls...)
+}
+
+// dispatchEvent dispatches evData to the subscribers of evType as the manager's Run()
+// does, the history entry of the event is returned.
+func dispatchEvent(ctx context.Context, mngr *Manager, evType string, evData *EventData) *HistoryEntry {
+	entry := newHistoryEntry("debounce-watcher", evType, evData)
+	mngr.history.add(entry)
+	mngr.dispatch(ctx, evType, evData, entry)
+	return entry
+}
+
+// sendEvent dispatches evData to the subscribers of debounceTestEvent.
+func sendEvent(mngr *Manager, evData *EventData) *HistoryEntry {
+	return dispatchEvent(context.Background(), mngr, debounceTestEvent, evData)
+}
+
+func TestDebounceBurst(t *testing.T) {
+	rec := &debounceRecorder{renew: true}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: 50 * time.Millisecond, MaxDelay: time.Second}})
+
+	var entries []*HistoryEntry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, sendEvent(mngr, &EventData{Data: i}))
+	}
+
+	if got := rec.get(); len(got) != 0 {
+		t.Errorf("Debounced callback called %d times during the quiet period, expected 0", len(got))
+	}
+
+	// The held back event is pending until the callback returns.
+	mngr.pending.Wait()
+	if got := rec.get(); len(got) != 1 || got[0] != 4 {
+		t.Errorf("Debounced callback got calls %v, expected [4]", got)
+	}
+
+	for i, entry := range entries {
+		coalesced := i < len(entries)-1
+		if len(entry.Subscribers) != 1 || entry.Subscribers[0].Coalesced != coalesced {
+			t.Errorf("History entry of event %d got subscribers %+v, expected coalesced = %t", i, entry.Subscribers, coalesced)
+		}
+	}
+}
+
+func TestDebounceMaxDelay(t *testing.T) {
+	rec := &debounceRecorder{renew: true}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: 100 * time.Millisecond, MaxDelay: 150 * time.Millisecond}})
+
+	// Events keep coming faster than the quiet period for longer than the max delay.
+	for i := 0; i < 20; i++ {
+		sendEvent(mngr, &EventData{Data: i})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := rec.get(); len(got) == 0 {
+		t.Errorf("Debounced callback wasn't called after max delay elapsed")
+	}
+	mngr.pending.Wait()
+}
+
+func TestDebounceNoOverlap(t *testing.T) {
+	rec := &debounceRecorder{renew: true, delay: 100 * time.Millisecond}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Concurrency: 4, Debounce: DebounceOptions{QuietPeriod: 10 * time.Millisecond}})
+
+	for i := 0; i < 10; i++ {
+		sendEvent(mngr, &EventData{Data: i})
+		time.Sleep(30 * time.Millisecond)
+	}
+	mngr.pending.Wait()
+
+	got := rec.get()
+	if rec.overlap {
+		t.Errorf("Debounced callback calls overlapped")
+	}
+	if len(got) == 0 || len(got) >= 10 {
+		t.Fatalf("Debounced callback got %d calls, expected the events to be coalesced", len(got))
+	}
+	if got[len(got)-1] != 9 {
+		t.Errorf("Debounced callback last call got %v, expected the latest event 9", got[len(got)-1])
+	}
+}
+
+func TestDebounceErrors(t *testing.T) {
+	rec := &debounceRecorder{renew: true}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: 20 * time.Millisecond}})
+
+	sendEvent(mngr, &EventData{Data: "success"})
+	sendEvent(mngr, &EventData{Data: "failure", Error: fmt.Errorf("failure")})
+	mngr.pending.Wait()
+
+	if got := rec.get(); len(got) != 1 || got[0] != "success" {
+		t.Errorf("Debounced callback got calls %v, expected [success]", got)
+	}
+}
+
+func TestDebounceRenew(t *testing.T) {
+	rec := &debounceRecorder{renew: false}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: 10 * time.Millisecond}})
+
+	sendEvent(mngr, &EventData{Data: 1})
+	mngr.pending.Wait()
+
+	if subs := mngr.Subscribers()[debounceTestEvent]; len(subs) != 0 {
+		t.Errorf("Subscribers() = %v after the debounced callback returned false, expected none", subs)
+	}
+
+	sendEvent(mngr, &EventData{Data: 2})
+	mngr.pending.Wait()
+	if got := rec.get(); len(got) != 1 {
+		t.Errorf("Debounced callback got calls %v, expected [1]", got)
+	}
+}
+
+func TestDebounceCallSubscriber(t *testing.T) {
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		panic("test panic")
+	}, SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: 10 * time.Millisecond}})
+
+	release := make(chan struct{})
+	mngr.SubscribeWithOptions(debounceTestEvent+"-slow", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		<-release
+		return true
+	}, SubscribeOptions{Timeout: 10 * time.Millisecond, Debounce: DebounceOptions{QuietPeriod: 10 * time.Millisecond}})
+	defer close(release)
+
+	entry := sendEvent(mngr, &EventData{Data: 1})
+	slowEntry := dispatchEvent(context.Background(), mngr, debounceTestEvent+"-slow", &EventData{Data: 1})
+	mngr.pending.Wait()
+
+	// The debounced calls go through the subscriber's worker as any other call.
+	if len(entry.Subscribers) != 1 || !entry.Subscribers[0].Panicked {
+		t.Errorf("History entry subscribers = %+v, expected a single panicked record", entry.Subscribers)
+	}
+	if len(slowEntry.Subscribers) != 1 || !slowEntry.Subscribers[0].TimedOut {
+		t.Errorf("History entry subscribers = %+v, expected a single timed out record", slowEntry.Subscribers)
+	}
+}
+
+func TestDebouncePriority(t *testing.T) {
+	rec := &debounceRecorder{renew: true}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Priority: 10, Debounce: DebounceOptions{QuietPeriod: time.Hour}})
+
+	called := make(chan bool, 1)
+	mngr.Subscribe(debounceTestEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		called <- true
+		return true
+	})
+
+	sendEvent(mngr, &EventData{Data: 1})
+
+	// The lower priority subscriber doesn't wait for the quiet period.
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Errorf("Lower priority subscriber wasn't called while the debounced subscriber held the event back")
+	}
+
+	if got := rec.get(); len(got) != 0 {
+		t.Errorf("Debounced callback got calls %v during the quiet period, expected none", got)
+	}
+}
+
+func TestDebounceFunc(t *testing.T) {
+	rec := &debounceRecorder{renew: true}
+
+	var mu sync.Mutex
+	opts := DebounceOptions{}
+	setOpts := func(o DebounceOptions) {
+		mu.Lock()
+		defer mu.Unlock()
+		opts = o
+	}
+
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{DebounceFunc: func() DebounceOptions {
+		mu.Lock()
+		defer mu.Unlock()
+		return opts
+	}})
+
+	sendEvent(mngr, &EventData{Data: 0})
+	mngr.pending.Wait()
+	if got := rec.get(); len(got) != 1 {
+		t.Fatalf("Callback got calls %v with debouncing disabled, expected [0]", got)
+	}
+
+	// Enabling debouncing takes effect on the next event.
+	setOpts(DebounceOptions{QuietPeriod: time.Hour})
+	for i := 1; i < 4; i++ {
+		sendEvent(mngr, &EventData{Data: i})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := rec.get(); len(got) != 1 {
+		t.Errorf("Debounced callback got calls %v during the quiet period, expected [0]", got)
+	}
+
+	// Disabling it fires the held back event right away.
+	setOpts(DebounceOptions{})
+	sendEvent(mngr, &EventData{Data: 4})
+	mngr.pending.Wait()
+	if got := rec.get(); len(got) != 2 || got[1] != 4 {
+		t.Errorf("Callback got calls %v after disabling debouncing, expected [0 4]", got)
+	}
+
+	sendEvent(mngr, &EventData{Data: 5})
+	mngr.pending.Wait()
+	if got := rec.get(); len(got) != 3 || got[2] != 5 {
+		t.Errorf("Callback got calls %v with debouncing disabled, expected [0 4 5]", got)
+	}
+}
+
+func TestDebounceLeaving(t *testing.T) {
+	rec := &debounceRecorder{renew: true}
+	mngr := newManager()
+	mngr.SubscribeWithOptions(debounceTestEvent, nil, rec.cb, SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: time.Hour}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatchEvent(ctx, mngr, debounceTestEvent, &EventData{Data: 1})
+
+	// The held back event is discarded once the context is done.
+	cancel()
+	mngr.pending.Wait()
+	if got := rec.get(); len(got) != 0 {
+		t.Errorf("Debounced callback got calls %v after leaving, expected none", got)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package disk implements the disk attach and detach events watcher, it correlates
+// the instance's disks metadata with the block devices present in the guest.
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the disk watcher's ID.
+	WatcherID = "disk-watcher"
+	// AttachedEvent is emitted when a disk is attached to the instance.
+	AttachedEvent = "disk-watcher,attached"
+	// DetachedEvent is emitted when a disk is detached from the instance.
+	DetachedEvent = "disk-watcher,detached"
+	// ChangedEvent is emitted for every attached and detached disk, in order, its
+	// subscribers never handle a disk's attach before its previous detach.
+	ChangedEvent = "disk-watcher,changed"
+
+	// disksKey is the metadata key listing the instance's disks.
+	disksKey = "instance/disks"
+
+	// byIDDir is the directory of the disks' symlinks named after their device name.
+	byIDDir = "/dev/disk/by-id"
+
+	// queueSize is the number of events buffered per event type, events are
+	// dropped if the subscribers can't keep up.
+	queueSize = 64
+)
+
+var (
+	// Attached describes AttachedEvent.
+	Attached = typed.New[*Disk](AttachedEvent)
+	// Detached describes DetachedEvent.
+	Detached = typed.New[*Disk](DetachedEvent)
+	// Changed describes ChangedEvent.
+	Changed = typed.New[*Change](ChangedEvent)
+)
+
+// Disk is the event data of the disk events.
+type Disk struct {
+	// DeviceName is the disk's device name, as set when attaching it.
+	DeviceName string
+	// Path is the disk's block device path, i.e. /dev/sdb.
+	Path string
+	// Mode is the disk's access mode, i.e. READ_WRITE or READ_ONLY.
+	Mode string
+	// Interface is the disk's interface, i.e. SCSI or NVME.
+	Interface string
+	// Type is the disk's type, i.e. PERSISTENT or LOCAL-SSD.
+	Type string
+	// Index is the disk's attachment index.
+	Index int
+}
+
+// String returns a human readable representation of the disk.
+func (d *Disk) String() string {
+	return fmt.Sprintf("%s (%s, %s)", d.DeviceName, d.Path, d.Mode)
+}
+
+// Change is the event data of ChangedEvent.
+type Change struct {
+	// Event is what happened to the disk, AttachedEvent or DetachedEvent.
+	Event string
+	// Disk is the attached or detached disk.
+	Disk *Disk
+}
+
+// String returns a human readable representation of the change.
+func (c *Change) String() string {
+	return fmt.Sprintf("%s %s", c.Event, c.Disk)
+}
+
+// event is a disk event to be dispatched.
+type event struct {
+	evType string
+	disk   *Disk
+}
+
+// Watcher is the disk event watcher implementation.
+type Watcher struct {
+	client metadata.MDSClientInterface
+	// resolve returns the block device path of the disk named deviceName, false if
+	// the device isn't present. Replaceable by unit tests.
+	resolve func(deviceName string) (string, bool)
+
+	// mutex protects running, done, err, cancel and active.
+	mutex sync.Mutex
+	// running is true while the watcher's go routines are running.
+	running bool
+	// done is closed when the watcher's go routines exit.
+	done chan struct{}
+	// err is the error that stopped the watcher's go routines.
+	err error
+	// cancel stops the watcher's go routines.
+	cancel context.CancelFunc
+	// active are the event types whose context is not done yet.
+	active map[string]bool
+
+	// queues maps the event types to the channels their disks are queued to.
+	queues map[string]chan *Disk
+	// changes is the channel ChangedEvent's changes are queued to.
+	changes chan *Change
+
+	// disks are the instance's disks by device name, as listed by the metadata.
+	// It's nil until the metadata was read. Only accessed by the reconciling go routine.
+	disks map[string]metadata.Disk
+	// attached are the disks reported as attached by device name. Only accessed by
+	// the reconciling go routine.
+	attached map[string]*Disk
+	// seeded is true once the disks present at start up were recorded, they are
+	// not reported as attached.
+	seeded bool
+}
+
+// New allocates and initializes a new Watcher.
+func New() *Watcher {
+	return newWatcher(metadata.New(), resolveDevice)
+}
+
+func newWatcher(client metadata.MDSClientInterface, resolve func(string) (string, bool)) *Watcher {
+	watcher := &Watcher{
+		client:   client,
+		resolve:  resolve,
+		queues:   make(map[string]chan *Disk),
+		changes:  make(chan *Change, queueSize),
+		active:   make(map[string]bool),
+		attached: make(map[string]*Disk),
+	}
+
+	for _, curr := range []string{AttachedEvent, DetachedEvent} {
+		watcher.queues[curr] = make(chan *Disk, queueSize)
+	}
+
+	return watcher
+}
+
+// ID returns the disk event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{AttachedEvent, DetachedEvent, ChangedEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Attached, Detached, Changed}
+}
+
+// resolveDevice returns the block device the by-id symlink of deviceName points to.
+func resolveDevice(deviceName string) (string, bool) {
+	path, err := filepath.EvalSymlinks(filepath.Join(byIDDir, "google-"+deviceName))
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// parseDisks parses the JSON formatted disks metadata.
+func parseDisks(resp string) (map[string]metadata.Disk, error) {
+	var list []metadata.Disk
+	if err := json.Unmarshal([]byte(resp), &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal disks metadata: %w", err)
+	}
+
+	disks := make(map[string]metadata.Disk)
+	for _, disk := range list {
+		disks[disk.DeviceName] = disk
+	}
+	return disks, nil
+}
+
+// reconcile compares the metadata disks with the present block devices and returns
+// the resulting events. A disk is attached once it's both listed by the metadata
+// and present, it's detached as soon as either goes away. pending is true if a
+// listed disk isn't present yet, the device may just not be set up yet.
+func (w *Watcher) reconcile() (events []event, pending bool) {
+	if w.disks == nil {
+		return nil, false
+	}
+
+	present := make(map[string]string)
+	for name := range w.disks {
+		if path, ok := w.resolve(name); ok {
+			present[name] = path
+		} else if _, found := w.attached[name]; !found {
+			pending = true
+		}
+	}
+
+	for name, disk := range w.attached {
+		if path, found := present[name]; !found || path != disk.Path {
+			delete(w.attached, name)
+			events = append(events, event{DetachedEvent, disk})
+		}
+	}
+
+	for name, path := range present {
+		if _, found := w.attached[name]; found {
+			continue
+		}
+
+		md := w.disks[name]
+		disk := &Disk{
+			DeviceName: name,
+			Path:       path,
+			Mode:       md.Mode,
+			Interface:  md.Interface,
+			Type:       md.Type,
+			Index:      md.Index,
+		}
+		w.attached[name] = disk
+
+		if w.seeded {
+			events = append(events, event{AttachedEvent, disk})
+		}
+	}
+
+	w.seeded = true
+
+	// Detaches come first, a disk whose device changed is detached and attached again.
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].evType != events[j].evType {
+			return events[i].evType == DetachedEvent
+		}
+		return events[i].disk.DeviceName < events[j].disk.DeviceName
+	})
+
+	return events, pending
+}
+
+// dispatch queues events to their event type and to ChangedEvent, events are
+// dropped if the queue is full.
+func (w *Watcher) dispatch(events []event) {
+	for _, ev := range events {
+		logger.Infof("Disk %s: %s", ev.evType, ev.disk)
+		select {
+		case w.queues[ev.evType] <- ev.disk:
+		default:
+			logger.Warningf("Disk watcher queue of %s is full, dropping %s", ev.evType, ev.disk)
+		}
+
+		select {
+		case w.changes <- &Change{Event: ev.evType, Disk: ev.disk}:
+		default:
+			logger.Warningf("Disk watcher queue of %s is full, dropping %s", ChangedEvent, ev.disk)
+		}
+	}
+}
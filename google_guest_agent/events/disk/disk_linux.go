@@ -0,0 +1,328 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ueventGroups are the kernel and udev uevent multicast groups. The udev
+	// events are sent once the device's symlinks are set up.
+	ueventGroups = 1 | 2
+
+	// udevPrefix prefixes the udev uevent messages.
+	udevPrefix = "libudev\x00"
+	// udevMagic identifies the udev uevent messages' header.
+	udevMagic = 0xfeedcafe
+	// udevHeaderSize is the size of the udev uevent messages' header fields read by
+	// the watcher: prefix, magic, header size, properties offset and length.
+	udevHeaderSize = 24
+
+	// maxRetries is how many times a listed but not present disk is looked for
+	// again before waiting for the next metadata change or uevent.
+	maxRetries = 20
+)
+
+var (
+	// retryInterval is how long the watcher waits before looking for a listed but
+	// not present disk again.
+	retryInterval = 500 * time.Millisecond
+
+	// metadataRetryInterval is how long the watcher waits before querying the
+	// metadata server again after a failure.
+	metadataRetryInterval = 5 * time.Second
+)
+
+// Run waits for the next disk event of evType and reports it back. The watcher's
+// go routines are started by the first call and stop once the contexts of all the
+// event types are done. If they fail the error is reported and renewing starts them
+// again.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	// Only one of queue and changes is set, the other one is never ready.
+	queue, found := w.queues[evType]
+	var changes chan *Change
+	if evType == ChangedEvent {
+		changes = w.changes
+	} else if !found {
+		return false, nil, fmt.Errorf("disk watcher: unknown event type %q", evType)
+	}
+
+	done, err := w.start(evType)
+	if err != nil {
+		return true, nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		w.leave(evType)
+		return false, nil, ctx.Err()
+	case <-done:
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		return true, nil, w.err
+	case disk := <-queue:
+		return true, disk, nil
+	case change := <-changes:
+		return true, change, nil
+	}
+}
+
+// start opens the uevent socket and launches the watcher's go routines if they
+// are not running yet, the returned channel is closed when they exit. evType is
+// recorded as using them until leave() is called.
+func (w *Watcher) start(evType string) (chan struct{}, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.active[evType] = true
+	if w.running {
+		return w.done, nil
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: ueventGroups}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+
+	// The socket is non blocking so the file is handled by the runtime poller and
+	// closing it unblocks the reader.
+	file := os.NewFile(uintptr(fd), "uevent")
+
+	done := make(chan struct{})
+	w.running = true
+	w.err = nil
+	w.done = done
+
+	disks := make(chan map[string]metadata.Disk, 1)
+	uevents := make(chan struct{}, 1)
+
+	// The go routines belong to the watcher rather than to the event type that
+	// started them, they share a context canceled by leave() or once the reader
+	// exits, so they don't outlive it when the watcher is started again.
+	runCtx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	go func() {
+		<-runCtx.Done()
+		file.Close()
+	}()
+
+	go w.watchMetadata(runCtx, disks)
+	go func() {
+		defer cancel()
+		w.read(file, uevents, done)
+	}()
+	go w.loop(runCtx, disks, uevents, done)
+
+	return done, nil
+}
+
+// leave records that evType's context is done, the watcher's go routines are
+// stopped once no event type is left.
+func (w *Watcher) leave(evType string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	delete(w.active, evType)
+	if len(w.active) == 0 && w.cancel != nil {
+		w.cancel()
+	}
+}
+
+// loop reconciles the disks on every metadata change and block device uevent
+// until ctx is done or the uevent reader exits.
+func (w *Watcher) loop(ctx context.Context, disks <-chan map[string]metadata.Disk, uevents <-chan struct{}, done chan struct{}) {
+	retry := time.NewTimer(retryInterval)
+	retry.Stop()
+	defer retry.Stop()
+
+	var retries int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case w.disks = <-disks:
+			retries = 0
+		case <-uevents:
+			retries = 0
+		case <-retry.C:
+		}
+
+		events, pending := w.reconcile()
+		w.dispatch(events)
+
+		if pending && retries < maxRetries {
+			retries++
+			retry.Reset(retryInterval)
+		}
+	}
+}
+
+// watchMetadata longpolls the disks metadata until ctx is done, the disks are sent
+// to disks on every change.
+func (w *Watcher) watchMetadata(ctx context.Context, disks chan map[string]metadata.Disk) {
+	var etag string
+	var failed bool
+
+	for ctx.Err() == nil {
+		resp, newEtag, err := w.client.WatchKey(ctx, disksKey, etag)
+		if err == nil && newEtag == etag {
+			continue
+		}
+
+		var parsed map[string]metadata.Disk
+		if err == nil {
+			parsed, err = parseDisks(resp)
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !failed {
+				logger.Errorf("Failed to watch %s: %v", disksKey, err)
+				failed = true
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(metadataRetryInterval):
+			}
+			continue
+		}
+
+		failed = false
+		etag = newEtag
+
+		// Only the latest disks matter, a pending previous version is replaced.
+		select {
+		case <-disks:
+		default:
+		}
+		disks <- parsed
+	}
+}
+
+// read reads the uevents until file is closed or fails, uevents is notified of
+// the block devices' ones.
+func (w *Watcher) read(file *os.File, uevents chan struct{}, done chan struct{}) {
+	var err error
+
+	defer func() {
+		file.Close()
+		w.mutex.Lock()
+		w.running = false
+		w.err = err
+		w.mutex.Unlock()
+		close(done)
+	}()
+
+	conn, err := file.SyscallConn()
+	if err != nil {
+		err = fmt.Errorf("failed to access uevent socket: %w", err)
+		return
+	}
+
+	buf := make([]byte, os.Getpagesize()*2)
+
+	for {
+		var n int
+		var recvErr error
+
+		err = conn.Read(func(fd uintptr) bool {
+			n, _, recvErr = unix.Recvfrom(int(fd), buf, 0)
+			return recvErr != unix.EAGAIN && recvErr != unix.EWOULDBLOCK
+		})
+
+		if err != nil {
+			// Closing the socket is how the reader is stopped.
+			if errors.Is(err, os.ErrClosed) {
+				err = nil
+			}
+			return
+		}
+
+		// A lost uevent is made up for by the next metadata change or uevent, the
+		// reconciliation doesn't depend on the uevents' content.
+		if errors.Is(recvErr, unix.ENOBUFS) {
+			logger.Warningf("Uevent socket buffer overrun, some block device changes were lost")
+		} else if recvErr != nil {
+			err = fmt.Errorf("failed to read uevent socket: %w", recvErr)
+			return
+		} else if props := parseUevent(buf[:n]); !isDiskUevent(props) {
+			continue
+		}
+
+		select {
+		case uevents <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// parseUevent returns the properties of a kernel or udev uevent message, nil if
+// the message is malformed.
+func parseUevent(msg []byte) map[string]string {
+	if bytes.HasPrefix(msg, []byte(udevPrefix)) {
+		if len(msg) < udevHeaderSize || binary.BigEndian.Uint32(msg[8:12]) != udevMagic {
+			return nil
+		}
+		offset := int(binary.LittleEndian.Uint32(msg[16:20]))
+		length := int(binary.LittleEndian.Uint32(msg[20:24]))
+		if offset < udevHeaderSize || offset+length > len(msg) {
+			return nil
+		}
+		msg = msg[offset : offset+length]
+	} else {
+		// Kernel uevents start with an action@devpath summary.
+		summary := bytes.IndexByte(msg, 0)
+		if summary < 0 || !bytes.Contains(msg[:summary], []byte("@")) {
+			return nil
+		}
+		msg = msg[summary+1:]
+	}
+
+	props := make(map[string]string)
+	for _, field := range strings.Split(string(msg), "\x00") {
+		if key, value, found := strings.Cut(field, "="); found {
+			props[key] = value
+		}
+	}
+	return props
+}
+
+// isDiskUevent returns true if props describe a whole block device uevent,
+// partitions are ignored.
+func isDiskUevent(props map[string]string) bool {
+	return props["SUBSYSTEM"] == "block" && props["DEVTYPE"] == "disk"
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+// mdsClient blocks WatchKey() until the context is done.
+type mdsClient struct{}
+
+func (mds *mdsClient) Get(ctx context.Context) (*metadata.Descriptor, error) {
+	return nil, fmt.Errorf("Get() not yet implemented")
+}
+
+func (mds *mdsClient) GetKey(ctx context.Context, key string, headers map[string]string) (string, error) {
+	return "", fmt.Errorf("GetKey() not yet implemented")
+}
+
+func (mds *mdsClient) GetKeyRecursive(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetKeyRecursive() not yet implemented")
+}
+
+func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
+	return nil, fmt.Errorf("Watch() not yet implemented")
+}
+
+func (mds *mdsClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	<-ctx.Done()
+	return "", "", ctx.Err()
+}
+
+func (mds *mdsClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
+	return fmt.Errorf("WriteGuestattributes() not yet implemented")
+}
+
+func (mds *mdsClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
+// udevMessage builds a udev uevent message carrying props.
+func udevMessage(props string) []byte {
+	header := make([]byte, 40)
+	copy(header, udevPrefix)
+	binary.BigEndian.PutUint32(header[8:12], udevMagic)
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(header)))
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(header)))
+	binary.LittleEndian.PutUint32(header[20:24], uint32(len(props)))
+	return append(header, props...)
+}
+
+func TestParseUevent(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		disk bool
+	}{
+		{
+			name: "kernel_disk",
+			msg:  []byte("add@/devices/virtual/block/sdb\x00ACTION=add\x00SUBSYSTEM=block\x00DEVNAME=sdb\x00DEVTYPE=disk\x00"),
+			disk: true,
+		},
+		{
+			name: "kernel_partition",
+			msg:  []byte("add@/devices/virtual/block/sdb/sdb1\x00ACTION=add\x00SUBSYSTEM=block\x00DEVNAME=sdb1\x00DEVTYPE=partition\x00"),
+		},
+		{
+			name: "kernel_net",
+			msg:  []byte("add@/devices/virtual/net/eth1\x00ACTION=add\x00SUBSYSTEM=net\x00INTERFACE=eth1\x00"),
+		},
+		{
+			name: "udev_disk",
+			msg:  udevMessage("ACTION=remove\x00SUBSYSTEM=block\x00DEVNAME=/dev/sdb\x00DEVTYPE=disk\x00"),
+			disk: true,
+		},
+		{
+			name: "udev_bad_magic",
+			msg:  append([]byte(udevPrefix), make([]byte, 32)...),
+		},
+		{
+			name: "udev_truncated",
+			msg:  udevMessage("SUBSYSTEM=block\x00DEVTYPE=disk\x00")[:50],
+		},
+		{
+			name: "garbage",
+			msg:  []byte("garbage"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isDiskUevent(parseUevent(tc.msg)); got != tc.disk {
+				t.Errorf("isDiskUevent(parseUevent(%q)) = %t, want %t", tc.msg, got, tc.disk)
+			}
+		})
+	}
+}
+
+func TestRunReaderExit(t *testing.T) {
+	watcher := newWatcher(&mdsClient{}, nil)
+
+	result := make(chan bool)
+	go func() {
+		renew, _, _ := watcher.Run(context.Background(), ChangedEvent)
+		result <- renew
+	}()
+
+	// Closing the socket stops the reader as a failure would.
+	for {
+		watcher.mutex.Lock()
+		cancel := watcher.cancel
+		watcher.mutex.Unlock()
+		if cancel != nil {
+			cancel()
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if renew := <-result; !renew {
+		t.Errorf("Run() returned renew = false after the reader exited, expected true so the watcher is started again.")
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	watcher := newWatcher(&mdsClient{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	renew, _, err := watcher.Run(ctx, AttachedEvent)
+	if err == nil {
+		t.Errorf("Run() succeeded, expected context cancellation error.")
+	}
+	if renew {
+		t.Errorf("Run() returned renew = true after cancellation, expected false.")
+	}
+}
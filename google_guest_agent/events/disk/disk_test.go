@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+func TestParseDisks(t *testing.T) {
+	resp := `[{"deviceName":"persistent-disk-0","index":0,"interface":"SCSI","mode":"READ_WRITE","type":"PERSISTENT"},` +
+		`{"deviceName":"data","index":1,"interface":"NVME","mode":"READ_ONLY","type":"PERSISTENT"}]`
+
+	got, err := parseDisks(resp)
+	if err != nil {
+		t.Fatalf("parseDisks() failed: %v", err)
+	}
+
+	want := map[string]metadata.Disk{
+		"persistent-disk-0": {DeviceName: "persistent-disk-0", Index: 0, Interface: "SCSI", Mode: "READ_WRITE", Type: "PERSISTENT"},
+		"data":              {DeviceName: "data", Index: 1, Interface: "NVME", Mode: "READ_ONLY", Type: "PERSISTENT"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDisks() = %+v, want %+v", got, want)
+	}
+
+	if _, err := parseDisks("{"); err == nil {
+		t.Errorf("parseDisks() of invalid JSON succeeded, want error")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	devices := map[string]string{"boot": "/dev/sda"}
+	watcher := newWatcher(nil, func(name string) (string, bool) {
+		path, found := devices[name]
+		return path, found
+	})
+
+	type step struct {
+		name    string
+		disks   []string
+		devices map[string]string
+		want    []string
+		pending bool
+	}
+
+	steps := []step{
+		{
+			name:    "metadata_unknown",
+			devices: map[string]string{"boot": "/dev/sda"},
+		},
+		{
+			name:    "seeded",
+			disks:   []string{"boot"},
+			devices: map[string]string{"boot": "/dev/sda"},
+		},
+		{
+			name:    "listed_not_present",
+			disks:   []string{"boot", "data"},
+			devices: map[string]string{"boot": "/dev/sda"},
+			pending: true,
+		},
+		{
+			name:    "attached",
+			disks:   []string{"boot", "data"},
+			devices: map[string]string{"boot": "/dev/sda", "data": "/dev/sdb"},
+			want:    []string{"attached data /dev/sdb"},
+		},
+		{
+			name:    "unchanged",
+			disks:   []string{"boot", "data"},
+			devices: map[string]string{"boot": "/dev/sda", "data": "/dev/sdb"},
+		},
+		{
+			name:    "device_changed",
+			disks:   []string{"boot", "data"},
+			devices: map[string]string{"boot": "/dev/sda", "data": "/dev/sdc"},
+			want:    []string{"detached data /dev/sdb", "attached data /dev/sdc"},
+		},
+		{
+			name:    "device_removed",
+			disks:   []string{"boot", "data"},
+			devices: map[string]string{"boot": "/dev/sda"},
+			want:    []string{"detached data /dev/sdc"},
+		},
+		{
+			name:    "metadata_removed",
+			disks:   []string{"boot"},
+			devices: map[string]string{"boot": "/dev/sda"},
+		},
+	}
+
+	for i, st := range steps {
+		if i > 0 {
+			watcher.disks = make(map[string]metadata.Disk)
+			for _, name := range st.disks {
+				watcher.disks[name] = metadata.Disk{DeviceName: name, Mode: "READ_WRITE"}
+			}
+		}
+		devices = st.devices
+
+		events, pending := watcher.reconcile()
+
+		var got []string
+		for _, ev := range events {
+			evType := "attached"
+			if ev.evType == DetachedEvent {
+				evType = "detached"
+			}
+			got = append(got, evType+" "+ev.disk.DeviceName+" "+ev.disk.Path)
+		}
+
+		if !reflect.DeepEqual(got, st.want) || pending != st.pending {
+			t.Errorf("step %s: reconcile() = %v, %t, want %v, %t", st.name, got, pending, st.want, st.pending)
+		}
+	}
+}
+
+func TestDispatchChanges(t *testing.T) {
+	watcher := newWatcher(nil, nil)
+	oldDisk := &Disk{DeviceName: "data", Path: "/dev/sdb"}
+	newDisk := &Disk{DeviceName: "data", Path: "/dev/sdc"}
+
+	watcher.dispatch([]event{{DetachedEvent, oldDisk}, {AttachedEvent, newDisk}})
+
+	if got := <-watcher.queues[DetachedEvent]; got != oldDisk {
+		t.Errorf("%s queue got %s, want %s", DetachedEvent, got, oldDisk)
+	}
+	if got := <-watcher.queues[AttachedEvent]; got != newDisk {
+		t.Errorf("%s queue got %s, want %s", AttachedEvent, got, newDisk)
+	}
+
+	// Both changes go through the same queue, in order.
+	for _, want := range []Change{{DetachedEvent, oldDisk}, {AttachedEvent, newDisk}} {
+		if got := <-watcher.changes; *got != want {
+			t.Errorf("%s queue got %s, want %s", ChangedEvent, got, &want)
+		}
+	}
+}
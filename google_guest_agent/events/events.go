@@ -18,9 +18,13 @@ package events
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
@@ -46,6 +50,14 @@ type Watcher interface {
 	Run(ctx context.Context, evType string) (bool, interface{}, error)
 }
 
+// DescribedWatcher is a Watcher declaring the data type of its events, the typed
+// subscriptions (see Subscribe()) are checked against it when the watcher is added.
+type DescribedWatcher interface {
+	Watcher
+	// Descriptors returns the descriptors of the watcher's events.
+	Descriptors() []typed.Descriptor
+}
+
 // Manager defines the interface between events management layer and the
 // core guest agent implementation.
 type Manager struct {
@@ -78,6 +90,28 @@ type Manager struct {
 	// control go routines to leave(given we don't have any more job left to
 	// process).
 	queue *watcherQueue
+
+	// history keeps the most recent events and their subscribers' outcomes.
+	history *history
+
+	// pending counts the events queued to subscribers and not yet handled.
+	pending sync.WaitGroup
+
+	// dataTypes maps the event types to their data type, as declared by the watchers
+	// and the typed subscribers.
+	dataTypes map[string]reflect.Type
+
+	// dataTypesMutex protects dataTypes.
+	dataTypesMutex sync.Mutex
+
+	// health maps the watchers' event types to their health.
+	health map[string]*WatcherHealth
+
+	// backoffPolicies maps the watcher ids to their backoff policy.
+	backoffPolicies map[string]BackoffPolicy
+
+	// healthMutex protects health and backoffPolicies.
+	healthMutex sync.Mutex
 }
 
 // watcherQueue wraps the watchers <-> callbacks communication as well as the
@@ -132,12 +166,25 @@ type WatcherEventType struct {
 
 type eventSubscriber struct {
 	data interface{}
-	cb   *EventCb
+	cb   EventCb
+	// name is the callback's function name, used in the event history.
+	name string
+	// opts are the subscription options.
+	opts SubscribeOptions
+	// queue feeds the subscriber's workers, it's closed when the subscriber is stopped.
+	queue chan *subscriberEvent
+	// start starts the subscriber's workers on the first dispatched event.
+	start sync.Once
+	// stopped is true once the subscriber was stopped.
+	stopped atomic.Bool
+	// late is the number of timed out callback calls still running.
+	late atomic.Int32
 }
 
 type eventBusData struct {
-	evType string
-	data   *EventData
+	watcherID string
+	evType    string
+	data      *EventData
 }
 
 // EventCb defines the callback interface between watchers and subscribers. The arguments are:
@@ -189,6 +236,10 @@ func newManager() *Manager {
 		watchersMap:           make(map[string]bool),
 		removingWatcherEvents: make(map[string]bool),
 		subscribers:           make(map[string][]*eventSubscriber),
+		history:               newHistory(defaultHistorySize),
+		dataTypes:             map[string]reflect.Type{WatcherHealthEvent: WatcherHealthChanged.DataType()},
+		health:                make(map[string]*WatcherHealth),
+		backoffPolicies:       make(map[string]BackoffPolicy),
 		queue: &watcherQueue{
 			watchersMap:           make(map[string]bool),
 			dataBus:               make(chan eventBusData),
@@ -211,25 +262,28 @@ func Get() *Manager {
 	return instance
 }
 
+// Subscription is a registered subscriber, it's used to unsubscribe it.
+type Subscription struct {
+	mngr   *Manager
+	evType string
+	sub    *eventSubscriber
+}
+
 // Subscribe registers an event consumer/subscriber callback to a given event type, data
 // is a context pointer provided by the caller to be passed down when calling cb when
-// a new event happens.
-func (mngr *Manager) Subscribe(evType string, data interface{}, cb EventCb) {
-	mngr.subscribersMutex.Lock()
-	defer mngr.subscribersMutex.Unlock()
-	mngr.subscribers[evType] = append(mngr.subscribers[evType],
-		&eventSubscriber{
-			data: data,
-			cb:   &cb,
-		},
-	)
+// a new event happens. Each subscriber handles its events from its own queue, see
+// SubscribeWithOptions() for the defaults.
+func (mngr *Manager) Subscribe(evType string, data interface{}, cb EventCb) *Subscription {
+	return mngr.SubscribeWithOptions(evType, data, cb, SubscribeOptions{})
 }
 
-func (mngr *Manager) unsubscribe(evType string, cb *EventCb) {
+func (mngr *Manager) unsubscribe(evType string, sub *eventSubscriber) {
 	var keepMe []*eventSubscriber
 	for _, curr := range mngr.subscribers[evType] {
-		if curr.cb != cb {
+		if curr != sub {
 			keepMe = append(keepMe, curr)
+		} else {
+			curr.stop()
 		}
 	}
 
@@ -241,11 +295,46 @@ func (mngr *Manager) unsubscribe(evType string, cb *EventCb) {
 	}
 }
 
-// Unsubscribe removes the subscription of a given callback for a given event type.
-func (mngr *Manager) Unsubscribe(evType string, cb EventCb) {
+// Unsubscribe removes the subscription, the events already queued to the subscriber
+// are discarded. Unsubscribing more than once, or once the callback returned false, is
+// a no-op.
+func (s *Subscription) Unsubscribe() {
+	s.mngr.subscribersMutex.Lock()
+	defer s.mngr.subscribersMutex.Unlock()
+	s.mngr.unsubscribe(s.evType, s.sub)
+}
+
+// Watchers returns the registered watchers that were not removed nor finished.
+func (mngr *Manager) Watchers() []Watcher {
+	mngr.watchersMutex.Lock()
+	defer mngr.watchersMutex.Unlock()
+
+	var res []Watcher
+	seen := make(map[string]bool)
+	for _, curr := range mngr.watcherEvents {
+		id := curr.watcher.ID()
+		if seen[id] || mngr.removingWatcherEvents[curr.evType] {
+			continue
+		}
+		seen[id] = true
+		res = append(res, curr.watcher)
+	}
+	return res
+}
+
+// Subscribers returns the callback names of the subscribers of each event type, in
+// priority order.
+func (mngr *Manager) Subscribers() map[string][]string {
 	mngr.subscribersMutex.Lock()
 	defer mngr.subscribersMutex.Unlock()
-	mngr.unsubscribe(evType, &cb)
+
+	res := make(map[string][]string)
+	for evType, subscribers := range mngr.subscribers {
+		for _, curr := range subscribers {
+			res[evType] = append(res[evType], curr.name)
+		}
+	}
+	return res
 }
 
 // RemoveWatcher removes a watcher from the event manager. Each running watcher has its own
@@ -287,6 +376,12 @@ func (mngr *Manager) AddWatcher(ctx context.Context, watcher Watcher) error {
 		return fmt.Errorf("watcher(%s) was previously added", id)
 	}
 
+	if described, ok := watcher.(DescribedWatcher); ok {
+		if err := mngr.registerDescriptors(described); err != nil {
+			return fmt.Errorf("watcher(%s): %w", id, err)
+		}
+	}
+
 	// Add the watchers and its events to internal mappings.
 	evTypes := make(map[string]*WatcherEventType)
 	mngr.watchersMap[id] = true
@@ -346,13 +441,32 @@ func (mngr *Manager) runWatcher(ctx context.Context, watcher Watcher, evType str
 			break
 		}
 
+		health, delay := mngr.updateHealth(id, evType, err)
+		if health != nil {
+			mngr.queue.dataBus <- eventBusData{
+				watcherID: ManagerID,
+				evType:    WatcherHealthEvent,
+				data:      &EventData{Data: health},
+			}
+		}
+
 		mngr.queue.dataBus <- eventBusData{
-			evType: evType,
+			watcherID: id,
+			evType:    evType,
 			data: &EventData{
 				Data:  evData,
 				Error: err,
 			},
 		}
+
+		// Failing watchers are renewed with an increasing delay instead of right away.
+		if renew && delay > 0 {
+			logger.Debugf("Watcher(%s) failed, renewing event %s in %s", id, evType, delay)
+			select {
+			case <-nCtx.Done():
+			case <-time.After(delay):
+			}
+		}
 	}
 
 	logger.Debugf("watcher finishing: %s", evType)
@@ -403,7 +517,7 @@ func (mngr *Manager) Run(ctx context.Context) error {
 	}(ctx.Done(), queue.finishContextHandler, queue.finishCallbackHandler)
 
 	// Manages the event processing avoiding blocking the watcher's go routines.
-	// This will listen to dataBus and call the events handlers/callbacks.
+	// This will listen to dataBus and queue the events to the subscribers' workers.
 	wg.Add(1)
 	go func(bus <-chan eventBusData, finishCallbackHandler <-chan bool) {
 		defer wg.Done()
@@ -413,34 +527,9 @@ func (mngr *Manager) Run(ctx context.Context) error {
 			case <-finishCallbackHandler:
 				return
 			case busData := <-bus:
-				subscribers := mngr.subscribers[busData.evType]
-				if subscribers == nil {
-					logger.Debugf("No subscriber found for event: %s, returning.", busData.evType)
-					continue
-				}
-
-				deleteMe := make([]*eventSubscriber, 0)
-				for _, curr := range subscribers {
-					logger.Debugf("Running registered callback for event: %s", busData.evType)
-					renew := (*curr.cb)(ctx, busData.evType, curr.data, busData.data)
-					if !renew {
-						deleteMe = append(deleteMe, curr)
-					}
-					logger.Debugf("Returning from event %q subscribed callback, should renew?: %t", busData.evType, renew)
-				}
-
-				mngr.subscribersMutex.Lock()
-				for _, curr := range deleteMe {
-					mngr.unsubscribe(busData.evType, curr.cb)
-				}
-				leave := mngr.subscribers[busData.evType] == nil
-				mngr.subscribersMutex.Unlock()
-
-				// No more subscribers at all, we have nothing more left to do here.
-				if leave {
-					logger.Debugf("No subscribers left, leaving")
-					break
-				}
+				entry := newHistoryEntry(busData.watcherID, busData.evType, busData.data)
+				mngr.history.add(entry)
+				mngr.dispatch(ctx, busData.evType, busData.data, entry)
 			}
 		}
 	}(queue.dataBus, queue.finishCallbackHandler)
@@ -463,7 +552,11 @@ func (mngr *Manager) Run(ctx context.Context) error {
 		for len := queue.length(); len > 0; {
 			doneStr := <-queue.watcherDone
 			len = queue.del(doneStr)
-			delete(mngr.removingWatcherEvents, doneStr)
+			// Finished watchers are kept flagged as removed, removing them again (i.e. from a
+			// callback still handling one of their last events) must not block.
+			mngr.watchersMutex.Lock()
+			mngr.removingWatcherEvents[doneStr] = true
+			mngr.watchersMutex.Unlock()
 			if !queue.leaving && len == 0 {
 				logger.Debugf("All watchers are finished, signaling to leave.")
 				queue.finishContextHandler <- true
@@ -473,5 +566,10 @@ func (mngr *Manager) Run(ctx context.Context) error {
 	}()
 
 	wg.Wait()
+
+	// Let the subscribers finish handling the events already queued.
+	mngr.pending.Wait()
+	mngr.stopSubscribers()
+
 	return nil
 }
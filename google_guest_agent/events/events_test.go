@@ -17,6 +17,7 @@ package events
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -132,6 +133,35 @@ func TestUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	evType := "test-watcher,test-event"
+	eventManager := newManager()
+
+	// Subscriptions of the same callback are told apart.
+	var subscriptions []*Subscription
+	for i := 0; i < 2; i++ {
+		subscriptions = append(subscriptions, eventManager.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			return true
+		}))
+	}
+
+	subscriptions[0].Unsubscribe()
+	if got := len(eventManager.Subscribers()[evType]); got != 1 {
+		t.Fatalf("Got %d subscribers after unsubscribing one, expected 1", got)
+	}
+
+	if eventManager.subscribers[evType][0] != subscriptions[1].sub {
+		t.Errorf("Unsubscribe() removed the wrong subscriber")
+	}
+
+	// Unsubscribing again is a no-op.
+	subscriptions[0].Unsubscribe()
+	subscriptions[1].Unsubscribe()
+	if got := len(eventManager.Subscribers()[evType]); got != 0 {
+		t.Errorf("Got %d subscribers after unsubscribing all, expected 0", got)
+	}
+}
+
 func TestCancelBeforeCallbacks(t *testing.T) {
 	watcherID := "test-watcher"
 	timeout := (1 * time.Second) / 100
@@ -636,3 +666,43 @@ func TestCrossWatcherRemovalFromCallback(t *testing.T) {
 		t.Errorf("Failed running event manager, expected success, got error: %+v", err)
 	}
 }
+
+func TestWatchersAndSubscribers(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	first := &genericWatcher{watcherID: "first-watcher"}
+	second := &genericWatcher{watcherID: "second-watcher"}
+	for _, curr := range []Watcher{first, second} {
+		if err := eventManager.AddWatcher(ctx, curr); err != nil {
+			t.Fatalf("Failed to add watcher to event manager: %+v", err)
+		}
+	}
+
+	eventManager.Subscribe(first.eventID(), nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		return true
+	})
+
+	watchers := eventManager.Watchers()
+	if len(watchers) != 2 || watchers[0].ID() != first.ID() || watchers[1].ID() != second.ID() {
+		t.Errorf("Watchers() returned %d watchers, expected %s and %s", len(watchers), first.ID(), second.ID())
+	}
+
+	subscribers := eventManager.Subscribers()
+	if len(subscribers) != 1 || len(subscribers[first.eventID()]) != 1 {
+		t.Fatalf("Subscribers() = %v, expected a single subscriber of %s", subscribers, first.eventID())
+	}
+
+	if name := subscribers[first.eventID()][0]; !strings.Contains(name, "TestWatchersAndSubscribers") {
+		t.Errorf("Subscribers() returned callback name %q, expected the test callback", name)
+	}
+
+	// Finished watchers are not reported.
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if watchers := eventManager.Watchers(); len(watchers) != 0 {
+		t.Errorf("Watchers() returned %d watchers after they finished, expected none", len(watchers))
+	}
+}
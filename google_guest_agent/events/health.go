@@ -0,0 +1,211 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// ManagerID is the id the events manager emits its own events with.
+	ManagerID = "events-manager"
+	// WatcherHealthEvent is emitted when the health state of a watcher's event type
+	// changes, its event data is a *WatcherHealth.
+	WatcherHealthEvent = "events-manager,watcher-health"
+)
+
+// WatcherHealthChanged describes WatcherHealthEvent.
+var WatcherHealthChanged = typed.New[*WatcherHealth](WatcherHealthEvent)
+
+// HealthState is the health state of a watcher's event type.
+type HealthState int
+
+const (
+	// Healthy watchers' last run succeeded.
+	Healthy HealthState = iota
+	// Degraded watchers failed less than BackoffPolicy.FailingAfter times in a row.
+	Degraded
+	// Failing watchers failed at least BackoffPolicy.FailingAfter times in a row.
+	Failing
+)
+
+// String returns the health state's name.
+func (s HealthState) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Failing:
+		return "failing"
+	default:
+		return "unknown"
+	}
+}
+
+// BackoffPolicy configures how the renewal of a failing watcher is delayed and
+// when it's considered failing.
+type BackoffPolicy struct {
+	// InitialDelay is the delay after the first failure, it's multiplied by Factor
+	// for every following consecutive failure.
+	InitialDelay time.Duration
+	// MaxDelay bounds the delay.
+	MaxDelay time.Duration
+	// Factor is the delay's growth factor.
+	Factor float64
+	// Jitter is the fraction of the delay randomly added or removed, so watchers
+	// failing together don't retry at once.
+	Jitter float64
+	// FailingAfter is the number of consecutive failures after which a watcher is
+	// failing instead of degraded.
+	FailingAfter int
+}
+
+// DefaultBackoffPolicy is the backoff policy of the watchers without one set with
+// SetBackoffPolicy().
+var DefaultBackoffPolicy = BackoffPolicy{
+	InitialDelay: time.Second,
+	MaxDelay:     2 * time.Minute,
+	Factor:       2,
+	Jitter:       0.2,
+	FailingAfter: 5,
+}
+
+// delay returns the delay before renewing a watcher after failures consecutive
+// failures.
+func (p BackoffPolicy) delay(failures int) time.Duration {
+	if failures <= 0 || p.InitialDelay <= 0 {
+		return 0
+	}
+
+	factor := p.Factor
+	if factor < 1 {
+		factor = 1
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(factor, float64(failures-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// state returns the health state after failures consecutive failures.
+func (p BackoffPolicy) state(failures int) HealthState {
+	switch {
+	case failures == 0:
+		return Healthy
+	case failures < p.FailingAfter:
+		return Degraded
+	default:
+		return Failing
+	}
+}
+
+// WatcherHealth is the health of a watcher's event type.
+type WatcherHealth struct {
+	// WatcherID is the watcher's id.
+	WatcherID string
+	// EventType is the watcher's event type.
+	EventType string
+	// State is the current health state.
+	State HealthState
+	// Previous is the health state before the last change.
+	Previous HealthState
+	// Failures is the number of consecutive failures.
+	Failures int
+	// LastError is the error of the last failure, empty if healthy.
+	LastError string
+	// Since is when the current state was entered.
+	Since time.Time
+}
+
+// SetBackoffPolicy sets the backoff policy of the watcher identified by id, it
+// applies from the watcher's next failure on.
+func (mngr *Manager) SetBackoffPolicy(id string, policy BackoffPolicy) {
+	mngr.healthMutex.Lock()
+	defer mngr.healthMutex.Unlock()
+	mngr.backoffPolicies[id] = policy
+}
+
+// WatchersHealth returns the health of the watchers' event types that ran at least
+// once, ordered by event type.
+func (mngr *Manager) WatchersHealth() []WatcherHealth {
+	mngr.healthMutex.Lock()
+	defer mngr.healthMutex.Unlock()
+
+	var res []WatcherHealth
+	for _, curr := range mngr.health {
+		res = append(res, *curr)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].EventType < res[j].EventType })
+	return res
+}
+
+// updateHealth records the outcome of a run of the watcher id's evType. It returns
+// the new health if its state changed, nil otherwise, and how long to wait before
+// renewing the watcher.
+func (mngr *Manager) updateHealth(id, evType string, err error) (*WatcherHealth, time.Duration) {
+	mngr.healthMutex.Lock()
+	defer mngr.healthMutex.Unlock()
+
+	policy, found := mngr.backoffPolicies[id]
+	if !found {
+		policy = DefaultBackoffPolicy
+	}
+
+	health, found := mngr.health[evType]
+	if !found {
+		health = &WatcherHealth{WatcherID: id, EventType: evType, Since: time.Now()}
+		mngr.health[evType] = health
+	}
+
+	if err == nil {
+		health.Failures = 0
+		health.LastError = ""
+	} else {
+		health.Failures++
+		health.LastError = err.Error()
+	}
+
+	var changed *WatcherHealth
+	if state := policy.state(health.Failures); state != health.State {
+		health.Previous, health.State = health.State, state
+		health.Since = time.Now()
+
+		snapshot := *health
+		changed = &snapshot
+
+		if state == Healthy {
+			logger.Infof("Watcher %s is healthy again, event: %s", id, evType)
+		} else {
+			logger.Warningf("Watcher %s is %s after %d consecutive failure(s), event: %s: %v", id, state, health.Failures, evType, err)
+		}
+	}
+
+	return changed, policy.delay(health.Failures)
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	policy := BackoffPolicy{InitialDelay: time.Second, MaxDelay: 10 * time.Second, Factor: 2, Jitter: 0.2}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("failures_%d", tc.failures), func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				got := policy.delay(tc.failures)
+				min, max := time.Duration(float64(tc.want)*0.8), time.Duration(float64(tc.want)*1.2)
+				if got < min || got > max {
+					t.Fatalf("delay(%d) = %s, want between %s and %s", tc.failures, got, min, max)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffPolicyState(t *testing.T) {
+	policy := BackoffPolicy{FailingAfter: 3}
+
+	want := []HealthState{Healthy, Degraded, Degraded, Failing, Failing}
+	for failures, state := range want {
+		if got := policy.state(failures); got != state {
+			t.Errorf("state(%d) = %s, want %s", failures, got, state)
+		}
+	}
+}
+
+// flakyWatcher fails failures times, then succeeds once and gives up.
+type flakyWatcher struct {
+	failures int
+	runs     int
+}
+
+func (fw *flakyWatcher) ID() string {
+	return "flaky-watcher"
+}
+
+func (fw *flakyWatcher) Events() []string {
+	return []string{"flaky-watcher,test-event"}
+}
+
+func (fw *flakyWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	fw.runs++
+	if fw.runs <= fw.failures {
+		return true, nil, fmt.Errorf("failure %d", fw.runs)
+	}
+	return false, nil, nil
+}
+
+func TestWatcherHealth(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	watcher := &flakyWatcher{failures: 3}
+	eventManager.SetBackoffPolicy(watcher.ID(), BackoffPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     20 * time.Millisecond,
+		Factor:       2,
+		FailingAfter: 2,
+	})
+
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var mutex sync.Mutex
+	var got []string
+	_, err := Subscribe(eventManager, WatcherHealthChanged, func(ctx context.Context, evType string, health *WatcherHealth, err error) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		got = append(got, fmt.Sprintf("%s->%s:%d", health.Previous, health.State, health.Failures))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() failed: %v", err)
+	}
+
+	start := time.Now()
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	// Delays: 10ms, 20ms and 20ms (capped).
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Run() took %s, expected the failures to be delayed by at least 50ms", elapsed)
+	}
+
+	want := []string{"healthy->degraded:1", "degraded->failing:2", "failing->healthy:0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("watcher health events = %v, want %v", got, want)
+	}
+
+	health := eventManager.WatchersHealth()
+	if len(health) != 1 || health[0].State != Healthy || health[0].EventType != "flaky-watcher,test-event" {
+		t.Errorf("WatchersHealth() = %+v, want a single healthy flaky-watcher,test-event", health)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistorySize is the number of events kept in the event history.
+	defaultHistorySize = 128
+	// maxHistoryDataLen is the maximum length of the recorded event data.
+	maxHistoryDataLen = 256
+)
+
+// HistoryEntry is the record of an event dispatched by the manager.
+type HistoryEntry struct {
+	// WatcherID is the id of the watcher that emitted the event.
+	WatcherID string
+	// EventType is the event type.
+	EventType string
+	// Time is when the event was received by the manager.
+	Time time.Time
+	// Data is a short summary of the event data, truncated to maxHistoryDataLen, see
+	// dataSummary().
+	Data string `json:",omitempty"`
+	// Error is the error reported by the watcher, if any.
+	Error string `json:",omitempty"`
+	// Subscribers are the outcomes of the subscribers called for the event in
+	// the order they were called.
+	Subscribers []SubscriberRecord `json:",omitempty"`
+}
+
+// SubscriberRecord is the outcome of a subscriber call.
+type SubscriberRecord struct {
+	// Name is the subscriber's callback function name.
+	Name string
+	// Renew is the callback's return value, false means it was unsubscribed.
+	Renew bool
+	// Duration is how long the callback took, or how long it was waited for if
+	// it timed out.
+	Duration time.Duration
+	// TimedOut is true if the callback didn't return within its deadline.
+	TimedOut bool `json:",omitempty"`
+	// Panicked is true if the callback panicked.
+	Panicked bool `json:",omitempty"`
+	// Dropped is true if the subscriber's queue was full and the callback wasn't
+	// called.
+	Dropped bool `json:",omitempty"`
+	// Skipped is true if the callback wasn't called because a timed out call of
+	// the subscriber was still running.
+	Skipped bool `json:",omitempty"`
+	// Coalesced is true if the callback wasn't called because the debounced
+	// subscriber got a later event.
+	Coalesced bool `json:",omitempty"`
+}
+
+// history is a bounded ring buffer of the most recent events.
+type history struct {
+	// mutex protects all the history members.
+	mutex sync.Mutex
+	// entries is the ring buffer storage, its length is the history size.
+	entries []*HistoryEntry
+	// next is the index the next entry will be stored at.
+	next int
+	// full is true once the ring buffer has wrapped around.
+	full bool
+}
+
+// newHistory allocates a history keeping the last size events.
+func newHistory(size int) *history {
+	return &history{entries: make([]*HistoryEntry, size)}
+}
+
+// add records entry, overwriting the oldest one if the history is full. The
+// subscribers' outcomes are recorded later with record().
+func (h *history) add(entry *HistoryEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.entries) == 0 {
+		return
+	}
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// record adds the outcome of a subscriber call to entry.
+func (h *history) record(entry *HistoryEntry, rec SubscriberRecord) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	entry.Subscribers = append(entry.Subscribers, rec)
+}
+
+// ordered returns the recorded entries, oldest first. The caller must hold mutex.
+func (h *history) ordered() []*HistoryEntry {
+	var res []*HistoryEntry
+	if h.full {
+		res = append(res, h.entries[h.next:]...)
+	}
+	return append(res, h.entries[:h.next]...)
+}
+
+// list returns a copy of the recorded entries, oldest first.
+func (h *history) list() []HistoryEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var res []HistoryEntry
+	for _, entry := range h.ordered() {
+		curr := *entry
+		curr.Subscribers = append([]SubscriberRecord(nil), entry.Subscribers...)
+		res = append(res, curr)
+	}
+	return res
+}
+
+// resize changes the history size keeping the most recent entries.
+func (h *history) resize(size int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entries := h.ordered()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+
+	h.entries = make([]*HistoryEntry, size)
+	h.next = copy(h.entries, entries)
+	h.full = false
+	if size > 0 && h.next == size {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// History returns the most recent events dispatched by the manager, oldest first.
+func (mngr *Manager) History() []HistoryEntry {
+	return mngr.history.list()
+}
+
+// SetHistorySize sets how many events are kept in the event history, a size of
+// zero disables it.
+func (mngr *Manager) SetHistorySize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("invalid event history size: %d", size)
+	}
+	mngr.history.resize(size)
+	return nil
+}
+
+// newHistoryEntry initializes the history entry of the event evType emitted by
+// watcherID.
+func newHistoryEntry(watcherID string, evType string, evData *EventData) *HistoryEntry {
+	entry := &HistoryEntry{
+		WatcherID: watcherID,
+		EventType: evType,
+		Time:      time.Now(),
+	}
+
+	if evData.Data != nil {
+		entry.Data = dataSummary(evData.Data)
+		if len(entry.Data) > maxHistoryDataLen {
+			entry.Data = entry.Data[:maxHistoryDataLen] + "..."
+		}
+	}
+
+	if evData.Error != nil {
+		entry.Error = evData.Error.Error()
+	}
+
+	return entry
+}
+
+// Summarizer is optionally implemented by event data to control how it's summarized
+// in the event history, i.e. to leave out values that could be sensitive.
+type Summarizer interface {
+	// Summary returns a short description of the data.
+	Summary() string
+}
+
+// dataSummary returns the history summary of the event data data: its Summary() or
+// String() if implemented, otherwise its type name. The data itself is not formatted
+// as it may be large and hold sensitive values, i.e. a metadata descriptor.
+func dataSummary(data interface{}) string {
+	switch v := data.(type) {
+	case Summarizer:
+		return v.Summary()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprintf("%T", data)
+	}
+}
+
+// callbackName returns the function name of cb, a callback function of any type.
+func callbackName(cb interface{}) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(cb).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
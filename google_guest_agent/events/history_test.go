@@ -0,0 +1,212 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRing(t *testing.T) {
+	tests := []struct {
+		desc  string
+		size  int
+		added int
+		want  []string
+	}{
+		{desc: "empty", size: 3},
+		{desc: "partial", size: 3, added: 2, want: []string{"ev-0", "ev-1"}},
+		{desc: "full", size: 3, added: 3, want: []string{"ev-0", "ev-1", "ev-2"}},
+		{desc: "wrapped", size: 3, added: 5, want: []string{"ev-2", "ev-3", "ev-4"}},
+		{desc: "disabled", size: 0, added: 5},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			h := newHistory(tc.size)
+			for i := 0; i < tc.added; i++ {
+				h.add(&HistoryEntry{EventType: fmt.Sprintf("ev-%d", i)})
+			}
+
+			var got []string
+			for _, entry := range h.list() {
+				got = append(got, entry.EventType)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("list() returned %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHistoryResize(t *testing.T) {
+	tests := []struct {
+		desc string
+		size int
+		want []string
+	}{
+		{desc: "shrink", size: 2, want: []string{"ev-3", "ev-4"}},
+		{desc: "same", size: 3, want: []string{"ev-2", "ev-3", "ev-4"}},
+		{desc: "grow", size: 5, want: []string{"ev-2", "ev-3", "ev-4", "ev-5", "ev-6"}},
+		{desc: "disable", size: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			h := newHistory(3)
+			for i := 0; i < 5; i++ {
+				h.add(&HistoryEntry{EventType: fmt.Sprintf("ev-%d", i)})
+			}
+
+			h.resize(tc.size)
+			// Entries added after resizing must keep the order.
+			if tc.size > 3 {
+				h.add(&HistoryEntry{EventType: "ev-5"})
+				h.add(&HistoryEntry{EventType: "ev-6"})
+			}
+
+			var got []string
+			for _, entry := range h.list() {
+				got = append(got, entry.EventType)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("list() returned %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetHistorySizeInvalid(t *testing.T) {
+	if err := newManager().SetHistorySize(-1); err == nil {
+		t.Errorf("SetHistorySize(-1) succeeded, expected error")
+	}
+}
+
+// testStringer is event data implementing fmt.Stringer.
+type testStringer string
+
+func (s testStringer) String() string {
+	return string(s)
+}
+
+// testSummarizer is event data implementing both Summarizer and fmt.Stringer.
+type testSummarizer struct {
+	testStringer
+}
+
+func (s testSummarizer) Summary() string {
+	return "summary"
+}
+
+func TestDataSummary(t *testing.T) {
+	var tests = []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{"summarizer", testSummarizer{"string"}, "summary"},
+		{"stringer", testStringer("string"), "string"},
+		{"struct", &struct{ Secret string }{"secret"}, "*struct { Secret string }"},
+		{"map", map[string]string{"ssh-keys": "key"}, "map[string]string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dataSummary(tt.data); got != tt.want {
+				t.Errorf("dataSummary(%v) = %q, expected %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHistoryEntry(t *testing.T) {
+	data := testStringer(strings.Repeat("x", maxHistoryDataLen*2))
+	entry := newHistoryEntry("watcher", "watcher,event", &EventData{Data: data, Error: errors.New("failed")})
+
+	if entry.WatcherID != "watcher" || entry.EventType != "watcher,event" {
+		t.Errorf("newHistoryEntry() = %+v, expected watcher and watcher,event ids", entry)
+	}
+
+	if len(entry.Data) != maxHistoryDataLen+len("...") {
+		t.Errorf("newHistoryEntry() data has length %d, expected it truncated to %d", len(entry.Data), maxHistoryDataLen)
+	}
+
+	if entry.Error != "failed" {
+		t.Errorf("newHistoryEntry() error = %q, expected %q", entry.Error, "failed")
+	}
+}
+
+func TestRunRecordsHistory(t *testing.T) {
+	watcherID := "test-watcher"
+	maxCount := 5
+	unsubscribeAt := 3
+
+	ctx := context.Background()
+	eventManager := newManager()
+
+	err := eventManager.AddWatcher(ctx, &testWatcher{
+		watcherID: watcherID,
+		maxCount:  maxCount,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	counter := 0
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		counter++
+		return counter < unsubscribeAt
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event managed, expected success, got error: %+v", err)
+	}
+
+	entries := eventManager.History()
+	if len(entries) != maxCount {
+		t.Fatalf("History() returned %d entries, expected %d", len(entries), maxCount)
+	}
+
+	for i, entry := range entries {
+		if entry.WatcherID != watcherID || entry.EventType != "test-watcher,test-event" {
+			t.Errorf("History()[%d] = %+v, expected watcher %s events", i, entry, watcherID)
+		}
+
+		if i >= unsubscribeAt {
+			if len(entry.Subscribers) != 0 {
+				t.Errorf("History()[%d] has subscribers %+v, expected none after unsubscribing", i, entry.Subscribers)
+			}
+			continue
+		}
+
+		if len(entry.Subscribers) != 1 {
+			t.Fatalf("History()[%d] has %d subscribers, expected 1", i, len(entry.Subscribers))
+		}
+
+		record := entry.Subscribers[0]
+		if wantRenew := i < unsubscribeAt-1; record.Renew != wantRenew {
+			t.Errorf("History()[%d] subscriber renew = %t, expected %t", i, record.Renew, wantRenew)
+		}
+
+		if !strings.Contains(record.Name, "TestRunRecordsHistory") {
+			t.Errorf("History()[%d] subscriber name = %q, expected the test callback", i, record.Name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maintenance implements the host maintenance events watcher.
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the maintenance watcher's ID.
+	WatcherID = "maintenance-watcher"
+	// BeforeMigrationEvent is emitted when the instance is about to be live migrated.
+	BeforeMigrationEvent = "maintenance-watcher,before-migration"
+	// AfterMigrationEvent is emitted once the instance was live migrated.
+	AfterMigrationEvent = "maintenance-watcher,after-migration"
+	// BeforeTerminationEvent is emitted when the instance is about to be terminated
+	// for a host maintenance.
+	BeforeTerminationEvent = "maintenance-watcher,before-termination"
+
+	// maintenanceKey is the metadata key announcing the host maintenance events.
+	maintenanceKey = "instance/maintenance-event"
+
+	// NoMaintenance is the maintenance event value when no maintenance is pending.
+	NoMaintenance = "NONE"
+	// MigrateOnHostMaintenance is the maintenance event value announcing a live migration.
+	MigrateOnHostMaintenance = "MIGRATE_ON_HOST_MAINTENANCE"
+	// TerminateOnHostMaintenance is the maintenance event value announcing a termination.
+	TerminateOnHostMaintenance = "TERMINATE_ON_HOST_MAINTENANCE"
+)
+
+var (
+	// BeforeMigration describes BeforeMigrationEvent.
+	BeforeMigration = typed.New[*Event](BeforeMigrationEvent)
+	// AfterMigration describes AfterMigrationEvent.
+	AfterMigration = typed.New[*Event](AfterMigrationEvent)
+	// BeforeTermination describes BeforeTerminationEvent.
+	BeforeTermination = typed.New[*Event](BeforeTerminationEvent)
+
+	// retryInterval is how long the watcher waits before querying the metadata
+	// server again after a failure.
+	retryInterval = 5 * time.Second
+)
+
+// Event is the event data of the maintenance events.
+type Event struct {
+	// Value is the maintenance event value, i.e. MIGRATE_ON_HOST_MAINTENANCE.
+	Value string
+	// Previous is the value it replaced, empty if the agent just started.
+	Previous string
+	// Time is when the change was detected.
+	Time time.Time
+}
+
+// String returns a human readable representation of the event.
+func (ev *Event) String() string {
+	return fmt.Sprintf("%s -> %s", ev.Previous, ev.Value)
+}
+
+// Watcher is the maintenance event watcher implementation, it longpolls the
+// instance's maintenance-event metadata key.
+type Watcher struct {
+	client metadata.MDSClientInterface
+
+	// start launches the longpoll go routine once.
+	start sync.Once
+	// queues maps the event types to the channels their events are queued to.
+	queues map[string]chan *Event
+}
+
+// New allocates and initializes a new Watcher.
+func New() *Watcher {
+	return newWatcher(metadata.New())
+}
+
+func newWatcher(client metadata.MDSClientInterface) *Watcher {
+	watcher := &Watcher{
+		client: client,
+		queues: make(map[string]chan *Event),
+	}
+
+	for _, curr := range watcher.Events() {
+		watcher.queues[curr] = make(chan *Event, 1)
+	}
+
+	return watcher
+}
+
+// ID returns the maintenance event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{BeforeMigrationEvent, AfterMigrationEvent, BeforeTerminationEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{BeforeMigration, AfterMigration, BeforeTermination}
+}
+
+// Run waits for the next maintenance event of evType and reports it back. The
+// longpoll is started by the first call and stops once its context is done.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	queue, found := w.queues[evType]
+	if !found {
+		return false, nil, fmt.Errorf("maintenance watcher: unknown event type %q", evType)
+	}
+
+	w.start.Do(func() { go w.poll(ctx) })
+
+	select {
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	case ev := <-queue:
+		return true, ev, nil
+	}
+}
+
+// poll longpolls the maintenance event key until ctx is done, queuing an event for
+// every transition.
+func (w *Watcher) poll(ctx context.Context) {
+	var etag, last string
+	var failed bool
+
+	for ctx.Err() == nil {
+		resp, newEtag, err := w.client.WatchKey(ctx, maintenanceKey, etag)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !failed {
+				logger.Errorf("Failed to watch %s: %v", maintenanceKey, err)
+				failed = true
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		failed = false
+		etag = newEtag
+		value := parseValue(resp)
+
+		for _, evType := range transitions(last, value) {
+			ev := &Event{Value: value, Previous: last, Time: time.Now()}
+			logger.Infof("Host maintenance event: %s", ev)
+			select {
+			case w.queues[evType] <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+		last = value
+	}
+}
+
+// parseValue returns the maintenance event value of the JSON formatted resp.
+func parseValue(resp string) string {
+	var value string
+	if err := json.Unmarshal([]byte(resp), &value); err != nil {
+		value = strings.TrimSpace(resp)
+	}
+	return value
+}
+
+// transitions returns the event types triggered by the maintenance event value
+// changing from prev to curr.
+func transitions(prev, curr string) []string {
+	if prev == curr {
+		return nil
+	}
+
+	var res []string
+	if prev == MigrateOnHostMaintenance {
+		res = append(res, AfterMigrationEvent)
+	}
+
+	switch curr {
+	case MigrateOnHostMaintenance:
+		res = append(res, BeforeMigrationEvent)
+	case TerminateOnHostMaintenance:
+		res = append(res, BeforeTerminationEvent)
+	}
+
+	return res
+}
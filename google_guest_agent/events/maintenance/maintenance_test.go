@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+type mdsClient struct {
+	// values are returned by successive WatchKey() calls, once exhausted WatchKey()
+	// blocks until the context is done.
+	values []string
+}
+
+func (mds *mdsClient) Get(ctx context.Context) (*metadata.Descriptor, error) {
+	return nil, fmt.Errorf("Get() not yet implemented")
+}
+
+func (mds *mdsClient) GetKey(ctx context.Context, key string, headers map[string]string) (string, error) {
+	return "", fmt.Errorf("GetKey() not yet implemented")
+}
+
+func (mds *mdsClient) GetKeyRecursive(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetKeyRecursive() not yet implemented")
+}
+
+func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
+	return nil, fmt.Errorf("Watch() not yet implemented")
+}
+
+func (mds *mdsClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	if key != maintenanceKey {
+		return "", "", fmt.Errorf("unexpected key %q", key)
+	}
+	if len(mds.values) == 0 {
+		<-ctx.Done()
+		return "", "", ctx.Err()
+	}
+	value := mds.values[0]
+	mds.values = mds.values[1:]
+	return fmt.Sprintf("%q", value), value, nil
+}
+
+func (mds *mdsClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
+	return fmt.Errorf("WriteGuestattributes() not yet implemented")
+}
+
+func (mds *mdsClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		prev string
+		curr string
+		want []string
+	}{
+		{"", NoMaintenance, nil},
+		{NoMaintenance, NoMaintenance, nil},
+		{NoMaintenance, MigrateOnHostMaintenance, []string{BeforeMigrationEvent}},
+		{"", MigrateOnHostMaintenance, []string{BeforeMigrationEvent}},
+		{MigrateOnHostMaintenance, NoMaintenance, []string{AfterMigrationEvent}},
+		{NoMaintenance, TerminateOnHostMaintenance, []string{BeforeTerminationEvent}},
+		{MigrateOnHostMaintenance, TerminateOnHostMaintenance, []string{AfterMigrationEvent, BeforeTerminationEvent}},
+		{TerminateOnHostMaintenance, NoMaintenance, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.prev+"_"+tc.curr, func(t *testing.T) {
+			if got := transitions(tc.prev, tc.curr); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("transitions(%q, %q) = %v, want %v", tc.prev, tc.curr, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		resp string
+		want string
+	}{
+		{`"NONE"`, NoMaintenance},
+		{`"MIGRATE_ON_HOST_MAINTENANCE"`, MigrateOnHostMaintenance},
+		{"TERMINATE_ON_HOST_MAINTENANCE\n", TerminateOnHostMaintenance},
+	}
+
+	for _, tc := range tests {
+		if got := parseValue(tc.resp); got != tc.want {
+			t.Errorf("parseValue(%q) = %q, want %q", tc.resp, got, tc.want)
+		}
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := newWatcher(&mdsClient{values: []string{NoMaintenance, MigrateOnHostMaintenance, NoMaintenance}})
+
+	for _, evType := range []string{BeforeMigrationEvent, AfterMigrationEvent} {
+		renew, data, err := watcher.Run(ctx, evType)
+		if err != nil || !renew {
+			t.Fatalf("Run(%s) = %t, %v, expected renew without error", evType, renew, err)
+		}
+
+		ev, ok := data.(*Event)
+		if !ok {
+			t.Fatalf("Run(%s) returned data of type %T, expected *Event", evType, data)
+		}
+
+		if evType == BeforeMigrationEvent && (ev.Previous != NoMaintenance || ev.Value != MigrateOnHostMaintenance) {
+			t.Errorf("Run(%s) = %s, expected NONE -> MIGRATE_ON_HOST_MAINTENANCE", evType, ev)
+		}
+
+		if evType == AfterMigrationEvent && (ev.Previous != MigrateOnHostMaintenance || ev.Value != NoMaintenance) {
+			t.Errorf("Run(%s) = %s, expected MIGRATE_ON_HOST_MAINTENANCE -> NONE", evType, ev)
+		}
+	}
+
+	cancel()
+	if renew, _, err := watcher.Run(ctx, BeforeTerminationEvent); renew || err == nil {
+		t.Errorf("Run() with a canceled context = %t, %v, expected no renew and an error", renew, err)
+	}
+
+	if _, _, err := watcher.Run(ctx, "unknown"); err == nil {
+		t.Errorf("Run() with an unknown event type succeeded, expected error")
+	}
+}
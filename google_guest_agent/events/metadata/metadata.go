@@ -19,7 +19,9 @@ import (
 	"context"
 	"net"
 	"net/url"
+	"sync"
 
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
@@ -29,18 +31,45 @@ const (
 	WatcherID = "metadata-watcher"
 	// LongpollEvent is the metadata's longpoll event type ID.
 	LongpollEvent = "metadata-watcher,longpoll"
+	// ChangeEvent is the metadata's per-key change event type ID, it's emitted once for
+	// every value changed by a longpoll update with a *metadata.Change as event data.
+	ChangeEvent = "metadata-watcher,change"
+)
+
+var (
+	// Longpoll describes LongpollEvent, its event data is the *metadata.Descriptor
+	// returned by the longpoll.
+	Longpoll = typed.New[*metadata.Descriptor](LongpollEvent)
+	// Change describes ChangeEvent.
+	Change = typed.New[*metadata.Change](ChangeEvent)
 )
 
 // Watcher is the metadata event watcher implementation.
 type Watcher struct {
 	client         metadata.MDSClientInterface
 	failedPrevious bool
+
+	// last is the last descriptor returned by the longpoll, changes are computed
+	// against it. No change is emitted for the first descriptor.
+	last *metadata.Descriptor
+
+	// changesMutex protects changes.
+	changesMutex sync.Mutex
+	// changes are the changes not yet emitted as ChangeEvent.
+	changes []metadata.Change
+	// changesReady notifies the ChangeEvent runner that changes were queued.
+	changesReady chan struct{}
 }
 
 // New allocates and initializes a new Watcher.
 func New() *Watcher {
+	client := metadata.New()
+	// Keep the last known good metadata on disk for offline boots.
+	client.SetCache(metadata.NewCache(""))
+
 	return &Watcher{
-		client: metadata.New(),
+		client:       client,
+		changesReady: make(chan struct{}, 1),
 	}
 }
 
@@ -51,11 +80,29 @@ func (mp *Watcher) ID() string {
 
 // Events returns an slice with all implemented events.
 func (mp *Watcher) Events() []string {
-	return []string{LongpollEvent}
+	return []string{LongpollEvent, ChangeEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (mp *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Longpoll, Change}
+}
+
+// ETag returns the etag of the last metadata returned by the longpoll, empty if the
+// client doesn't track it.
+func (mp *Watcher) ETag() string {
+	if client, ok := mp.client.(interface{ ETag() string }); ok {
+		return client.ETag()
+	}
+	return ""
 }
 
 // Run listens to metadata changes and report back the event.
 func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	if evType == ChangeEvent {
+		return mp.nextChange(ctx)
+	}
+
 	descriptor, err := mp.client.Watch(ctx)
 	if err != nil {
 		// Only log error once to avoid transient errors and not to spam the log on network failures.
@@ -70,7 +117,56 @@ func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, e
 		}
 	} else {
 		mp.failedPrevious = false
+		mp.queueChanges(descriptor)
 	}
 
 	return true, descriptor, err
 }
+
+// queueChanges queues the changes between the last and the new descriptor to be
+// emitted as ChangeEvent.
+func (mp *Watcher) queueChanges(descriptor *metadata.Descriptor) {
+	if descriptor == nil {
+		return
+	}
+
+	last := mp.last
+	mp.last = descriptor
+	if last == nil {
+		return
+	}
+
+	changes := last.Diff(descriptor)
+	if len(changes) == 0 {
+		return
+	}
+
+	mp.changesMutex.Lock()
+	mp.changes = append(mp.changes, changes...)
+	mp.changesMutex.Unlock()
+
+	select {
+	case mp.changesReady <- struct{}{}:
+	default:
+	}
+}
+
+// nextChange blocks until a change is queued and returns it.
+func (mp *Watcher) nextChange(ctx context.Context) (bool, interface{}, error) {
+	for {
+		mp.changesMutex.Lock()
+		if len(mp.changes) > 0 {
+			change := mp.changes[0]
+			mp.changes = mp.changes[1:]
+			mp.changesMutex.Unlock()
+			return true, &change, nil
+		}
+		mp.changesMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		case <-mp.changesReady:
+		}
+	}
+}
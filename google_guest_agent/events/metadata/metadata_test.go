@@ -29,6 +29,8 @@ var (
 
 type mdsClient struct {
 	disableUnknownFailure bool
+	// descriptors are returned by successive Watch() calls.
+	descriptors []*metadata.Descriptor
 }
 
 func (mds *mdsClient) Get(ctx context.Context) (*metadata.Descriptor, error) {
@@ -47,16 +49,37 @@ func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
 	if !mds.disableUnknownFailure {
 		return nil, errUnknown
 	}
+	if len(mds.descriptors) > 0 {
+		desc := mds.descriptors[0]
+		mds.descriptors = mds.descriptors[1:]
+		return desc, nil
+	}
 	return nil, nil
 }
 
+func (mds *mdsClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	return "", "", fmt.Errorf("WatchKey() not yet implemented")
+}
+
 func (mds *mdsClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
 	return fmt.Errorf("WriteGuestattributes() not yet implemented")
 }
 
+func (mds *mdsClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
 func TestWatcherAPI(t *testing.T) {
 	watcher := New()
-	expectedEvents := []string{LongpollEvent}
+	expectedEvents := []string{LongpollEvent, ChangeEvent}
 	if !reflect.DeepEqual(watcher.Events(), expectedEvents) {
 		t.Fatalf("watcher.Events() returned: %+v, expected: %+v.", watcher.Events(), expectedEvents)
 	}
@@ -99,3 +122,49 @@ func TestWatcherUnknownFailure(t *testing.T) {
 		t.Errorf("watcher.Run(%s) returned renew: %t, expected: true.", LongpollEvent, renew)
 	}
 }
+
+func TestWatcherChanges(t *testing.T) {
+	disabled, enabled := false, true
+	first := &metadata.Descriptor{}
+	first.Instance.Attributes.EnableOSLogin = &disabled
+	first.Instance.Attributes.Raw = map[string]string{"enable-oslogin": "false", "startup-script": "echo"}
+	second := &metadata.Descriptor{}
+	second.Instance.Attributes.EnableOSLogin = &enabled
+	second.Instance.Attributes.Raw = map[string]string{"enable-oslogin": "true", "startup-script": "echo"}
+	second.Instance.VirtualClock.DriftToken = 1
+
+	watcher := New()
+	watcher.client = &mdsClient{disableUnknownFailure: true, descriptors: []*metadata.Descriptor{first, second}}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := watcher.Run(context.Background(), LongpollEvent); err != nil {
+			t.Fatalf("watcher.Run(%s) returned error: %+v, expected success.", LongpollEvent, err)
+		}
+	}
+
+	want := []metadata.Change{
+		{Path: "Instance.Attributes.EnableOSLogin", Old: false, New: true},
+		{Path: "Instance.VirtualClock.DriftToken", Old: 0, New: 1},
+	}
+
+	for _, w := range want {
+		renew, evData, err := watcher.Run(context.Background(), ChangeEvent)
+		if err != nil || !renew {
+			t.Fatalf("watcher.Run(%s) = (%t, %v), expected (true, nil).", ChangeEvent, renew, err)
+		}
+		got, ok := evData.(*metadata.Change)
+		if !ok {
+			t.Fatalf("watcher.Run(%s) returned %T, expected *metadata.Change.", ChangeEvent, evData)
+		}
+		if !reflect.DeepEqual(*got, w) {
+			t.Errorf("watcher.Run(%s) returned change: %+v, expected: %+v.", ChangeEvent, *got, w)
+		}
+	}
+
+	// No more changes queued, should block until the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if renew, _, err := watcher.Run(ctx, ChangeEvent); renew || err == nil {
+		t.Errorf("watcher.Run(%s) with canceled context = (%t, %v), expected (false, error).", ChangeEvent, renew, err)
+	}
+}
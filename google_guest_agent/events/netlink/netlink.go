@@ -0,0 +1,195 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netlink implements the network link, address and route events watcher.
+package netlink
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the netlink watcher's ID.
+	WatcherID = "netlink-watcher"
+	// LinkEvent is the link added/removed and up/down event type ID.
+	LinkEvent = "netlink-watcher,link"
+	// AddressEvent is the address added/removed event type ID.
+	AddressEvent = "netlink-watcher,address"
+	// RouteEvent is the route added/removed event type ID.
+	RouteEvent = "netlink-watcher,route"
+
+	// queueSize is the number of changes buffered per event type, changes are
+	// dropped if the subscribers can't keep up.
+	queueSize = 64
+)
+
+var (
+	// Link describes LinkEvent, its event data is a *Change.
+	Link = typed.New[*Change](LinkEvent)
+	// Address describes AddressEvent, its event data is a *Change.
+	Address = typed.New[*Change](AddressEvent)
+	// Route describes RouteEvent, its event data is a *Change.
+	Route = typed.New[*Change](RouteEvent)
+)
+
+// Kind is the kind of a network change.
+type Kind int
+
+const (
+	// LinkAdded is reported when a new network interface shows up.
+	LinkAdded Kind = iota
+	// LinkRemoved is reported when a network interface goes away.
+	LinkRemoved
+	// LinkUp is reported when a network interface becomes up and running.
+	LinkUp
+	// LinkDown is reported when a network interface stops being up and running.
+	LinkDown
+	// AddressAdded is reported when an address is added to a network interface.
+	AddressAdded
+	// AddressRemoved is reported when an address is removed from a network interface.
+	AddressRemoved
+	// RouteAdded is reported when a route through a network interface is added.
+	RouteAdded
+	// RouteRemoved is reported when a route through a network interface is removed.
+	RouteRemoved
+)
+
+var kindNames = map[Kind]string{
+	LinkAdded:      "link-added",
+	LinkRemoved:    "link-removed",
+	LinkUp:         "link-up",
+	LinkDown:       "link-down",
+	AddressAdded:   "address-added",
+	AddressRemoved: "address-removed",
+	RouteAdded:     "route-added",
+	RouteRemoved:   "route-removed",
+}
+
+// String returns the name of k.
+func (k Kind) String() string {
+	if name, found := kindNames[k]; found {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(k))
+}
+
+// eventType returns the event type changes of kind k are reported with.
+func (k Kind) eventType() string {
+	switch k {
+	case LinkAdded, LinkRemoved, LinkUp, LinkDown:
+		return LinkEvent
+	case AddressAdded, AddressRemoved:
+		return AddressEvent
+	default:
+		return RouteEvent
+	}
+}
+
+// Change is the event data of the netlink events, it describes a single network
+// change.
+type Change struct {
+	// Kind is the kind of change.
+	Kind Kind
+	// Index is the index of the affected network interface.
+	Index int
+	// Name is the name of the affected network interface, it may be empty if the
+	// interface is unknown.
+	Name string
+	// Address is the address or the route destination for address and route
+	// changes, empty otherwise.
+	Address string
+}
+
+// String returns a human readable representation of c.
+func (c Change) String() string {
+	res := fmt.Sprintf("%s %s(%d)", c.Kind, c.Name, c.Index)
+	if c.Address != "" {
+		res += " " + c.Address
+	}
+	return res
+}
+
+// link is the last known state of a network interface.
+type link struct {
+	name string
+	up   bool
+}
+
+// Watcher is the netlink event watcher implementation.
+type Watcher struct {
+	// mutex protects running, done, err, cancel and active.
+	mutex sync.Mutex
+	// running is true while the netlink socket is being read.
+	running bool
+	// done is closed when the reader go routine exits.
+	done chan struct{}
+	// err is the error the reader go routine failed with, if any.
+	err error
+	// cancel closes the netlink socket.
+	cancel context.CancelFunc
+	// active are the event types whose context is not done yet.
+	active map[string]bool
+	// links are the known network interfaces indexed by their index, only
+	// accessed by the reader go routine.
+	links map[int]*link
+	// queues are the pending changes per event type.
+	queues map[string]chan *Change
+}
+
+// New allocates and initializes a new Watcher.
+func New() *Watcher {
+	watcher := &Watcher{
+		links:  make(map[int]*link),
+		queues: make(map[string]chan *Change),
+		active: make(map[string]bool),
+	}
+
+	for _, curr := range watcher.Events() {
+		watcher.queues[curr] = make(chan *Change, queueSize)
+	}
+
+	return watcher
+}
+
+// ID returns the netlink event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{LinkEvent, AddressEvent, RouteEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Link, Address, Route}
+}
+
+// dispatch queues changes to their event types, changes are dropped if the
+// queue is full.
+func (w *Watcher) dispatch(changes []*Change) {
+	for _, change := range changes {
+		select {
+		case w.queues[change.Kind.eventType()] <- change:
+		default:
+			logger.Debugf("Netlink event queue full, dropping change: %s", change)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/sys/unix"
+)
+
+// groups are the netlink multicast groups the watcher subscribes to.
+const groups = unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+	unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE
+
+// Run waits for the next network change of evType and reports it back. The netlink
+// socket is opened by the first call and closed once the contexts of all the event
+// types are done. If the socket fails the error is reported and renewing reopens it.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	queue, found := w.queues[evType]
+	if !found {
+		return false, nil, fmt.Errorf("netlink watcher: unknown event type %q", evType)
+	}
+
+	done, err := w.start(evType)
+	if err != nil {
+		return true, nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		w.leave(evType)
+		return false, nil, ctx.Err()
+	case <-done:
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		return true, nil, w.err
+	case change := <-queue:
+		return true, change, nil
+	}
+}
+
+// start opens the netlink socket and launches the reader go routine if it's not
+// running yet, the returned channel is closed when the reader go routine exits.
+// evType is recorded as using the socket until leave() is called.
+func (w *Watcher) start(evType string) (chan struct{}, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.active[evType] = true
+	if w.running {
+		return w.done, nil
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	// The socket is non blocking so the file is handled by the runtime poller and
+	// closing it unblocks the reader.
+	file := os.NewFile(uintptr(fd), "netlink")
+
+	// The links are seeded after subscribing so no change is missed in between.
+	w.seedLinks()
+
+	done := make(chan struct{})
+	w.running = true
+	w.err = nil
+	w.done = done
+
+	// The socket belongs to the watcher rather than to the event type that opened
+	// it, it's closed by leave() or once the reader exits.
+	runCtx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	go func() {
+		<-runCtx.Done()
+		file.Close()
+	}()
+
+	go func() {
+		defer cancel()
+		w.read(file, done)
+	}()
+
+	return done, nil
+}
+
+// leave records that evType's context is done, the netlink socket is closed once
+// no event type is left.
+func (w *Watcher) leave(evType string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	delete(w.active, evType)
+	if len(w.active) == 0 && w.cancel != nil {
+		w.cancel()
+	}
+}
+
+// seedLinks initializes the known links with the current network interfaces.
+func (w *Watcher) seedLinks() {
+	w.links = make(map[int]*link)
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Errorf("Failed to list network interfaces: %v", err)
+		return
+	}
+
+	for _, iface := range ifaces {
+		up := iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0
+		w.links[iface.Index] = &link{name: iface.Name, up: up}
+	}
+}
+
+// read reads and dispatches the netlink messages until file is closed or fails.
+func (w *Watcher) read(file *os.File, done chan struct{}) {
+	var err error
+
+	defer func() {
+		file.Close()
+		w.mutex.Lock()
+		w.running = false
+		w.err = err
+		w.mutex.Unlock()
+		close(done)
+	}()
+
+	conn, err := file.SyscallConn()
+	if err != nil {
+		err = fmt.Errorf("failed to access netlink socket: %w", err)
+		return
+	}
+
+	buf := make([]byte, os.Getpagesize()*4)
+
+	for {
+		var n int
+		var recvErr error
+
+		err = conn.Read(func(fd uintptr) bool {
+			n, _, recvErr = unix.Recvfrom(int(fd), buf, 0)
+			return recvErr != unix.EAGAIN && recvErr != unix.EWOULDBLOCK
+		})
+
+		if err != nil {
+			// Closing the socket is how the reader is stopped.
+			if errors.Is(err, os.ErrClosed) {
+				err = nil
+			}
+			return
+		}
+
+		if errors.Is(recvErr, unix.ENOBUFS) {
+			logger.Warningf("Netlink socket buffer overrun, some network changes were lost")
+			continue
+		}
+
+		if recvErr != nil {
+			err = fmt.Errorf("failed to read netlink socket: %w", recvErr)
+			return
+		}
+
+		msgs, parseErr := syscall.ParseNetlinkMessage(buf[:n])
+		if parseErr != nil {
+			logger.Debugf("Failed to parse netlink message: %v", parseErr)
+			continue
+		}
+
+		w.dispatch(w.parseMessages(msgs))
+	}
+}
+
+// parseMessages translates msgs into changes and updates the known links.
+func (w *Watcher) parseMessages(msgs []syscall.NetlinkMessage) []*Change {
+	var changes []*Change
+
+	for i := range msgs {
+		msg := &msgs[i]
+
+		switch msg.Header.Type {
+		case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+			if len(msg.Data) < unix.SizeofIfInfomsg {
+				continue
+			}
+			info := (*unix.IfInfomsg)(unsafe.Pointer(&msg.Data[0]))
+			// Bridge port notifications are not interface changes.
+			if info.Family == unix.AF_BRIDGE {
+				continue
+			}
+			name := attrString(routeAttrs(msg), unix.IFLA_IFNAME)
+			changes = append(changes, w.linkChanges(msg.Header.Type == unix.RTM_DELLINK, int(info.Index), name, info.Flags)...)
+		case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+			if len(msg.Data) < unix.SizeofIfAddrmsg {
+				continue
+			}
+			info := (*unix.IfAddrmsg)(unsafe.Pointer(&msg.Data[0]))
+			attrs := routeAttrs(msg)
+			addr := attrIP(attrs, unix.IFA_LOCAL)
+			if addr == nil {
+				addr = attrIP(attrs, unix.IFA_ADDRESS)
+			}
+			kind := AddressAdded
+			if msg.Header.Type == unix.RTM_DELADDR {
+				kind = AddressRemoved
+			}
+			changes = append(changes, w.change(kind, int(info.Index), prefix(addr, info.Prefixlen)))
+		case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+			if len(msg.Data) < unix.SizeofRtMsg {
+				continue
+			}
+			info := (*unix.RtMsg)(unsafe.Pointer(&msg.Data[0]))
+			attrs := routeAttrs(msg)
+			index := attrUint32(attrs, unix.RTA_OIF)
+			// Routes not going through a single interface are not tracked.
+			if index == 0 {
+				continue
+			}
+			dst := "default"
+			if ip := attrIP(attrs, unix.RTA_DST); ip != nil {
+				dst = prefix(ip, info.Dst_len)
+			}
+			kind := RouteAdded
+			if msg.Header.Type == unix.RTM_DELROUTE {
+				kind = RouteRemoved
+			}
+			changes = append(changes, w.change(kind, int(index), dst))
+		}
+	}
+
+	return changes
+}
+
+// linkChanges updates the known state of the link index and returns the resulting
+// changes.
+func (w *Watcher) linkChanges(removed bool, index int, name string, flags uint32) []*Change {
+	up := flags&unix.IFF_UP != 0 && flags&unix.IFF_RUNNING != 0
+	curr, known := w.links[index]
+
+	if removed {
+		if known && name == "" {
+			name = curr.name
+		}
+		delete(w.links, index)
+		return []*Change{{Kind: LinkRemoved, Index: index, Name: name}}
+	}
+
+	var changes []*Change
+	if !known {
+		curr = &link{name: name}
+		w.links[index] = curr
+		changes = append(changes, &Change{Kind: LinkAdded, Index: index, Name: name})
+	}
+
+	if name != "" {
+		curr.name = name
+	}
+
+	if up != curr.up {
+		curr.up = up
+		kind := LinkDown
+		if up {
+			kind = LinkUp
+		}
+		changes = append(changes, &Change{Kind: kind, Index: index, Name: curr.name})
+	}
+
+	return changes
+}
+
+// change returns a change of kind for the link index, named after the known link.
+func (w *Watcher) change(kind Kind, index int, addr string) *Change {
+	change := &Change{Kind: kind, Index: index, Address: addr}
+	if curr, found := w.links[index]; found {
+		change.Name = curr.name
+	}
+	return change
+}
+
+// routeAttrs returns the route attributes of msg, malformed attributes are ignored.
+func routeAttrs(msg *syscall.NetlinkMessage) []syscall.NetlinkRouteAttr {
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err != nil {
+		logger.Debugf("Failed to parse netlink route attributes: %v", err)
+		return nil
+	}
+	return attrs
+}
+
+// attrValue returns the value of the attribute attrType or nil if it's not present.
+func attrValue(attrs []syscall.NetlinkRouteAttr, attrType uint16) []byte {
+	for _, attr := range attrs {
+		if attr.Attr.Type == attrType {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+// attrString returns the NUL terminated string value of the attribute attrType.
+func attrString(attrs []syscall.NetlinkRouteAttr, attrType uint16) string {
+	return unix.ByteSliceToString(attrValue(attrs, attrType))
+}
+
+// attrIP returns the IP address value of the attribute attrType.
+func attrIP(attrs []syscall.NetlinkRouteAttr, attrType uint16) net.IP {
+	value := attrValue(attrs, attrType)
+	if len(value) != net.IPv4len && len(value) != net.IPv6len {
+		return nil
+	}
+	return net.IP(value)
+}
+
+// attrUint32 returns the native endian uint32 value of the attribute attrType.
+func attrUint32(attrs []syscall.NetlinkRouteAttr, attrType uint16) uint32 {
+	value := attrValue(attrs, attrType)
+	if len(value) < 4 {
+		return 0
+	}
+	return *(*uint32)(unsafe.Pointer(&value[0]))
+}
+
+// prefix formats ip with its prefix length, empty if ip is nil.
+func prefix(ip net.IP, length uint8) string {
+	if ip == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", ip, length)
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netlink
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtAttr serializes a route attribute of attrType carrying value.
+func rtAttr(attrType uint16, value []byte) []byte {
+	attr := unix.RtAttr{Len: uint16(unix.SizeofRtAttr + len(value)), Type: attrType}
+	res := append([]byte{}, (*[unix.SizeofRtAttr]byte)(unsafe.Pointer(&attr))[:]...)
+	res = append(res, value...)
+	for len(res)%4 != 0 {
+		res = append(res, 0)
+	}
+	return res
+}
+
+func linkMessage(msgType uint16, index int32, flags uint32, name string) syscall.NetlinkMessage {
+	info := unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: index, Flags: flags}
+	data := append([]byte{}, (*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&info))[:]...)
+	data = append(data, rtAttr(unix.IFLA_IFNAME, append([]byte(name), 0))...)
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: msgType}, Data: data}
+}
+
+func addrMessage(msgType uint16, index uint32, addr string, prefixLen uint8) syscall.NetlinkMessage {
+	info := unix.IfAddrmsg{Family: unix.AF_INET, Prefixlen: prefixLen, Index: index}
+	data := append([]byte{}, (*[unix.SizeofIfAddrmsg]byte)(unsafe.Pointer(&info))[:]...)
+	data = append(data, rtAttr(unix.IFA_LOCAL, net.ParseIP(addr).To4())...)
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: msgType}, Data: data}
+}
+
+func routeMessage(msgType uint16, index uint32, dst string, dstLen uint8) syscall.NetlinkMessage {
+	info := unix.RtMsg{Family: unix.AF_INET, Dst_len: dstLen}
+	data := append([]byte{}, (*[unix.SizeofRtMsg]byte)(unsafe.Pointer(&info))[:]...)
+	if dst != "" {
+		data = append(data, rtAttr(unix.RTA_DST, net.ParseIP(dst).To4())...)
+	}
+	if index != 0 {
+		data = append(data, rtAttr(unix.RTA_OIF, (*[4]byte)(unsafe.Pointer(&index))[:])...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: msgType}, Data: data}
+}
+
+func TestParseMessages(t *testing.T) {
+	up := uint32(unix.IFF_UP | unix.IFF_RUNNING)
+
+	tests := []struct {
+		desc string
+		msg  syscall.NetlinkMessage
+		want []Change
+	}{
+		{
+			desc: "new_link",
+			msg:  linkMessage(unix.RTM_NEWLINK, 7, 0, "eth1"),
+			want: []Change{{Kind: LinkAdded, Index: 7, Name: "eth1"}},
+		},
+		{
+			desc: "link_up",
+			msg:  linkMessage(unix.RTM_NEWLINK, 7, up, "eth1"),
+			want: []Change{{Kind: LinkUp, Index: 7, Name: "eth1"}},
+		},
+		{
+			desc: "link_unchanged",
+			msg:  linkMessage(unix.RTM_NEWLINK, 7, up, "eth1"),
+		},
+		{
+			desc: "address_added",
+			msg:  addrMessage(unix.RTM_NEWADDR, 7, "10.128.0.5", 32),
+			want: []Change{{Kind: AddressAdded, Index: 7, Name: "eth1", Address: "10.128.0.5/32"}},
+		},
+		{
+			desc: "route_removed",
+			msg:  routeMessage(unix.RTM_DELROUTE, 7, "10.0.0.0", 24),
+			want: []Change{{Kind: RouteRemoved, Index: 7, Name: "eth1", Address: "10.0.0.0/24"}},
+		},
+		{
+			desc: "default_route_added",
+			msg:  routeMessage(unix.RTM_NEWROUTE, 7, "", 0),
+			want: []Change{{Kind: RouteAdded, Index: 7, Name: "eth1", Address: "default"}},
+		},
+		{
+			desc: "route_without_interface",
+			msg:  routeMessage(unix.RTM_NEWROUTE, 0, "10.0.0.0", 8),
+		},
+		{
+			desc: "link_down",
+			msg:  linkMessage(unix.RTM_NEWLINK, 7, unix.IFF_UP, "eth1"),
+			want: []Change{{Kind: LinkDown, Index: 7, Name: "eth1"}},
+		},
+		{
+			desc: "link_removed",
+			msg:  linkMessage(unix.RTM_DELLINK, 7, 0, ""),
+			want: []Change{{Kind: LinkRemoved, Index: 7, Name: "eth1"}},
+		},
+		{
+			desc: "new_link_up",
+			msg:  linkMessage(unix.RTM_NEWLINK, 8, up, "eth2"),
+			want: []Change{{Kind: LinkAdded, Index: 8, Name: "eth2"}, {Kind: LinkUp, Index: 8, Name: "eth2"}},
+		},
+		{
+			desc: "truncated",
+			msg:  syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: unix.RTM_NEWLINK}, Data: []byte{0, 0}},
+		},
+	}
+
+	// The test cases are applied in order as the link state carries over.
+	watcher := New()
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := watcher.parseMessages([]syscall.NetlinkMessage{tc.msg})
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseMessages() returned %v, expected %v.", got, tc.want)
+			}
+			for i := range tc.want {
+				if *got[i] != tc.want[i] {
+					t.Errorf("parseMessages()[%d] = %s, expected %s.", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	watcher := New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	renew, _, err := watcher.Run(ctx, LinkEvent)
+	if err == nil {
+		t.Errorf("Run() succeeded, expected context cancellation error.")
+	}
+	if renew {
+		t.Errorf("Run() returned renew = true after cancellation, expected false.")
+	}
+}
+
+func TestRunSharedSocket(t *testing.T) {
+	watcher := New()
+	linkCtx, cancelLink := context.WithCancel(context.Background())
+	addrCtx, cancelAddr := context.WithCancel(context.Background())
+	defer cancelAddr()
+
+	result := make(chan bool)
+	go func() {
+		renew, _, _ := watcher.Run(addrCtx, AddressEvent)
+		result <- renew
+	}()
+
+	for {
+		watcher.mutex.Lock()
+		watching := watcher.active[AddressEvent]
+		watcher.mutex.Unlock()
+		if watching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The address events keep the socket open after the link events' context is done.
+	cancelLink()
+	if renew, _, _ := watcher.Run(linkCtx, LinkEvent); renew {
+		t.Errorf("Run() returned renew = true after cancellation, expected false.")
+	}
+
+	watcher.mutex.Lock()
+	running := watcher.running
+	watcher.mutex.Unlock()
+	if !running {
+		t.Errorf("Netlink socket was closed while the address events were still watched.")
+	}
+
+	cancelAddr()
+	if renew := <-result; renew {
+		t.Errorf("Run() returned renew = true after cancellation, expected false.")
+	}
+}
+
+func TestRunReaderExit(t *testing.T) {
+	watcher := New()
+
+	result := make(chan bool)
+	go func() {
+		renew, _, _ := watcher.Run(context.Background(), LinkEvent)
+		result <- renew
+	}()
+
+	// Closing the socket stops the reader as a failure would.
+	for {
+		watcher.mutex.Lock()
+		cancel := watcher.cancel
+		watcher.mutex.Unlock()
+		if cancel != nil {
+			cancel()
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if renew := <-result; !renew {
+		t.Errorf("Run() returned renew = false after the reader exited, expected true so the socket is reopened.")
+	}
+}
+
+func TestRunUnknownEvent(t *testing.T) {
+	if _, _, err := New().Run(context.Background(), "netlink-watcher,unknown"); err == nil {
+		t.Errorf("Run() succeeded for an unknown event type, expected error.")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netlink
+
+import (
+	"testing"
+)
+
+func TestWatcherAPI(t *testing.T) {
+	watcher := New()
+
+	if watcher.ID() != WatcherID {
+		t.Errorf("watcher.ID() returned: %s, expected: %s.", watcher.ID(), WatcherID)
+	}
+
+	want := []string{LinkEvent, AddressEvent, RouteEvent}
+	got := watcher.Events()
+	if len(got) != len(want) {
+		t.Fatalf("watcher.Events() returned: %v, expected: %v.", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("watcher.Events() returned: %v, expected: %v.", got, want)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		kind   Kind
+		evType string
+	}{
+		{LinkAdded, LinkEvent},
+		{LinkRemoved, LinkEvent},
+		{LinkUp, LinkEvent},
+		{LinkDown, LinkEvent},
+		{AddressAdded, AddressEvent},
+		{AddressRemoved, AddressEvent},
+		{RouteAdded, RouteEvent},
+		{RouteRemoved, RouteEvent},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.kind.String(), func(t *testing.T) {
+			watcher := New()
+			watcher.dispatch([]*Change{{Kind: tc.kind, Index: 2, Name: "eth0"}})
+
+			select {
+			case change := <-watcher.queues[tc.evType]:
+				if change.Kind != tc.kind {
+					t.Errorf("dispatch() queued change of kind %s, expected %s.", change.Kind, tc.kind)
+				}
+			default:
+				t.Errorf("dispatch() didn't queue a change to %s.", tc.evType)
+			}
+		})
+	}
+}
+
+func TestDispatchFullQueue(t *testing.T) {
+	watcher := New()
+
+	var changes []*Change
+	for i := 0; i < queueSize+10; i++ {
+		changes = append(changes, &Change{Kind: LinkUp, Index: i})
+	}
+	watcher.dispatch(changes)
+
+	if got := len(watcher.queues[LinkEvent]); got != queueSize {
+		t.Errorf("dispatch() queued %d changes, expected %d.", got, queueSize)
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	tests := []struct {
+		change Change
+		want   string
+	}{
+		{Change{Kind: LinkAdded, Index: 3, Name: "eth1"}, "link-added eth1(3)"},
+		{Change{Kind: RouteRemoved, Index: 3, Name: "eth1", Address: "10.0.0.0/24"}, "route-removed eth1(3) 10.0.0.0/24"},
+		{Change{Kind: Kind(100), Index: 1}, "unknown(100) (1)"},
+	}
+
+	for _, tc := range tests {
+		if got := tc.change.String(); got != tc.want {
+			t.Errorf("Change.String() returned %q, expected %q.", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package preemption implements the preemption events watcher, it notifies the
+// preemptible and spot instances' termination notice.
+package preemption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the preemption watcher's ID.
+	WatcherID = "preemption-watcher"
+	// PreemptedEvent is emitted as soon as the instance is notified of its preemption.
+	PreemptedEvent = "preemption-watcher,preempted"
+
+	// preemptedKey is the metadata key flagging the instance's preemption.
+	preemptedKey = "instance/preempted"
+
+	// NoticeWindow is how long the instance keeps running once preempted.
+	NoticeWindow = 30 * time.Second
+)
+
+var (
+	// Preempted describes PreemptedEvent.
+	Preempted = typed.New[*Event](PreemptedEvent)
+
+	// retryInterval is how long the watcher waits before querying the metadata
+	// server again after a failure.
+	retryInterval = 5 * time.Second
+)
+
+// Event is the event data of PreemptedEvent.
+type Event struct {
+	// Time is when the preemption was detected.
+	Time time.Time
+}
+
+// Deadline returns when the instance is expected to be stopped.
+func (ev *Event) Deadline() time.Time {
+	return ev.Time.Add(NoticeWindow)
+}
+
+// Watcher is the preemption event watcher implementation, it longpolls the
+// instance's preempted metadata key.
+type Watcher struct {
+	client metadata.MDSClientInterface
+
+	// etag is the etag of the last longpoll response.
+	etag string
+	// preempted is the last known preempted flag.
+	preempted bool
+	// failed is true if the last longpoll failed.
+	failed bool
+}
+
+// New allocates and initializes a new Watcher.
+func New() *Watcher {
+	return &Watcher{client: metadata.New()}
+}
+
+// ID returns the preemption event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{PreemptedEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Preempted}
+}
+
+// Run longpolls the preempted flag until it flips to true and reports back the
+// event, failures are retried until ctx is done.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	if evType != PreemptedEvent {
+		return false, nil, fmt.Errorf("preemption watcher: unknown event type %q", evType)
+	}
+
+	for {
+		resp, etag, err := w.client.WatchKey(ctx, preemptedKey, w.etag)
+		if ctx.Err() != nil {
+			return false, nil, ctx.Err()
+		}
+
+		if err != nil {
+			if !w.failed {
+				logger.Errorf("Failed to watch %s: %v", preemptedKey, err)
+				w.failed = true
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		w.failed = false
+		w.etag = etag
+		preempted := parsePreempted(resp)
+		if preempted && !w.preempted {
+			w.preempted = true
+			ev := &Event{Time: time.Now()}
+			logger.Warningf("Instance preempted, expecting termination by %s", ev.Deadline().Format(time.RFC3339))
+			return true, ev, nil
+		}
+		w.preempted = preempted
+	}
+}
+
+// parsePreempted returns the preempted flag of the JSON formatted resp.
+func parsePreempted(resp string) bool {
+	var value string
+	if err := json.Unmarshal([]byte(resp), &value); err != nil {
+		value = strings.TrimSpace(resp)
+	}
+	return strings.EqualFold(value, "TRUE")
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preemption
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+type mdsClient struct {
+	// values are returned by successive WatchKey() calls, once exhausted WatchKey()
+	// blocks until the context is done.
+	values []string
+}
+
+func (mds *mdsClient) Get(ctx context.Context) (*metadata.Descriptor, error) {
+	return nil, fmt.Errorf("Get() not yet implemented")
+}
+
+func (mds *mdsClient) GetKey(ctx context.Context, key string, headers map[string]string) (string, error) {
+	return "", fmt.Errorf("GetKey() not yet implemented")
+}
+
+func (mds *mdsClient) GetKeyRecursive(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetKeyRecursive() not yet implemented")
+}
+
+func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
+	return nil, fmt.Errorf("Watch() not yet implemented")
+}
+
+func (mds *mdsClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	if key != preemptedKey {
+		return "", "", fmt.Errorf("unexpected key %q", key)
+	}
+	if len(mds.values) == 0 {
+		<-ctx.Done()
+		return "", "", ctx.Err()
+	}
+	value := mds.values[0]
+	mds.values = mds.values[1:]
+	return fmt.Sprintf("%q", value), value, nil
+}
+
+func (mds *mdsClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
+	return fmt.Errorf("WriteGuestattributes() not yet implemented")
+}
+
+func (mds *mdsClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
+func TestParsePreempted(t *testing.T) {
+	tests := []struct {
+		resp string
+		want bool
+	}{
+		{`"TRUE"`, true},
+		{`"FALSE"`, false},
+		{"TRUE\n", true},
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		if got := parsePreempted(tc.resp); got != tc.want {
+			t.Errorf("parsePreempted(%q) = %t, want %t", tc.resp, got, tc.want)
+		}
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := &Watcher{client: &mdsClient{values: []string{"FALSE", "FALSE", "TRUE", "TRUE"}}}
+
+	renew, data, err := watcher.Run(ctx, PreemptedEvent)
+	if err != nil || !renew {
+		t.Fatalf("Run() = %t, %v, expected renew without error", renew, err)
+	}
+
+	ev, ok := data.(*Event)
+	if !ok {
+		t.Fatalf("Run() returned data of type %T, expected *Event", data)
+	}
+
+	if got := ev.Deadline().Sub(ev.Time); got != NoticeWindow {
+		t.Errorf("Event.Deadline() is %s after the event, want %s", got, NoticeWindow)
+	}
+
+	// The flag staying set isn't a new preemption.
+	cancel()
+	if renew, _, err := watcher.Run(ctx, PreemptedEvent); renew || err == nil {
+		t.Errorf("Run() with a canceled context = %t, %v, expected no renew and an error", renew, err)
+	}
+
+	if _, _, err := watcher.Run(ctx, "unknown"); err == nil {
+		t.Errorf("Run() with an unknown event type succeeded, expected error")
+	}
+}
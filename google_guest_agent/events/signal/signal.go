@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signal implements the process signals events watcher.
+package signal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+)
+
+const (
+	// WatcherID is the signal watcher's ID.
+	WatcherID = "signal-watcher"
+	// ReloadEvent is emitted on SIGHUP, subscribers are expected to reload the
+	// configuration. Its event data is the received os.Signal.
+	ReloadEvent = "signal-watcher,reload"
+	// DumpEvent is emitted on SIGUSR1, subscribers are expected to log their
+	// internal state. Its event data is the received os.Signal.
+	DumpEvent = "signal-watcher,dump"
+)
+
+var (
+	// Reload describes ReloadEvent.
+	Reload = typed.New[os.Signal](ReloadEvent)
+	// Dump describes DumpEvent.
+	Dump = typed.New[os.Signal](DumpEvent)
+)
+
+// Watcher is the signal event watcher implementation.
+type Watcher struct {
+	// channels maps the event types to the channels their signal is relayed to.
+	channels map[string]chan os.Signal
+}
+
+// New allocates and initializes a new Watcher. The signals are handled from this
+// point on, they no longer have their default behavior (i.e. SIGHUP and SIGUSR1
+// terminate the process).
+func New() *Watcher {
+	watcher := &Watcher{
+		channels: make(map[string]chan os.Signal),
+	}
+
+	for evType, sig := range signals {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, sig)
+		watcher.channels[evType] = ch
+	}
+
+	return watcher
+}
+
+// ID returns the signal event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{ReloadEvent, DumpEvent}
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Reload, Dump}
+}
+
+// Run waits for the signal of evType and reports back the event. Signals received
+// while the previous one is being handled are coalesced.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	ch, found := w.channels[evType]
+	if !found {
+		return false, nil, fmt.Errorf("signal watcher: event type %q is not supported on %s", evType, runtime.GOOS)
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	case sig := <-ch:
+		return true, sig, nil
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package signal
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatcherAPI(t *testing.T) {
+	watcher := New()
+
+	if watcher.ID() != WatcherID {
+		t.Errorf("watcher.ID() returned: %s, expected: %s.", watcher.ID(), WatcherID)
+	}
+
+	want := []string{ReloadEvent, DumpEvent}
+	got := watcher.Events()
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("watcher.Events() returned: %v, expected: %v.", got, want)
+	}
+}
+
+func TestRunSignal(t *testing.T) {
+	tests := []struct {
+		evType string
+		sig    syscall.Signal
+	}{
+		{ReloadEvent, syscall.SIGHUP},
+		{DumpEvent, syscall.SIGUSR1},
+	}
+
+	watcher := New()
+	for _, tc := range tests {
+		t.Run(tc.evType, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := syscall.Kill(syscall.Getpid(), tc.sig); err != nil {
+				t.Fatalf("Failed to send %s: %v", tc.sig, err)
+			}
+
+			renew, data, err := watcher.Run(ctx, tc.evType)
+			if err != nil {
+				t.Fatalf("Run(%s) failed: %v", tc.evType, err)
+			}
+
+			if !renew {
+				t.Errorf("Run(%s) returned renew = false, expected true", tc.evType)
+			}
+
+			if data != tc.sig {
+				t.Errorf("Run(%s) returned %v, expected %v", tc.evType, data, tc.sig)
+			}
+		})
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	renew, _, err := New().Run(ctx, ReloadEvent)
+	if err == nil || renew {
+		t.Errorf("Run() with a canceled context returned (%t, %v), expected (false, error)", renew, err)
+	}
+}
+
+func TestRunUnknownEvent(t *testing.T) {
+	if _, _, err := New().Run(context.Background(), "signal-watcher,unknown"); err == nil {
+		t.Errorf("Run() succeeded for an unknown event type, expected error")
+	}
+}
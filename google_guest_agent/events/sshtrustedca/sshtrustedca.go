@@ -18,6 +18,8 @@ package sshtrustedca
 import (
 	"os"
 	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
 )
 
 const (
@@ -29,6 +31,9 @@ const (
 	DefaultPipePath = "/etc/ssh/oslogin_trustedca.pub"
 )
 
+// Read describes ReadEvent, its event data is a *PipeData.
+var Read = typed.New[*PipeData](ReadEvent)
+
 // Watcher is the sshtrustedca event watcher implementation.
 type Watcher struct {
 	// pipePath points to the named pipe it's writing to.
@@ -69,3 +74,8 @@ func (mp *Watcher) ID() string {
 func (mp *Watcher) Events() []string {
 	return []string{ReadEvent}
 }
+
+// Descriptors returns the descriptors of the implemented events.
+func (mp *Watcher) Descriptors() []typed.Descriptor {
+	return []typed.Descriptor{Read}
+}
@@ -0,0 +1,346 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// defaultSubscriberQueueSize is the default number of events queued per subscriber.
+	defaultSubscriberQueueSize = 64
+)
+
+// SubscribeOptions configures how the events are delivered to a subscriber.
+type SubscribeOptions struct {
+	// Priority orders the subscribers of an event type, an event is handed to
+	// the subscribers with a lower priority only once the subscribers with a
+	// higher priority are done with it: their callback returned, timed out or the
+	// event was dropped. Subscribers with the same priority handle the events
+	// concurrently.
+	Priority int
+	// Concurrency is the maximum number of concurrent callback calls, defaults
+	// to 1 meaning the events are handled one at a time and in order.
+	Concurrency int
+	// Timeout is the deadline of a callback call, the context passed to the
+	// callback is canceled when it elapses and the subscriber moves on to its
+	// next event. The events are skipped until the timed out call returns. Zero
+	// means no deadline.
+	Timeout time.Duration
+	// QueueSize is the number of events queued while the subscriber is busy,
+	// events arriving when the queue is full are dropped. Defaults to 64.
+	QueueSize int
+	// Debounce coalesces bursts of events into a single callback call, the callback
+	// is called with the latest event once the burst is over. Debounced subscribers
+	// ignore Concurrency, their calls never overlap. Disabled by default.
+	Debounce DebounceOptions
+	// DebounceFunc, if set, returns the debounce options on every event so they can
+	// change at runtime, i.e. on a configuration reload. It takes precedence over
+	// Debounce.
+	DebounceFunc func() DebounceOptions
+}
+
+// subscriberEvent is an event queued to the subscribers of its type, one priority
+// level at a time.
+type subscriberEvent struct {
+	ctx    context.Context
+	evType string
+	data   *EventData
+	entry  *HistoryEntry
+	// levels are the priority levels the event is yet to be handed to.
+	levels [][]*eventSubscriber
+	// remaining is the number of subscribers of the current priority level that
+	// are not done with the event.
+	remaining atomic.Int32
+}
+
+// callResult is the outcome of a callback call.
+type callResult struct {
+	renew    bool
+	panicked bool
+}
+
+// newEventSubscriber allocates a subscriber named name, the options defaults are applied.
+func newEventSubscriber(data interface{}, cb EventCb, opts SubscribeOptions, name string) *eventSubscriber {
+	// A debounced subscriber holds back its events in a single worker.
+	if opts.Concurrency <= 0 || opts.DebounceFunc != nil || opts.Debounce.QuietPeriod > 0 {
+		opts.Concurrency = 1
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultSubscriberQueueSize
+	}
+
+	return &eventSubscriber{
+		data:  data,
+		cb:    cb,
+		name:  name,
+		opts:  opts,
+		queue: make(chan *subscriberEvent, opts.QueueSize),
+	}
+}
+
+// SubscribeWithOptions registers an event consumer/subscriber callback to a given event
+// type like Subscribe(), opts configures the callback's priority, concurrency, deadline
+// and queue size.
+func (mngr *Manager) SubscribeWithOptions(evType string, data interface{}, cb EventCb, opts SubscribeOptions) *Subscription {
+	return mngr.subscribe(evType, data, cb, opts, callbackName(cb))
+}
+
+// subscribe registers cb as a subscriber of evType named name, in priority order.
+func (mngr *Manager) subscribe(evType string, data interface{}, cb EventCb, opts SubscribeOptions, name string) *Subscription {
+	mngr.subscribersMutex.Lock()
+	defer mngr.subscribersMutex.Unlock()
+
+	sub := newEventSubscriber(data, cb, opts, name)
+	subscribers := append(mngr.subscribers[evType], sub)
+	sort.SliceStable(subscribers, func(i, j int) bool {
+		return subscribers[i].opts.Priority > subscribers[j].opts.Priority
+	})
+	mngr.subscribers[evType] = subscribers
+
+	return &Subscription{mngr: mngr, evType: evType, sub: sub}
+}
+
+// stop stops the subscriber's workers, the events still queued are discarded. The
+// caller must hold the manager's subscribersMutex.
+func (sub *eventSubscriber) stop() {
+	if sub.stopped.Swap(true) {
+		return
+	}
+	close(sub.queue)
+}
+
+// dispatch queues an event to the subscribers of evType, a priority level at a time
+// starting with the highest priority.
+func (mngr *Manager) dispatch(ctx context.Context, evType string, data *EventData, entry *HistoryEntry) {
+	mngr.subscribersMutex.Lock()
+	defer mngr.subscribersMutex.Unlock()
+
+	subscribers := mngr.subscribers[evType]
+	if subscribers == nil {
+		logger.Debugf("No subscriber found for event: %s, returning.", evType)
+		return
+	}
+
+	ev := &subscriberEvent{ctx: ctx, evType: evType, data: data, entry: entry, levels: priorityLevels(subscribers)}
+	mngr.dispatchLevel(ev)
+}
+
+// priorityLevels groups the subscribers, sorted by priority, by priority level.
+func priorityLevels(subscribers []*eventSubscriber) [][]*eventSubscriber {
+	var levels [][]*eventSubscriber
+	for i, curr := range subscribers {
+		if i == 0 || curr.opts.Priority != subscribers[i-1].opts.Priority {
+			levels = append(levels, nil)
+		}
+		levels[len(levels)-1] = append(levels[len(levels)-1], curr)
+	}
+	return levels
+}
+
+// dispatchLevel queues ev to the subscribers of its next priority level, the levels
+// where no subscriber got the event are skipped. The caller must hold the manager's
+// subscribersMutex.
+func (mngr *Manager) dispatchLevel(ev *subscriberEvent) {
+	for len(ev.levels) > 0 {
+		level := ev.levels[0]
+		ev.levels = ev.levels[1:]
+
+		// The extra count keeps the subscribers from moving on to the next level
+		// while this one is still being queued.
+		ev.remaining.Store(int32(len(level)) + 1)
+		for _, curr := range level {
+			if !mngr.enqueue(ev, curr) {
+				ev.remaining.Add(-1)
+			}
+		}
+
+		if ev.remaining.Add(-1) > 0 {
+			return
+		}
+	}
+}
+
+// enqueue queues ev to sub, it returns false if sub was stopped or its queue is
+// full. The caller must hold the manager's subscribersMutex.
+func (mngr *Manager) enqueue(ev *subscriberEvent, sub *eventSubscriber) bool {
+	// Subscribers of the lower priority levels may be gone by the time the event
+	// gets to them.
+	if sub.stopped.Load() {
+		return false
+	}
+
+	sub.start.Do(func() {
+		for i := 0; i < sub.opts.Concurrency; i++ {
+			go mngr.runSubscriber(ev.ctx, sub)
+		}
+	})
+
+	mngr.pending.Add(1)
+	select {
+	case sub.queue <- ev:
+		return true
+	default:
+		mngr.pending.Done()
+		logger.Warningf("Subscriber %s of event %s is not keeping up, dropping event.", sub.name, ev.evType)
+		mngr.history.record(ev.entry, SubscriberRecord{Name: sub.name, Renew: true, Dropped: true})
+		return false
+	}
+}
+
+// handled is called once a subscriber is done with ev, the last subscriber of a
+// priority level hands the event to the next one.
+func (mngr *Manager) handled(ev *subscriberEvent) {
+	if ev.remaining.Add(-1) > 0 {
+		return
+	}
+
+	mngr.subscribersMutex.Lock()
+	defer mngr.subscribersMutex.Unlock()
+	mngr.dispatchLevel(ev)
+}
+
+// runSubscriber is a subscriber's worker, it calls the callback for the queued events
+// until the subscriber is stopped.
+func (mngr *Manager) runSubscriber(ctx context.Context, sub *eventSubscriber) {
+	if sub.debounced() {
+		mngr.runDebouncedSubscriber(ctx, sub)
+		return
+	}
+
+	for ev := range sub.queue {
+		mngr.handle(ctx, sub, ev)
+	}
+}
+
+// handle calls the subscriber's callback for ev and hands the event to the next
+// priority level.
+func (mngr *Manager) handle(ctx context.Context, sub *eventSubscriber, ev *subscriberEvent) {
+	mngr.call(ctx, sub, ev)
+	mngr.handled(ev)
+	mngr.pending.Done()
+}
+
+// call calls the subscriber's callback for ev and records the outcome in the event
+// history, the subscriber is removed if it asked not to be renewed.
+func (mngr *Manager) call(ctx context.Context, sub *eventSubscriber, ev *subscriberEvent) {
+	// Events queued before unsubscribing or leaving are discarded.
+	if sub.stopped.Load() || ctx.Err() != nil {
+		return
+	}
+
+	var rec SubscriberRecord
+	if sub.late.Load() > 0 {
+		logger.Warningf("Subscriber %s of event %s is still handling a timed out event, skipping event.", sub.name, ev.evType)
+		rec = SubscriberRecord{Name: sub.name, Renew: true, Skipped: true}
+	} else {
+		logger.Debugf("Running registered callback for event: %s", ev.evType)
+		rec = mngr.callSubscriber(ctx, sub, ev)
+	}
+	logger.Debugf("Returning from event %q subscribed callback, should renew?: %t", ev.evType, rec.Renew)
+
+	mngr.history.record(ev.entry, rec)
+	if !rec.Renew {
+		mngr.removeSubscriber(ev.evType, sub)
+	}
+}
+
+// callSubscriber calls the subscriber's callback for ev, enforcing its deadline.
+func (mngr *Manager) callSubscriber(ctx context.Context, sub *eventSubscriber, ev *subscriberEvent) SubscriberRecord {
+	rec := SubscriberRecord{Name: sub.name}
+	start := time.Now()
+
+	if sub.opts.Timeout <= 0 {
+		res := safeCall(ctx, sub, ev)
+		rec.Renew, rec.Panicked, rec.Duration = res.renew, res.panicked, time.Since(start)
+		return rec
+	}
+
+	cbCtx, cancel := context.WithTimeout(ctx, sub.opts.Timeout)
+	result := make(chan callResult, 1)
+	go func() {
+		result <- safeCall(cbCtx, sub, ev)
+	}()
+
+	timer := time.NewTimer(sub.opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-result:
+		cancel()
+		rec.Renew, rec.Panicked = res.renew, res.panicked
+	case <-timer.C:
+		logger.Warningf("Subscriber %s of event %s didn't return within %s, moving on.", sub.name, ev.evType, sub.opts.Timeout)
+		rec.Renew, rec.TimedOut = true, true
+		// The late call still decides whether the subscriber is renewed, the subscriber
+		// skips its events until then so late calls don't pile up.
+		sub.late.Add(1)
+		go func() {
+			res := <-result
+			cancel()
+			sub.late.Add(-1)
+			logger.Infof("Subscriber %s of event %s returned after %s.", sub.name, ev.evType, time.Since(start))
+			if !res.renew {
+				mngr.removeSubscriber(ev.evType, sub)
+			}
+		}()
+	}
+
+	rec.Duration = time.Since(start)
+	return rec
+}
+
+// safeCall calls the subscriber's callback recovering from panics, a panicking
+// callback is kept subscribed.
+func safeCall(ctx context.Context, sub *eventSubscriber, ev *subscriberEvent) (res callResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Subscriber %s of event %s panicked: %v\n%s", sub.name, ev.evType, r, debug.Stack())
+			res = callResult{renew: true, panicked: true}
+		}
+	}()
+
+	return callResult{renew: sub.cb(ctx, ev.evType, sub.data, ev.data)}
+}
+
+// removeSubscriber unsubscribes sub from evType and stops its workers.
+func (mngr *Manager) removeSubscriber(evType string, sub *eventSubscriber) {
+	mngr.subscribersMutex.Lock()
+	defer mngr.subscribersMutex.Unlock()
+
+	mngr.unsubscribe(evType, sub)
+	if mngr.subscribers[evType] == nil {
+		logger.Debugf("No subscribers left for event %s", evType)
+	}
+}
+
+// stopSubscribers stops the workers of all subscribers.
+func (mngr *Manager) stopSubscribers() {
+	mngr.subscribersMutex.Lock()
+	defer mngr.subscribersMutex.Unlock()
+
+	for _, subscribers := range mngr.subscribers {
+		for _, curr := range subscribers {
+			curr.stop()
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runTestManager runs a manager with a testWatcher emitting maxCount events after
+// subscribe registered its subscribers.
+func runTestManager(t *testing.T, maxCount int, subscribe func(mngr *Manager, evType string)) *Manager {
+	t.Helper()
+
+	ctx := context.Background()
+	eventManager := newManager()
+
+	watcher := &testWatcher{watcherID: "test-watcher", maxCount: maxCount}
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	subscribe(eventManager, watcher.Events()[0])
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event managed, expected success, got error: %+v", err)
+	}
+
+	return eventManager
+}
+
+func TestSubscriberPanic(t *testing.T) {
+	var calls atomic.Int32
+
+	eventManager := runTestManager(t, 3, func(mngr *Manager, evType string) {
+		mngr.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			calls.Add(1)
+			panic("test panic")
+		})
+	})
+
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Panicking subscriber was called %d times, expected 3", got)
+	}
+
+	for i, entry := range eventManager.History() {
+		if len(entry.Subscribers) != 1 || !entry.Subscribers[0].Panicked {
+			t.Errorf("History()[%d] subscribers = %+v, expected a single panicked record", i, entry.Subscribers)
+		}
+	}
+}
+
+func TestSubscriberTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var fastCalls atomic.Int32
+
+	eventManager := runTestManager(t, 2, func(mngr *Manager, evType string) {
+		mngr.SubscribeWithOptions(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			<-release
+			return true
+		}, SubscribeOptions{Timeout: 10 * time.Millisecond})
+
+		mngr.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			fastCalls.Add(1)
+			return true
+		})
+	})
+	close(release)
+
+	if got := fastCalls.Load(); got != 2 {
+		t.Errorf("Subscriber was called %d times, expected 2", got)
+	}
+
+	// The second event is skipped while the first timed out call is still running.
+	history := eventManager.History()
+	for i, skipped := range []bool{false, true} {
+		var found int
+		for _, rec := range history[i].Subscribers {
+			if (rec.TimedOut && !skipped) || (rec.Skipped && skipped) {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("History()[%d] subscribers = %+v, expected one timed out or skipped (%t) record", i, history[i].Subscribers, skipped)
+		}
+	}
+}
+
+func TestSubscriberTimeoutContext(t *testing.T) {
+	var canceled atomic.Int32
+
+	runTestManager(t, 1, func(mngr *Manager, evType string) {
+		mngr.SubscribeWithOptions(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			<-ctx.Done()
+			canceled.Add(1)
+			return true
+		}, SubscribeOptions{Timeout: 10 * time.Millisecond})
+	})
+
+	// The callback may return after the manager stopped waiting for it.
+	deadline := time.Now().Add(time.Second)
+	for canceled.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if canceled.Load() != 1 {
+		t.Errorf("Callback context wasn't canceled after the subscriber's timeout")
+	}
+}
+
+func TestSubscriberIsolation(t *testing.T) {
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+	maxCount := 5
+
+	go func() {
+		select {
+		case <-fastDone:
+		case <-time.After(5 * time.Second):
+		}
+		close(release)
+	}()
+
+	var fastCalls atomic.Int32
+	runTestManager(t, maxCount, func(mngr *Manager, evType string) {
+		// The slow subscriber blocks until the fast one handled all events.
+		mngr.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			<-release
+			return true
+		})
+
+		mngr.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			if int(fastCalls.Add(1)) == maxCount {
+				close(fastDone)
+			}
+			return true
+		})
+	})
+
+	select {
+	case <-fastDone:
+	default:
+		t.Errorf("Fast subscriber was stalled by the slow one, handled %d of %d events", fastCalls.Load(), maxCount)
+	}
+}
+
+func TestSubscriberPriority(t *testing.T) {
+	var mutex sync.Mutex
+	var order []string
+
+	record := func(name string, delay time.Duration) EventCb {
+		return func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			time.Sleep(delay)
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, name)
+			return false
+		}
+	}
+
+	runTestManager(t, 1, func(mngr *Manager, evType string) {
+		mngr.SubscribeWithOptions(evType, nil, record("low", 0), SubscribeOptions{Priority: -1})
+		mngr.Subscribe(evType, nil, record("default", 10*time.Millisecond))
+		mngr.SubscribeWithOptions(evType, nil, record("high", 20*time.Millisecond), SubscribeOptions{Priority: 10})
+
+		if got := len(mngr.subscribers[evType]); got != 3 {
+			t.Fatalf("Got %d subscribers, expected 3", got)
+		}
+
+		for i, want := range []int{10, 0, -1} {
+			if got := mngr.subscribers[evType][i].opts.Priority; got != want {
+				t.Errorf("Subscriber %d has priority %d, expected %d", i, got, want)
+			}
+		}
+	})
+
+	// The slower subscribers with a higher priority are still handed the event first.
+	if got, want := strings.Join(order, ","), "high,default,low"; got != want {
+		t.Errorf("Subscribers called in order %q, expected %q", got, want)
+	}
+}
+
+func TestSubscriberConcurrency(t *testing.T) {
+	var running, maxRunning atomic.Int32
+
+	runTestManager(t, 8, func(mngr *Manager, evType string) {
+		mngr.SubscribeWithOptions(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			curr := running.Add(1)
+			defer running.Add(-1)
+			for {
+				prev := maxRunning.Load()
+				if curr <= prev || maxRunning.CompareAndSwap(prev, curr) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return true
+		}, SubscribeOptions{Concurrency: 4})
+	})
+
+	if got := maxRunning.Load(); got < 2 || got > 4 {
+		t.Errorf("Got %d concurrent callback calls, expected between 2 and 4", got)
+	}
+}
+
+func TestSubscriberQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	maxCount := 10
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+
+	var calls atomic.Int32
+	eventManager := runTestManager(t, maxCount, func(mngr *Manager, evType string) {
+		mngr.SubscribeWithOptions(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			<-release
+			calls.Add(1)
+			return true
+		}, SubscribeOptions{QueueSize: 1})
+	})
+
+	var dropped int
+	for _, entry := range eventManager.History() {
+		for _, rec := range entry.Subscribers {
+			if rec.Dropped {
+				dropped++
+			}
+		}
+	}
+
+	if dropped == 0 {
+		t.Errorf("No event was dropped with a full subscriber queue")
+	}
+
+	if got := int(calls.Load()) + dropped; got != maxCount {
+		t.Errorf("Got %d handled and %d dropped events, expected %d in total", calls.Load(), dropped, maxCount)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package timer implements the periodic timer events watcher.
+package timer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+)
+
+// Tick describes a named periodic event.
+type Tick struct {
+	// Name is the tick's name, the event type is <watcher id>,<name>.
+	Name string
+	// Interval is the time between two consecutive ticks.
+	Interval time.Duration
+	// Jitter is the maximum random delay added to every interval, it spreads the
+	// ticks of multiple instances so they don't all fire at once.
+	Jitter time.Duration
+	// Immediate makes the first tick fire right away (plus jitter) instead of
+	// after the first interval.
+	Immediate bool
+}
+
+// TickData is the event data of a tick event.
+type TickData struct {
+	// Name is the tick's name.
+	Name string
+	// Count is the number of times the tick has fired, starting at 1.
+	Count int
+	// Time is when the tick fired.
+	Time time.Time
+}
+
+// tickState is the state of a tick, only accessed by its event type's go routine.
+type tickState struct {
+	tick  Tick
+	count int
+}
+
+// Watcher is the timer event watcher implementation, it emits an event per
+// configured Tick at its interval.
+type Watcher struct {
+	// id is the watcher's id.
+	id string
+	// events are the event types in the ticks' order.
+	events []string
+	// ticks maps the event types to their ticks.
+	ticks map[string]*tickState
+}
+
+// New allocates and initializes a new timer Watcher identified by id firing ticks.
+func New(id string, ticks ...Tick) (*Watcher, error) {
+	if id == "" {
+		return nil, fmt.Errorf("timer watcher id is required")
+	}
+
+	watcher := &Watcher{
+		id:    id,
+		ticks: make(map[string]*tickState),
+	}
+
+	for _, tick := range ticks {
+		if tick.Name == "" {
+			return nil, fmt.Errorf("timer watcher %s: tick name is required", id)
+		}
+
+		if tick.Interval <= 0 {
+			return nil, fmt.Errorf("timer watcher %s: tick %q has invalid interval %s", id, tick.Name, tick.Interval)
+		}
+
+		if tick.Jitter < 0 {
+			return nil, fmt.Errorf("timer watcher %s: tick %q has invalid jitter %s", id, tick.Name, tick.Jitter)
+		}
+
+		evType := watcher.EventType(tick.Name)
+		if _, found := watcher.ticks[evType]; found {
+			return nil, fmt.Errorf("timer watcher %s: duplicated tick %q", id, tick.Name)
+		}
+
+		watcher.ticks[evType] = &tickState{tick: tick}
+		watcher.events = append(watcher.events, evType)
+	}
+
+	if len(watcher.events) == 0 {
+		return nil, fmt.Errorf("timer watcher %s: at least one tick is required", id)
+	}
+
+	return watcher, nil
+}
+
+// EventType returns the event type of the tick named name.
+func (w *Watcher) EventType(name string) string {
+	return w.id + "," + name
+}
+
+// Tick returns the descriptor of the tick named name's event.
+func (w *Watcher) Tick(name string) typed.Event[*TickData] {
+	return typed.New[*TickData](w.EventType(name))
+}
+
+// ID returns the timer event watcher id.
+func (w *Watcher) ID() string {
+	return w.id
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return w.events
+}
+
+// Descriptors returns the descriptors of the implemented events.
+func (w *Watcher) Descriptors() []typed.Descriptor {
+	var descriptors []typed.Descriptor
+	for _, evType := range w.events {
+		descriptors = append(descriptors, typed.New[*TickData](evType))
+	}
+	return descriptors
+}
+
+// Run waits for the next tick of evType and reports back the event.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	state, found := w.ticks[evType]
+	if !found {
+		return false, nil, fmt.Errorf("timer watcher %s: unknown event type %q", w.id, evType)
+	}
+
+	delay := state.tick.Interval
+	if state.count == 0 && state.tick.Immediate {
+		delay = 0
+	}
+	if state.tick.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(state.tick.Jitter)))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	case now := <-timer.C:
+		state.count++
+		return true, &TickData{Name: state.tick.Name, Count: state.count, Time: now}, nil
+	}
+}
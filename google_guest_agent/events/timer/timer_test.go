@@ -0,0 +1,116 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		desc  string
+		id    string
+		ticks []Tick
+	}{
+		{desc: "no_id", ticks: []Tick{{Name: "tick", Interval: time.Second}}},
+		{desc: "no_ticks", id: "timer"},
+		{desc: "no_name", id: "timer", ticks: []Tick{{Interval: time.Second}}},
+		{desc: "invalid_interval", id: "timer", ticks: []Tick{{Name: "tick"}}},
+		{desc: "invalid_jitter", id: "timer", ticks: []Tick{{Name: "tick", Interval: time.Second, Jitter: -time.Second}}},
+		{desc: "duplicated", id: "timer", ticks: []Tick{{Name: "tick", Interval: time.Second}, {Name: "tick", Interval: time.Minute}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := New(tc.id, tc.ticks...); err == nil {
+				t.Errorf("New(%q, %+v) succeeded, expected error.", tc.id, tc.ticks)
+			}
+		})
+	}
+}
+
+func TestWatcherAPI(t *testing.T) {
+	watcher, err := New("timer", Tick{Name: "fast", Interval: time.Second}, Tick{Name: "slow", Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("New() failed unexpectedly with error: %v", err)
+	}
+
+	if watcher.ID() != "timer" {
+		t.Errorf("watcher.ID() returned: %s, expected: timer.", watcher.ID())
+	}
+
+	want := []string{"timer,fast", "timer,slow"}
+	got := watcher.Events()
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("watcher.Events() returned: %v, expected: %v.", got, want)
+	}
+
+	if _, _, err := watcher.Run(context.Background(), "timer,unknown"); err == nil {
+		t.Errorf("watcher.Run(timer,unknown) succeeded, expected error.")
+	}
+}
+
+func TestWatcherTicks(t *testing.T) {
+	interval := 50 * time.Millisecond
+	jitter := 20 * time.Millisecond
+	watcher, err := New("timer", Tick{Name: "tick", Interval: interval, Jitter: jitter, Immediate: true})
+	if err != nil {
+		t.Fatalf("New() failed unexpectedly with error: %v", err)
+	}
+	evType := watcher.EventType("tick")
+
+	for i := 1; i <= 3; i++ {
+		start := time.Now()
+		renew, evData, err := watcher.Run(context.Background(), evType)
+		elapsed := time.Since(start)
+		if err != nil || !renew {
+			t.Fatalf("watcher.Run(%s) = (%t, %v), expected (true, nil).", evType, renew, err)
+		}
+
+		data, ok := evData.(*TickData)
+		if !ok {
+			t.Fatalf("watcher.Run(%s) returned %T, expected *TickData.", evType, evData)
+		}
+		if data.Name != "tick" || data.Count != i {
+			t.Errorf("watcher.Run(%s) returned %+v, expected tick count %d.", evType, data, i)
+		}
+
+		// The first tick is immediate, the following ones wait for the interval.
+		min, max := interval, interval+jitter+time.Second
+		if i == 1 {
+			min, max = 0, jitter+time.Second
+		}
+		if elapsed < min || elapsed > max {
+			t.Errorf("watcher.Run(%s) tick %d fired after %s, expected between %s and %s.", evType, i, elapsed, min, max)
+		}
+	}
+}
+
+func TestWatcherCancel(t *testing.T) {
+	watcher, err := New("timer", Tick{Name: "tick", Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("New() failed unexpectedly with error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	renew, _, err := watcher.Run(ctx, watcher.EventType("tick"))
+	if renew || err == nil {
+		t.Errorf("watcher.Run() with canceled context = (%t, %v), expected (false, error).", renew, err)
+	}
+}
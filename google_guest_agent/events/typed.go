@@ -0,0 +1,109 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// TypedCb is the callback of a typed subscription. The arguments are:
+//   - ctx the app' context passed in from the manager's Run() call.
+//   - evType a string defining the what event type triggered the call.
+//   - data the event data, the zero value of T if the watcher provided none.
+//   - err the error reported by the watcher, if any (see EventData).
+//
+// The callback should return true if it wants to renew, returning false will case the callback
+// to be unregistered/unsubscribed.
+type TypedCb[T any] func(ctx context.Context, evType string, data T, err error) bool
+
+// Subscribe registers cb as a typed subscriber of ev. It fails if the data type of ev
+// doesn't match the one declared by the watcher emitting it or by another typed
+// subscriber.
+func Subscribe[T any](mngr *Manager, ev typed.Event[T], cb TypedCb[T]) (*Subscription, error) {
+	return SubscribeWithOptions(mngr, ev, cb, SubscribeOptions{})
+}
+
+// SubscribeWithOptions registers cb as a typed subscriber of ev like Subscribe(), opts
+// configures the subscriber like Manager.SubscribeWithOptions().
+func SubscribeWithOptions[T any](mngr *Manager, ev typed.Event[T], cb TypedCb[T], opts SubscribeOptions) (*Subscription, error) {
+	if err := mngr.registerDataType(ev); err != nil {
+		return nil, err
+	}
+
+	return mngr.subscribe(ev.ID(), nil, typedCallback(ev, cb), opts, callbackName(cb)), nil
+}
+
+// typedCallback adapts cb to the EventCb interface. Data of an unexpected type is
+// logged and ignored instead of panicking, it can only come from watchers not
+// declaring their events' data type.
+func typedCallback[T any](ev typed.Event[T], cb TypedCb[T]) EventCb {
+	return func(ctx context.Context, evType string, _ interface{}, evData *EventData) bool {
+		var data T
+		if evData.Data != nil {
+			var ok bool
+			if data, ok = evData.Data.(T); !ok {
+				logger.Errorf("Ignoring event %s, got data of type %T, expected %s", evType, evData.Data, ev.DataType())
+				return true
+			}
+		}
+		return cb(ctx, evType, data, evData.Error)
+	}
+}
+
+// registerDataType records the data type of desc's event type, it fails if a
+// different data type was previously registered.
+func (mngr *Manager) registerDataType(desc typed.Descriptor) error {
+	mngr.dataTypesMutex.Lock()
+	defer mngr.dataTypesMutex.Unlock()
+
+	if registered, found := mngr.dataTypes[desc.ID()]; found && registered != desc.DataType() {
+		return fmt.Errorf("event %s has data of type %s, not %s", desc.ID(), registered, desc.DataType())
+	}
+	mngr.dataTypes[desc.ID()] = desc.DataType()
+	return nil
+}
+
+// registerDescriptors records the data types declared by watcher, nothing is recorded
+// if any of them is invalid or doesn't match the registered ones.
+func (mngr *Manager) registerDescriptors(watcher DescribedWatcher) error {
+	events := make(map[string]bool)
+	for _, curr := range watcher.Events() {
+		events[curr] = true
+	}
+
+	mngr.dataTypesMutex.Lock()
+	defer mngr.dataTypesMutex.Unlock()
+
+	declared := make(map[string]reflect.Type)
+	for _, desc := range watcher.Descriptors() {
+		if !events[desc.ID()] {
+			return fmt.Errorf("descriptor of unknown event %s", desc.ID())
+		}
+		if registered, found := mngr.dataTypes[desc.ID()]; found && registered != desc.DataType() {
+			return fmt.Errorf("event %s has data of type %s, not %s", desc.ID(), registered, desc.DataType())
+		}
+		declared[desc.ID()] = desc.DataType()
+	}
+
+	for evType, dataType := range declared {
+		mngr.dataTypes[evType] = dataType
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package typed implements the typed event descriptors, binding event types to the
+// type of their data. Watchers declare them and subscribers use them with the
+// events package's typed subscription API.
+package typed
+
+import (
+	"reflect"
+)
+
+// Descriptor describes an event type and the type of its data, it's implemented
+// by all Event types.
+type Descriptor interface {
+	// ID returns the event type ID.
+	ID() string
+	// DataType returns the type of the event data.
+	DataType() reflect.Type
+}
+
+// Event is the descriptor of an event type whose data is of type T.
+type Event[T any] struct {
+	id string
+}
+
+// New returns the descriptor of the event type id whose data is of type T.
+func New[T any](id string) Event[T] {
+	return Event[T]{id: id}
+}
+
+// ID returns the event type ID.
+func (e Event[T]) ID() string {
+	return e.id
+}
+
+// DataType returns the type of the event data.
+func (e Event[T]) DataType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// String returns the event type ID and its data type.
+func (e Event[T]) String() string {
+	return e.id + "(" + e.DataType().String() + ")"
+}
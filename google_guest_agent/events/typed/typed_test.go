@@ -0,0 +1,51 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package typed
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testData struct{}
+
+func TestEvent(t *testing.T) {
+	ev := New[*testData]("test-watcher,test-event")
+
+	if ev.ID() != "test-watcher,test-event" {
+		t.Errorf("ID() = %q, want test-watcher,test-event", ev.ID())
+	}
+
+	if want := reflect.TypeOf(&testData{}); ev.DataType() != want {
+		t.Errorf("DataType() = %s, want %s", ev.DataType(), want)
+	}
+
+	if got, want := ev.String(), "test-watcher,test-event(*typed.testData)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestEventInterfaceDataType(t *testing.T) {
+	ev := New[error]("test-watcher,test-event")
+
+	if want := reflect.TypeOf((*error)(nil)).Elem(); ev.DataType() != want {
+		t.Errorf("DataType() = %s, want %s", ev.DataType(), want)
+	}
+
+	var desc Descriptor = ev
+	if desc.ID() != ev.ID() {
+		t.Errorf("Descriptor.ID() = %q, want %q", desc.ID(), ev.ID())
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+)
+
+type describedWatcher struct {
+	testWatcher
+	descriptors []typed.Descriptor
+}
+
+func (dw *describedWatcher) Descriptors() []typed.Descriptor {
+	return dw.descriptors
+}
+
+func TestTypedSubscribe(t *testing.T) {
+	var sum, calls atomic.Int32
+
+	runTestManager(t, 4, func(mngr *Manager, evType string) {
+		_, err := Subscribe(mngr, typed.New[*int](evType), func(ctx context.Context, evType string, data *int, err error) bool {
+			calls.Add(1)
+			if data != nil {
+				sum.Add(int32(*data))
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Subscribe() failed: %v", err)
+		}
+	})
+
+	if got := calls.Load(); got != 4 {
+		t.Errorf("typed callback called %d times, expected 4", got)
+	}
+
+	// The last event carries no data, the callback gets a nil pointer.
+	if got := sum.Load(); got != 1+2+3 {
+		t.Errorf("typed callback got data summing to %d, expected 6", got)
+	}
+}
+
+func TestTypedSubscribeWrongData(t *testing.T) {
+	var calls atomic.Int32
+
+	runTestManager(t, 3, func(mngr *Manager, evType string) {
+		_, err := Subscribe(mngr, typed.New[string](evType), func(ctx context.Context, evType string, data string, err error) bool {
+			calls.Add(1)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Subscribe() failed: %v", err)
+		}
+	})
+
+	// Only the last event, without data, is passed through.
+	if got := calls.Load(); got != 1 {
+		t.Errorf("typed callback called %d times, expected 1", got)
+	}
+}
+
+func TestTypedDescriptors(t *testing.T) {
+	ctx := context.Background()
+	evType := "test-watcher,test-event"
+	cb := func(ctx context.Context, evType string, data string, err error) bool { return true }
+
+	tests := []struct {
+		name        string
+		descriptors []typed.Descriptor
+		subscribe   bool
+		wantAdd     bool
+		wantSub     bool
+	}{
+		{
+			name:        "matching",
+			descriptors: []typed.Descriptor{typed.New[string](evType)},
+			wantAdd:     true,
+			wantSub:     true,
+		},
+		{
+			name:        "matching_subscribed_first",
+			descriptors: []typed.Descriptor{typed.New[string](evType)},
+			subscribe:   true,
+			wantAdd:     true,
+			wantSub:     true,
+		},
+		{
+			name:        "mismatch",
+			descriptors: []typed.Descriptor{typed.New[*int](evType)},
+			wantAdd:     true,
+			wantSub:     false,
+		},
+		{
+			name:        "mismatch_subscribed_first",
+			descriptors: []typed.Descriptor{typed.New[*int](evType)},
+			subscribe:   true,
+			wantAdd:     false,
+			wantSub:     true,
+		},
+		{
+			name:        "unknown_event",
+			descriptors: []typed.Descriptor{typed.New[string]("test-watcher,unknown")},
+			wantAdd:     false,
+			wantSub:     true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mngr := newManager()
+			watcher := &describedWatcher{
+				testWatcher: testWatcher{watcherID: "test-watcher", maxCount: 1},
+				descriptors: tc.descriptors,
+			}
+
+			subscribe := func() {
+				if _, err := Subscribe(mngr, typed.New[string](evType), cb); (err == nil) != tc.wantSub {
+					t.Errorf("Subscribe() = %v, expected success: %t", err, tc.wantSub)
+				}
+			}
+
+			if tc.subscribe {
+				subscribe()
+			}
+
+			if err := mngr.AddWatcher(ctx, watcher); (err == nil) != tc.wantAdd {
+				t.Errorf("AddWatcher() = %v, expected success: %t", err, tc.wantAdd)
+			}
+
+			if !tc.subscribe {
+				subscribe()
+			}
+		})
+	}
+}
+
+func TestTypedSubscribeDebounce(t *testing.T) {
+	var calls atomic.Int32
+
+	runTestManager(t, 5, func(mngr *Manager, evType string) {
+		opts := SubscribeOptions{Debounce: DebounceOptions{QuietPeriod: 50 * time.Millisecond}}
+		_, err := SubscribeWithOptions(mngr, typed.New[*int](evType), func(ctx context.Context, evType string, data *int, err error) bool {
+			calls.Add(1)
+			return true
+		}, opts)
+		if err != nil {
+			t.Fatalf("SubscribeWithOptions() failed: %v", err)
+		}
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("debounced callback called %d times, expected 1", got)
+	}
+}
@@ -88,7 +88,27 @@ func (s MDSClient) Watch(context.Context) (*metadata.Descriptor, error) {
 	return nil, fmt.Errorf("not yet implemented")
 }
 
+// WatchKey method implements fake key watcher on MDS.
+func (s MDSClient) WatchKey(context.Context, string, string) (string, string, error) {
+	return "", "", fmt.Errorf("not yet implemented")
+}
+
 // WriteGuestAttributes method implements fake writer on MDS.
 func (s MDSClient) WriteGuestAttributes(context.Context, string, string) error {
 	return fmt.Errorf("not yet implemented")
 }
+
+// GetGuestAttribute method implements fake guest attribute getter on MDS.
+func (s MDSClient) GetGuestAttribute(context.Context, string) (string, error) {
+	return "", fmt.Errorf("not yet implemented")
+}
+
+// ListGuestAttributes method implements fake guest attribute lister on MDS.
+func (s MDSClient) ListGuestAttributes(context.Context, string) (map[string]string, error) {
+	return nil, fmt.Errorf("not yet implemented")
+}
+
+// DeleteGuestAttribute method implements fake guest attribute deleter on MDS.
+func (s MDSClient) DeleteGuestAttribute(context.Context, string) error {
+	return fmt.Errorf("not yet implemented")
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// guestAttributesJobID is the scheduler id of the guest attributes flush job.
+	guestAttributesJobID = "guestAttributesFlushJobID"
+	// guestAttributesFlushInterval is how often queued guest attribute writes are retried.
+	guestAttributesFlushInterval = time.Minute
+)
+
+// guestAttributesJob implements job scheduler interface for retrying the guest
+// attribute writes and deletes that failed to reach the metadata server.
+type guestAttributesJob struct {
+	client *metadata.Client
+}
+
+// ID returns the ID for this job.
+func (j *guestAttributesJob) ID() string {
+	return guestAttributesJobID
+}
+
+// Interval returns the interval at which job is executed.
+func (j *guestAttributesJob) Interval() (time.Duration, bool) {
+	return guestAttributesFlushInterval, false
+}
+
+// ShouldEnable always returns true, the job is a no-op while the queue is empty.
+func (j *guestAttributesJob) ShouldEnable(ctx context.Context) bool {
+	return true
+}
+
+// Run flushes the queued guest attribute operations, failures are retried on the
+// next run.
+func (j *guestAttributesJob) Run(ctx context.Context) (bool, error) {
+	return true, j.client.FlushGuestAttributes(ctx)
+}
+
+// logGuestAttributeError logs err, returned by a guest attribute write or delete,
+// prefixed with msg. Operations queued for retry are only logged as a warning.
+func logGuestAttributeError(msg string, err error) {
+	if errors.Is(err, metadata.ErrGuestAttributeQueued) {
+		logger.Warningf("%s: %v", msg, err)
+		return
+	}
+	logger.Errorf("%s: %v", msg, err)
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hooks runs the user provided executables of a hook directory when an
+// event happens, i.e. /etc/google/maintenance.d before a live migration.
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// timeoutExitCode is the exit code reported by run for timed out commands.
+	timeoutExitCode = 124
+
+	// maxOutput is the maximum length of a hook's output kept in its Result.
+	maxOutput = 1024
+)
+
+// Result is the outcome of a hook run.
+type Result struct {
+	// Name is the hook's file name.
+	Name string `json:"name"`
+	// ExitCode is the hook's exit code, -1 if it couldn't be started.
+	ExitCode int `json:"exitCode"`
+	// Duration is how long the hook ran, it's marshaled to JSON as a duration string.
+	Duration time.Duration `json:"-"`
+	// TimedOut is true if the hook was killed because the time budget elapsed.
+	TimedOut bool `json:"timedOut,omitempty"`
+	// Skipped is true if the hook wasn't run because the time budget elapsed.
+	Skipped bool `json:"skipped,omitempty"`
+	// Output is the hook's standard error if it failed, its standard output
+	// otherwise. It's truncated to maxOutput bytes.
+	Output string `json:"output,omitempty"`
+}
+
+// Report is the outcome of the hooks run for an event, as reported to the guest
+// attributes.
+type Report struct {
+	// Event is the event type the hooks were run for.
+	Event string `json:"event"`
+	// Time is when the hooks were started.
+	Time time.Time `json:"time"`
+	// Hooks are the results of the hooks in the order they were run.
+	Hooks []Result `json:"hooks"`
+}
+
+// MarshalJSON marshals r with its duration formatted as a duration string, i.e. 1.5s.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		result
+		Duration string `json:"duration"`
+	}{result(r), r.Duration.String()})
+}
+
+// Succeeded returns true if the hook ran and exited with 0.
+func (r Result) Succeeded() bool {
+	return !r.Skipped && !r.TimedOut && r.ExitCode == 0
+}
+
+// String returns a human readable representation of the result.
+func (r Result) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("%s: skipped", r.Name)
+	case r.TimedOut:
+		return fmt.Sprintf("%s: timed out after %s", r.Name, r.Duration)
+	default:
+		return fmt.Sprintf("%s: exit code %d after %s", r.Name, r.ExitCode, r.Duration)
+	}
+}
+
+// List returns the paths of the hooks of dir in lexical order. Only executable
+// regular files are hooks, hidden files are ignored. A missing dir has no hooks.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hook directory %s: %w", dir, err)
+	}
+
+	var res []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			logger.Debugf("Skipping hook %s: %v", entry.Name(), err)
+			continue
+		}
+
+		if !isExecutable(info) {
+			logger.Debugf("Skipping hook %s, it's not executable", entry.Name())
+			continue
+		}
+
+		res = append(res, filepath.Join(dir, entry.Name()))
+	}
+
+	sort.Strings(res)
+	return res, nil
+}
+
+// Run runs the hooks of dir one after the other in lexical order, they must all
+// complete within budget. Every hook is called with evType, its remaining time
+// budget in seconds and args as arguments, a hook still running once the budget
+// elapsed is killed and the following ones are skipped.
+func Run(ctx context.Context, dir string, evType string, budget time.Duration, args ...string) ([]Result, error) {
+	paths, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(budget)
+	var res []Result
+
+	for _, path := range paths {
+		result := Result{Name: filepath.Base(path)}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 || ctx.Err() != nil {
+			result.Skipped = true
+			result.ExitCode = -1
+			logger.Warningf("Skipping %s hook %s, the time budget elapsed", evType, result.Name)
+			res = append(res, result)
+			continue
+		}
+
+		seconds := strconv.Itoa(int(math.Ceil(remaining.Seconds())))
+		start := time.Now()
+		out := run.WithOutputTimeout(ctx, remaining, path, append([]string{evType, seconds}, args...)...)
+		result.Duration = time.Since(start)
+		result.ExitCode = out.ExitCode
+		result.TimedOut = out.ExitCode == timeoutExitCode && time.Until(deadline) <= 0
+
+		result.Output = out.StdOut
+		if out.ExitCode != 0 {
+			result.Output = out.StdErr
+		}
+		result.Output = truncate(strings.TrimSpace(result.Output), maxOutput)
+
+		if result.Succeeded() {
+			logger.Infof("Hook %s for %s: %s", path, evType, result)
+		} else {
+			logger.Errorf("Hook %s for %s failed: %s: %s", path, evType, result, result.Output)
+		}
+
+		res = append(res, result)
+	}
+
+	return res, nil
+}
+
+// truncate returns s cut to n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
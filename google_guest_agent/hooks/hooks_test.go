@@ -0,0 +1,158 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+)
+
+// hooksMockRunner records the commands it's asked to run and reports the exit
+// codes of exitCodes, sleeping for delay before returning.
+type hooksMockRunner struct {
+	run.Runner
+	exitCodes map[string]int
+	delay     time.Duration
+	calls     [][]string
+}
+
+func (r *hooksMockRunner) WithOutputTimeout(ctx context.Context, timeout time.Duration, name string, args ...string) *run.Result {
+	r.calls = append(r.calls, append([]string{filepath.Base(name)}, args...))
+
+	if r.delay > timeout {
+		time.Sleep(timeout)
+		return &run.Result{ExitCode: timeoutExitCode}
+	}
+	time.Sleep(r.delay)
+
+	code := r.exitCodes[filepath.Base(name)]
+	if code != 0 {
+		return &run.Result{ExitCode: code, StdErr: fmt.Sprintf("%s failed\n", name)}
+	}
+	return &run.Result{StdOut: "done\n"}
+}
+
+// writeHooks creates the hooks names in dir, executable unless prefixed with "-".
+func writeHooks(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		mode := os.FileMode(0755)
+		if name[0] == '-' {
+			name, mode = name[1:], 0644
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatalf("failed to write hook %s: %v", name, err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are identified by their extension on windows")
+	}
+
+	dir := t.TempDir()
+	writeHooks(t, dir, "20-checkpoint", "10-drain", "-30-disabled", ".hidden")
+	if err := os.Mkdir(filepath.Join(dir, "40-dir"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	got, err := List(dir)
+	if err != nil {
+		t.Fatalf("List(%s) failed: %v", dir, err)
+	}
+
+	want := []string{filepath.Join(dir, "10-drain"), filepath.Join(dir, "20-checkpoint")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List(%s) = %v, want %v", dir, got, want)
+	}
+
+	if got, err := List(filepath.Join(dir, "missing")); err != nil || got != nil {
+		t.Errorf("List() of a missing directory = %v, %v, want no hooks and no error", got, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are identified by their extension on windows")
+	}
+
+	dir := t.TempDir()
+	writeHooks(t, dir, "10-drain", "20-checkpoint")
+
+	runner := &hooksMockRunner{exitCodes: map[string]int{"20-checkpoint": 3}}
+	run.Client = runner
+	defer func() { run.Client = &run.Runner{} }()
+
+	results, err := Run(context.Background(), dir, "test-event", time.Minute, "extra")
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	wantCalls := [][]string{{"10-drain", "test-event", "60", "extra"}, {"20-checkpoint", "test-event", "60", "extra"}}
+	if !reflect.DeepEqual(runner.calls, wantCalls) {
+		t.Errorf("Run() called %v, want %v", runner.calls, wantCalls)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Run() returned %d results, want 2", len(results))
+	}
+
+	if !results[0].Succeeded() || results[0].Output != "done" {
+		t.Errorf("Run() result[0] = %+v, want success with output \"done\"", results[0])
+	}
+
+	if results[1].Succeeded() || results[1].ExitCode != 3 {
+		t.Errorf("Run() result[1] = %+v, want exit code 3", results[1])
+	}
+}
+
+func TestRunBudget(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are identified by their extension on windows")
+	}
+
+	dir := t.TempDir()
+	writeHooks(t, dir, "10-slow", "20-skipped")
+
+	run.Client = &hooksMockRunner{delay: time.Second}
+	defer func() { run.Client = &run.Runner{} }()
+
+	results, err := Run(context.Background(), dir, "test-event", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Run() returned %d results, want 2", len(results))
+	}
+
+	if !results[0].TimedOut {
+		t.Errorf("Run() result[0] = %+v, want timed out", results[0])
+	}
+
+	if !results[1].Skipped {
+		t.Errorf("Run() result[1] = %+v, want skipped", results[1])
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// executableExtensions are the file extensions windows can execute directly.
+var executableExtensions = map[string]bool{
+	".exe": true,
+	".bat": true,
+	".cmd": true,
+}
+
+// isExecutable returns true if info has an executable extension, windows has no
+// execute permission bits.
+func isExecutable(info os.FileInfo) bool {
+	return executableExtensions[strings.ToLower(filepath.Ext(info.Name()))]
+}
@@ -152,9 +152,10 @@ func agentInit(ctx context.Context) {
 		if newMetadata == nil {
 			var err error
 			logger.Debugf("populate metadata for the first time...")
-			newMetadata, err = mdsClient.Get(ctx)
+			// Fallback to the last known good metadata, MDS may come up late in some networks.
+			newMetadata, newMetadataVerified, err = mdsClient.GetWithFallback(ctx)
 			if err != nil {
-				logger.Errorf("Failed to reach MDS(all retries exhausted): %+v", err)
+				logger.Errorf("Failed to reach MDS(all retries exhausted) and no cached metadata available: %+v", err)
 				os.Exit(1)
 			}
 		}
@@ -163,8 +164,7 @@ func agentInit(ctx context.Context) {
 		runManager(ctx, addressManager)
 
 		// Disable overcommit accounting; e2 instances only.
-		parts := strings.Split(newMetadata.Instance.MachineType, "/")
-		if strings.HasPrefix(parts[len(parts)-1], "e2-") {
+		if strings.HasPrefix(newMetadata.Instance.MachineTypeName(), "e2-") {
 			if err := run.Quiet(ctx, "sysctl", "vm.overcommit_memory=1"); err != nil {
 				logger.Warningf("Failed to run 'sysctl vm.overcommit_memory=1': %v", err)
 			}
@@ -177,6 +177,9 @@ func agentInit(ctx context.Context) {
 		instanceID, err := os.ReadFile(instanceIDFile)
 		if err != nil && !os.IsNotExist(err) {
 			logger.Warningf("Not running first-boot actions, error reading instance ID: %v", err)
+		} else if !newMetadataVerified {
+			// The cached instance ID may be the one of the instance the disk was cloned from.
+			logger.Warningf("Not running first-boot actions, cached metadata couldn't be verified to belong to this instance")
 		} else {
 			if string(instanceID) == "" {
 				// If the file didn't exist or was empty, try legacy key from instance configs.
@@ -283,7 +286,7 @@ func generateSSHKeys(ctx context.Context) error {
 		}
 		if vals := strings.Split(string(pubKey), " "); len(vals) >= 2 {
 			if err := mdsClient.WriteGuestAttributes(ctx, "hostkeys/"+vals[0], vals[1]); err != nil {
-				logger.Errorf("Failed to upload %s key to guest attributes: %v", keytype, err)
+				logGuestAttributeError(fmt.Sprintf("Failed to upload %s key to guest attributes", keytype), err)
 			}
 		} else {
 			logger.Warningf("Generated key is malformed, not uploading")
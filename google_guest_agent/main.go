@@ -28,7 +28,14 @@ import (
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	configEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/config"
+	diskEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/disk"
+	maintenanceEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/maintenance"
 	mdsEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/metadata"
+	netlinkEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/netlink"
+	preemptionEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/preemption"
+	signalEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/signal"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/osinfo"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/telemetry"
@@ -54,6 +61,14 @@ var (
 	osInfo                   osinfo.OSInfo
 	mdsClient                *metadata.Client
 	addressManager           = &addressMgr{}
+
+	// newMetadataVerified is false while newMetadata was read from the metadata cache
+	// and couldn't be verified to belong to this instance.
+	newMetadataVerified bool
+
+	// updateMutex serializes the runUpdate() calls and protects oldMetadata and
+	// newMetadata once the event manager is running.
+	updateMutex sync.Mutex
 )
 
 const (
@@ -105,7 +120,23 @@ func availableManagers() []manager {
 	)
 }
 
+// requiresVerifiedMetadata reports whether mgr sets up user accounts or SSH access,
+// which must not be set up from metadata that may belong to another instance.
+func requiresVerifiedMetadata(mgr manager) bool {
+	switch mgr.(type) {
+	case *accountsMgr, *winAccountsMgr, *osloginMgr:
+		return true
+	default:
+		return false
+	}
+}
+
 func runManager(ctx context.Context, mgr manager) {
+	if !newMetadataVerified && requiresVerifiedMetadata(mgr) {
+		logger.Warningf("[%#v] Cached metadata couldn't be verified to belong to this instance, skipping manager", mgr)
+		return
+	}
+
 	disabled, err := mgr.Disabled(ctx)
 	if err != nil {
 		logger.Errorf("Failed to run manager's Disabled() call: %+v", err)
@@ -152,6 +183,66 @@ func runUpdate(ctx context.Context) {
 	wg.Wait()
 }
 
+// metadataEndpointsChanged reports whether changes affect the metadata server
+// endpoints.
+func metadataEndpointsChanged(changes []cfg.Change) bool {
+	for _, change := range changes {
+		if change.Section == "MDS" && change.Key == "endpoints" {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataDebounceOptions returns the configured metadata events debouncing options,
+// invalid durations fall back to no debouncing. It's called on every metadata event,
+// so configuration reloads take effect right away.
+func metadataDebounceOptions() events.DebounceOptions {
+	var opts events.DebounceOptions
+	config := cfg.Get().MDS
+	if config.EventQuietPeriod == "" {
+		return opts
+	}
+
+	quietPeriod, err := time.ParseDuration(config.EventQuietPeriod)
+	if err != nil {
+		logger.Errorf("Invalid metadata event quiet period %q, disabling debouncing: %v", config.EventQuietPeriod, err)
+		return opts
+	}
+	opts.QuietPeriod = quietPeriod
+
+	if config.EventMaxDelay != "" {
+		maxDelay, err := time.ParseDuration(config.EventMaxDelay)
+		if err != nil {
+			logger.Errorf("Invalid metadata event max delay %q, not bounding the delay: %v", config.EventMaxDelay, err)
+		} else {
+			opts.MaxDelay = maxDelay
+		}
+	}
+
+	return opts
+}
+
+// fullReconcile re-runs all the managers as if all metadata had changed, i.e. after a
+// configuration change.
+func fullReconcile(ctx context.Context) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	if newMetadata == nil {
+		logger.Debugf("No metadata available yet, skipping reconciliation.")
+		return
+	}
+
+	oldMetadata = &metadata.Descriptor{}
+	runUpdate(ctx)
+	// The managers skipped for unverified metadata must see all of it as changed once
+	// the metadata server is reached.
+	if newMetadataVerified {
+		oldMetadata = newMetadata
+	}
+}
+
 func runAgent(ctx context.Context) {
 	opts := logger.LogOpts{LoggerName: programName}
 	if runtime.GOOS == "windows" {
@@ -179,7 +270,14 @@ func runAgent(ctx context.Context) {
 	logger.Infof("GCE Agent Started (version %s)", version)
 
 	osInfo = osinfo.Get()
+
+	if err := metadata.SetEndpoints(strings.Split(cfg.Get().MDS.Endpoints, ",")); err != nil {
+		logger.Errorf("Invalid metadata endpoints configuration, using the default endpoint: %v", err)
+	}
+
 	mdsClient = metadata.New()
+	mdsClient.SetCache(metadata.NewCache(""))
+	mdsClient.SetGuestAttributeQueue(metadata.NewGuestAttributeQueue(""))
 
 	agentInit(ctx)
 
@@ -187,12 +285,13 @@ func runAgent(ctx context.Context) {
 		command.Init(ctx)
 		defer command.Close()
 	}
+	registerCommandHandlers()
 
 	// Previous request to metadata *may* not have worked becasue routes don't get added until agentInit.
 	var err error
 	if newMetadata == nil {
 		// Error here doesn't matter, if we cant get metadata, we cant record telemetry.
-		newMetadata, err = mdsClient.Get(ctx)
+		newMetadata, newMetadataVerified, err = mdsClient.GetWithFallback(ctx)
 		if err != nil {
 			logger.Debugf("Error getting metdata: %v", err)
 		}
@@ -208,7 +307,7 @@ func runAgent(ctx context.Context) {
 	}
 
 	// knownJobs is list of default jobs that run on a pre-defined schedule.
-	knownJobs := []scheduler.Job{telemetry.New(mdsClient, programName, version)}
+	knownJobs := []scheduler.Job{telemetry.New(mdsClient, programName, version), &guestAttributesJob{client: mdsClient}}
 	scheduler.ScheduleJobs(ctx, knownJobs, false)
 
 	eventManager := events.Get()
@@ -223,22 +322,36 @@ func runAgent(ctx context.Context) {
 	}
 
 	oldMetadata = &metadata.Descriptor{}
-	eventManager.Subscribe(mdsEvent.LongpollEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+	// Metadata changes are debounced so bursts of changes are handled by a single
+	// runUpdate() with the latest metadata.
+	_, err = events.SubscribeWithOptions(eventManager, mdsEvent.Longpoll, func(ctx context.Context, evType string, descriptor *metadata.Descriptor, err error) bool {
 		logger.Debugf("Handling metadata %q event.", evType)
 
 		// If metadata watcher failed there isn't much we can do, just ignore the event and
 		// allow the water to get it corrected.
-		if evData.Error != nil {
-			logger.Infof("Metadata event watcher failed, ignoring: %+v", evData.Error)
+		if err != nil {
+			logger.Infof("Metadata event watcher failed, ignoring: %+v", err)
 			return true
 		}
 
-		if evData.Data == nil {
+		if descriptor == nil {
 			logger.Infof("Metadata event watcher didn't pass in the metadata, ignoring.")
 			return true
 		}
 
-		newMetadata = evData.Data.(*metadata.Descriptor)
+		updateMutex.Lock()
+		defer updateMutex.Unlock()
+
+		newMetadata = descriptor
+		newMetadataVerified = true
+
+		var changed []string
+		for _, change := range oldMetadata.Diff(newMetadata) {
+			changed = append(changed, change.Path)
+		}
+		if len(changed) > 0 {
+			logger.Debugf("Metadata changed: %s", strings.Join(changed, ", "))
+		}
 
 		if err := enableDisableOSLoginCertAuth(ctx); err != nil {
 			logger.Errorf("Failed to enable/disable sshtrustedca watcher: %+v", err)
@@ -247,8 +360,92 @@ func runAgent(ctx context.Context) {
 		runUpdate(ctx)
 		oldMetadata = newMetadata
 
+		return true
+	}, events.SubscribeOptions{DebounceFunc: metadataDebounceOptions})
+	if err != nil {
+		logger.Errorf("Failed to subscribe to metadata events: %v", err)
+	}
+
+	if err := eventManager.AddWatcher(ctx, configEvent.New()); err != nil {
+		logger.Errorf("Failed to add configuration watcher: %v", err)
+	}
+
+	_, err = events.Subscribe(eventManager, configEvent.Changed, func(ctx context.Context, evType string, changes []cfg.Change, err error) bool {
+		if err != nil {
+			logger.Errorf("Configuration reload failed, ignoring: %v", err)
+			return true
+		}
+
+		applyConfigChanges(ctx, changes)
 		return true
 	})
+	if err != nil {
+		logger.Errorf("Failed to subscribe to configuration events: %v", err)
+	}
+
+	// SIGHUP reloads the configuration and SIGUSR1 dumps the agent's state, windows
+	// services don't get these signals.
+	if runtime.GOOS != "windows" {
+		if err := eventManager.AddWatcher(ctx, signalEvent.New()); err != nil {
+			logger.Errorf("Failed to add signal watcher: %v", err)
+		} else {
+			signalCb := func(ctx context.Context, evType string, sig os.Signal, err error) bool {
+				logger.Infof("Received %s signal.", sig)
+				if evType == signalEvent.ReloadEvent {
+					reloadAndReconcile(ctx)
+				} else {
+					dumpState(ctx)
+				}
+				return true
+			}
+			for _, ev := range []typed.Event[os.Signal]{signalEvent.Reload, signalEvent.Dump} {
+				if _, err := events.Subscribe(eventManager, ev, signalCb); err != nil {
+					logger.Errorf("Failed to subscribe to signal events: %v", err)
+				}
+			}
+		}
+	}
+
+	// Latency sensitive workloads drain and checkpoint from their maintenance hooks
+	// before a live migration or a host maintenance termination.
+	if err := eventManager.AddWatcher(ctx, maintenanceEvent.New()); err != nil {
+		logger.Errorf("Failed to add maintenance watcher: %v", err)
+	} else {
+		subscribeMaintenanceHooks(eventManager)
+	}
+
+	// Preemptible and spot instances get a short notice before being stopped, the
+	// preemption hooks checkpoint the workloads within it.
+	if err := eventManager.AddWatcher(ctx, preemptionEvent.New()); err != nil {
+		logger.Errorf("Failed to add preemption watcher: %v", err)
+	} else {
+		subscribePreemptionHooks(eventManager)
+	}
+
+	// Hot-plugged disks, interfaces and lost routes are handled as they happen instead
+	// of waiting for the next metadata change.
+	if runtime.GOOS == "linux" {
+		if err := eventManager.AddWatcher(ctx, diskEvent.New()); err != nil {
+			logger.Errorf("Failed to add disk watcher: %v", err)
+		} else {
+			subscribeDiskHooks(eventManager)
+		}
+
+		if err := eventManager.AddWatcher(ctx, netlinkEvent.New()); err != nil {
+			logger.Errorf("Failed to add netlink watcher: %v", err)
+		} else {
+			// The changes are recorded before the event is handed to the debounced
+			// resync, so none is lost when a burst of changes is coalesced.
+			for _, ev := range []typed.Event[*netlinkEvent.Change]{netlinkEvent.Link, netlinkEvent.Address, netlinkEvent.Route} {
+				if _, err := events.SubscribeWithOptions(eventManager, ev, addressManager.recordNetworkEvent, events.SubscribeOptions{Priority: 1}); err != nil {
+					logger.Errorf("Failed to subscribe to netlink events: %v", err)
+				}
+				if _, err := events.SubscribeWithOptions(eventManager, ev, addressManager.resyncInterfaces, events.SubscribeOptions{Debounce: networkDebounceOptions}); err != nil {
+					logger.Errorf("Failed to subscribe to netlink events: %v", err)
+				}
+			}
+		}
+	}
 
 	if err := eventManager.Run(ctx); err != nil {
 		logger.Fatalf("Failed to run event manager: %+v", err)
@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	maintenanceEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/maintenance"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/typed"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/hooks"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// maintenanceHooksAttribute is the guest attribute the maintenance hooks results
+	// are reported to.
+	maintenanceHooksAttribute = "guest-agent/maintenance-hooks"
+	// defaultMaintenanceTimeout is the maintenance hooks time budget used when the
+	// configured one is invalid.
+	defaultMaintenanceTimeout = 60 * time.Second
+)
+
+var (
+	// maintenanceHooksMutex serializes the maintenance hooks runs, each maintenance
+	// event type has its own subscriber and they run concurrently.
+	maintenanceHooksMutex sync.Mutex
+)
+
+// subscribeMaintenanceHooks runs the maintenance hooks on the maintenance watcher's
+// events.
+func subscribeMaintenanceHooks(eventManager *events.Manager) {
+	for _, ev := range []typed.Event[*maintenanceEvent.Event]{maintenanceEvent.BeforeMigration, maintenanceEvent.AfterMigration, maintenanceEvent.BeforeTermination} {
+		if _, err := events.Subscribe(eventManager, ev, runMaintenanceHooks); err != nil {
+			logger.Errorf("Failed to subscribe to maintenance events: %v", err)
+		}
+	}
+}
+
+// runMaintenanceHooks runs the maintenance hooks for evType and reports their
+// results to the guest attributes, one event at a time.
+func runMaintenanceHooks(ctx context.Context, evType string, ev *maintenanceEvent.Event, err error) bool {
+	if err != nil {
+		logger.Errorf("Maintenance event watcher failed, ignoring: %v", err)
+		return true
+	}
+
+	config := cfg.Get().Hooks
+	if config == nil || config.MaintenanceDir == "" {
+		return true
+	}
+
+	// A before-migration run may still be going when the after-migration event comes.
+	maintenanceHooksMutex.Lock()
+	defer maintenanceHooksMutex.Unlock()
+
+	timeout := hooksTimeout("maintenance", config.MaintenanceTimeout, defaultMaintenanceTimeout)
+	logger.Infof("Running %s hooks (%s) with a %s budget", evType, ev, timeout)
+	report := hooks.Report{Event: evType, Time: time.Now()}
+	report.Hooks, err = hooks.Run(ctx, config.MaintenanceDir, evType, timeout)
+	if err != nil {
+		logger.Errorf("Failed to run maintenance hooks: %v", err)
+		return true
+	}
+
+	if len(report.Hooks) == 0 {
+		logger.Debugf("No maintenance hooks in %s", config.MaintenanceDir)
+		return true
+	}
+
+	if err := writeJSONGuestAttribute(ctx, mdsClient, maintenanceHooksAttribute, report); err != nil {
+		logGuestAttributeError("Failed to report maintenance hooks results", err)
+	}
+
+	return true
+}
+
+// hooksTimeout parses the configured time budget of the kind hooks, def is returned
+// if it's empty or invalid.
+func hooksTimeout(kind string, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Errorf("Invalid %s hooks timeout %q, using %s: %v", kind, value, def, err)
+		return def
+	}
+	return timeout
+}
+
+// writeJSONGuestAttribute writes v as JSON to the guest attribute key.
+func writeJSONGuestAttribute(ctx context.Context, client metadata.MDSClientInterface, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal guest attribute %s: %w", key, err)
+	}
+	return client.WriteGuestAttributes(ctx, key, string(value))
+}
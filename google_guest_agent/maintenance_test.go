@@ -0,0 +1,57 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/fakes"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/hooks"
+)
+
+type guestAttributesClient struct {
+	fakes.MDSClient
+	attributes map[string]string
+}
+
+func (c *guestAttributesClient) WriteGuestAttributes(ctx context.Context, key, value string) error {
+	c.attributes[key] = value
+	return nil
+}
+
+func TestWriteJSONGuestAttribute(t *testing.T) {
+	client := &guestAttributesClient{attributes: make(map[string]string)}
+	report := hooks.Report{
+		Event: "maintenance-watcher,before-migration",
+		Time:  time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		Hooks: []hooks.Result{
+			{Name: "10-drain", Duration: 1500 * time.Millisecond, Output: "drained"},
+			{Name: "20-checkpoint", ExitCode: -1, Skipped: true},
+		},
+	}
+
+	if err := writeJSONGuestAttribute(context.Background(), client, maintenanceHooksAttribute, report); err != nil {
+		t.Fatalf("writeJSONGuestAttribute() failed: %v", err)
+	}
+
+	want := `{"event":"maintenance-watcher,before-migration","time":"2023-10-01T12:00:00Z","hooks":[` +
+		`{"name":"10-drain","exitCode":0,"output":"drained","duration":"1.5s"},` +
+		`{"name":"20-checkpoint","exitCode":-1,"skipped":true,"duration":"0s"}]}`
+	if got := client.attributes[maintenanceHooksAttribute]; got != want {
+		t.Errorf("writeJSONGuestAttribute() wrote %s, want %s", got, want)
+	}
+}
@@ -175,7 +175,9 @@ func (o *osloginMgr) Set(ctx context.Context) error {
 	}
 
 	now := fmt.Sprintf("%d", time.Now().Unix())
-	mdsClient.WriteGuestAttributes(ctx, "guest-agent/sshable", now)
+	if err := mdsClient.WriteGuestAttributes(ctx, "guest-agent/sshable", now); err != nil {
+		logGuestAttributeError("Failed to write sshable guest attribute", err)
+	}
 
 	if enable {
 		logger.Debugf("Create OS Login dirs, if needed")
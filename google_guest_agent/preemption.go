@@ -0,0 +1,130 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	preemptionEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/preemption"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/hooks"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// preemptionTimelineAttribute is the guest attribute the preemption timeline is
+	// reported to.
+	preemptionTimelineAttribute = "guest-agent/preemption-timeline"
+	// defaultPreemptionTimeout is the preemption hooks time budget used when the
+	// configured one is invalid, it leaves some of the notice window to the shutdown.
+	defaultPreemptionTimeout = 25 * time.Second
+)
+
+// preemptionTimeline records the handling of a preemption.
+type preemptionTimeline struct {
+	// Preempted is when the preemption was detected.
+	Preempted time.Time `json:"preempted"`
+	// Deadline is when the instance is expected to be stopped.
+	Deadline time.Time `json:"deadline"`
+	// HooksStarted is when the hooks were started, zero if none were run.
+	HooksStarted time.Time `json:"hooksStarted"`
+	// HooksFinished is when the last hook finished, zero if none were run.
+	HooksFinished time.Time `json:"hooksFinished"`
+	// Hooks are the results of the hooks in the order they were run.
+	Hooks []hooks.Result `json:"hooks"`
+}
+
+// subscribePreemptionHooks runs the preemption hooks on the preemption watcher's
+// events. The other subscribers of the events don't wait for the hooks, they have as
+// little of the notice window left as the hooks.
+func subscribePreemptionHooks(eventManager *events.Manager) {
+	if _, err := events.Subscribe(eventManager, preemptionEvent.Preempted, runPreemptionHooks); err != nil {
+		logger.Errorf("Failed to subscribe to preemption events: %v", err)
+	}
+}
+
+// runPreemptionHooks runs the preemption hooks, they must complete before the end
+// of the preemption notice window. The timeline is logged and reported to the guest
+// attributes as soon as the preemption is detected, without holding back the hooks,
+// and again once the hooks are done.
+func runPreemptionHooks(ctx context.Context, evType string, ev *preemptionEvent.Event, err error) bool {
+	if err != nil {
+		logger.Errorf("Preemption event watcher failed, ignoring: %v", err)
+		return true
+	}
+
+	timeline := preemptionTimeline{Preempted: ev.Time, Deadline: ev.Deadline()}
+	logger.Warningf("Preemption detected at %s, deadline %s", formatTime(timeline.Preempted), formatTime(timeline.Deadline))
+	reported := make(chan struct{})
+	go func(timeline preemptionTimeline) {
+		defer close(reported)
+		reportPreemptionTimeline(ctx, timeline)
+	}(timeline)
+
+	config := cfg.Get().Hooks
+	if config == nil || config.PreemptionDir == "" {
+		return true
+	}
+
+	budget := preemptionBudget(config.PreemptionTimeout, timeline.Deadline)
+	timeline.HooksStarted = time.Now()
+	timeline.Hooks, err = hooks.Run(ctx, config.PreemptionDir, evType, budget)
+	timeline.HooksFinished = time.Now()
+	if err != nil {
+		logger.Errorf("Failed to run preemption hooks: %v", err)
+		return true
+	}
+
+	if len(timeline.Hooks) == 0 {
+		logger.Debugf("No preemption hooks in %s", config.PreemptionDir)
+		return true
+	}
+
+	var succeeded int
+	for _, result := range timeline.Hooks {
+		if result.Succeeded() {
+			succeeded++
+		}
+	}
+
+	logger.Infof("Preemption hooks started at %s, finished at %s with %s left before the deadline, %d of %d succeeded",
+		formatTime(timeline.HooksStarted), formatTime(timeline.HooksFinished),
+		timeline.Deadline.Sub(timeline.HooksFinished).Round(time.Millisecond), succeeded, len(timeline.Hooks))
+
+	// The first report must not override this one.
+	<-reported
+	reportPreemptionTimeline(ctx, timeline)
+
+	return true
+}
+
+// preemptionBudget returns the preemption hooks time budget, the configured timeout
+// capped to what's left until deadline.
+func preemptionBudget(timeout string, deadline time.Time) time.Duration {
+	budget := hooksTimeout("preemption", timeout, defaultPreemptionTimeout)
+	if remaining := time.Until(deadline); remaining < budget {
+		budget = remaining
+	}
+	return budget
+}
+
+// reportPreemptionTimeline writes timeline to the preemption guest attribute.
+func reportPreemptionTimeline(ctx context.Context, timeline preemptionTimeline) {
+	if err := writeJSONGuestAttribute(ctx, mdsClient, preemptionTimelineAttribute, timeline); err != nil {
+		logGuestAttributeError("Failed to report preemption timeline", err)
+	}
+}
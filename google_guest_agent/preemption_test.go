@@ -0,0 +1,45 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreemptionBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  string
+		deadline time.Duration
+		min      time.Duration
+		max      time.Duration
+	}{
+		{"configured", "10s", time.Minute, 10 * time.Second, 10 * time.Second},
+		{"default", "", time.Minute, defaultPreemptionTimeout, defaultPreemptionTimeout},
+		{"invalid", "10 seconds", time.Minute, defaultPreemptionTimeout, defaultPreemptionTimeout},
+		{"capped", "25s", 5 * time.Second, 4 * time.Second, 5 * time.Second},
+		{"elapsed", "25s", -time.Second, -2 * time.Second, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := preemptionBudget(tc.timeout, time.Now().Add(tc.deadline))
+			if got < tc.min || got > tc.max {
+				t.Errorf("preemptionBudget(%q, now+%s) = %s, want between %s and %s", tc.timeout, tc.deadline, got, tc.min, tc.max)
+			}
+		})
+	}
+}
@@ -18,9 +18,13 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/robfig/cron/v3"
 )
@@ -40,11 +44,56 @@ type Job interface {
 	Run(context.Context) (bool, error)
 }
 
+// Resumable is optionally implemented by jobs whose persisted schedule is only
+// valid as long as the outputs of their last run are in place.
+type Resumable interface {
+	// CanResume reports whether the job can resume its persisted schedule, if
+	// false it's scheduled as if it never ran, i.e. its outputs didn't survive a
+	// reboot.
+	CanResume(context.Context) bool
+}
+
+// JobOptions controls how the runs of a job are executed.
+type JobOptions struct {
+	// Timeout bounds each run attempt through its context, zero means no timeout.
+	Timeout time.Duration
+	// Retry is the policy a failed run is retried with before waiting for the next
+	// scheduled run, a zero policy means a single attempt. A run asking to be
+	// unscheduled is never retried. It doesn't apply to the first run of jobs
+	// scheduled synchronously, which would delay the caller.
+	Retry retry.Policy
+	// StartJitter is the maximum random delay added to the job's first run so that
+	// instances started together don't run it all at once. It doesn't apply to
+	// jobs scheduled synchronously.
+	StartJitter time.Duration
+	// FailureBackoff is how long after a failed scheduled run the job is run again,
+	// it doubles with each consecutive failure up to the job's interval. Zero means
+	// a failed run waits for the next scheduled run.
+	FailureBackoff time.Duration
+}
+
+// Configurable is optionally implemented by jobs that need control over how
+// their runs are executed.
+type Configurable interface {
+	// Options returns the job's run options.
+	Options() JobOptions
+}
+
+// jobOptions returns job's run options, the zero options if it's not Configurable.
+func jobOptions(job Job) JobOptions {
+	if c, ok := job.(Configurable); ok {
+		return c.Options()
+	}
+	return JobOptions{}
+}
+
 // Scheduler implements job schedule manager and offers a way to schedule/unschedule new jobs.
 type Scheduler struct {
-	cron *cron.Cron
-	jobs map[string]cron.EntryID
-	mu   sync.RWMutex
+	cron    *cron.Cron
+	jobs    map[string]cron.EntryID
+	runners map[string]*jobRunner
+	mu      sync.RWMutex
+	state   *stateStore
 }
 
 var scheduler *Scheduler
@@ -54,9 +103,11 @@ func init() {
 	cron := cron.New(cron.WithLogger(&cronLogger{}))
 
 	scheduler = &Scheduler{
-		cron: cron,
-		jobs: taskIDs,
-		mu:   sync.RWMutex{},
+		cron:    cron,
+		jobs:    taskIDs,
+		runners: make(map[string]*jobRunner),
+		mu:      sync.RWMutex{},
+		state:   newStateStore(defaultStateFile),
 	}
 }
 
@@ -66,19 +117,130 @@ func Get() *Scheduler {
 	return scheduler
 }
 
-// getFunc generates a wrapper function for cron scheduler.
-func (s *Scheduler) getFunc(ctx context.Context, job Job) func() {
-	f := func() {
+// resumeSchedule is a cron schedule running at a constant interval except for its
+// first run, which may be earlier, i.e. when a job resumes its persisted schedule.
+type resumeSchedule struct {
+	first time.Time
+	every cron.ConstantDelaySchedule
+}
+
+// Next implements cron.Schedule.
+func (rs resumeSchedule) Next(t time.Time) time.Time {
+	if t.Before(rs.first) {
+		return rs.first
+	}
+	return rs.every.Next(t)
+}
+
+// jobRunner runs a scheduled job, it's shared by the scheduled and the manual runs
+// so that they never overlap.
+type jobRunner struct {
+	running atomic.Bool
+	run     func(manual, synchronous bool) error
+	// failures is the number of consecutive failed scheduled runs.
+	failures int
+}
+
+// newRunner generates the runner of job. Scheduled runs are skipped while the job
+// is paused, manual runs aren't. Synchronous runs are a single attempt.
+func (s *Scheduler) newRunner(ctx context.Context, job Job, interval time.Duration) *jobRunner {
+	r := &jobRunner{}
+	opts := jobOptions(job)
+
+	r.run = func(manual, synchronous bool) error {
+		if state, _ := s.state.get(job.ID()); state.Paused && !manual {
+			logger.Infof("Skipping job %q, it's paused", job.ID())
+			return nil
+		}
+
+		if !r.running.CompareAndSwap(false, true) {
+			logger.Infof("Skipping job %q, its previous run is still in progress", job.ID())
+			return fmt.Errorf("job %q is already running", job.ID())
+		}
+		defer r.running.Store(false)
+
 		logger.Infof("Invoking job %q", job.ID())
-		schedule, err := job.Run(ctx)
+		start := time.Now()
+		runOpts := opts
+		if synchronous {
+			runOpts.Retry = retry.Policy{}
+		}
+
+		schedule, err := runJob(ctx, job, runOpts)
+		if schedule && !manual && opts.FailureBackoff > 0 {
+			r.backoff(s, job.ID(), opts.FailureBackoff, interval, err)
+		}
+		s.saveState(job.ID(), start, interval, err)
 		if !schedule {
 			s.UnscheduleJob(job.ID())
 		}
 		if err != nil {
 			logger.Errorf("Failed to execute job %s: %v", job.ID(), err)
 		}
+		return err
+	}
+
+	return r
+}
+
+// backoff reschedules the job jobID sooner than its interval after a failed run,
+// the delay doubles with each consecutive failure up to interval.
+func (r *jobRunner) backoff(s *Scheduler, jobID string, delay, interval time.Duration, runErr error) {
+	if runErr == nil {
+		r.failures = 0
+		return
+	}
+
+	r.failures++
+	for i := 1; i < r.failures && delay < interval; i++ {
+		delay *= 2
+	}
+	if delay > interval {
+		delay = interval
+	}
+
+	logger.Infof("Job %q failed %d time(s) in a row, running it again in %s", jobID, r.failures, delay)
+	s.reschedule(jobID, r, time.Now().Add(delay), interval)
+}
+
+// runJob runs job, retrying it and bounding each attempt according to opts. It
+// returns whether the job should remain scheduled and the run's error.
+func runJob(ctx context.Context, job Job, opts JobOptions) (bool, error) {
+	schedule := true
+
+	attempt := func() error {
+		runCtx := ctx
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+
+		var err error
+		schedule, err = job.Run(runCtx)
+		return err
+	}
+
+	if opts.Retry.MaxAttempts <= 1 {
+		err := attempt()
+		return schedule, err
 	}
-	return f
+
+	policy := opts.Retry
+	shouldRetry := policy.ShouldRetry
+	policy.ShouldRetry = func(err error) bool {
+		if !schedule {
+			return false
+		}
+		if shouldRetry != nil && !shouldRetry(err) {
+			return false
+		}
+		logger.Infof("Job %q failed, retrying: %v", job.ID(), err)
+		return true
+	}
+
+	err := retry.Run(ctx, policy, attempt)
+	return schedule, err
 }
 
 // ScheduleJob adds a job to schedule at defined interval.
@@ -90,27 +252,84 @@ func (s *Scheduler) ScheduleJob(ctx context.Context, job Job, synchronous bool)
 	logger.Infof("Scheduling job: %s", job.ID())
 
 	interval, startNow := job.Interval()
-	if err := s.jobInit(job.ID(), interval, s.getFunc(ctx, job), startNow, synchronous); err != nil {
+
+	state, found := s.state.get(job.ID())
+	if r, ok := job.(Resumable); found && ok && !r.CanResume(ctx) {
+		logger.Infof("Job %q can't resume its persisted schedule, starting over", job.ID())
+		found = false
+	}
+
+	now := time.Now()
+	first := resumeAt(state, found, interval, startNow, now)
+
+	if jitter := jobOptions(job).StartJitter; jitter > 0 && !synchronous {
+		first = first.Add(time.Duration(rand.Int63n(int64(jitter))))
+	}
+
+	if err := s.jobInit(job.ID(), interval, s.newRunner(ctx, job, interval), first, synchronous); err != nil {
 		return err
 	}
 
+	// Persist the due time right away so that restarting before the first run
+	// doesn't postpone it.
+	if first.After(now) && (!found || !state.NextDue.Equal(first)) {
+		if found {
+			logger.Infof("Resuming job %q, next run at %s", job.ID(), first.Format(time.RFC3339))
+		}
+		if err := s.state.update(job.ID(), func(st *JobState) { st.NextDue = first }); err != nil {
+			logger.Warningf("Failed to persist the state of job %q: %v", job.ID(), err)
+		}
+	}
+
 	return nil
 }
 
-func (s *Scheduler) setEntryID(jobID string, entryID cron.EntryID) {
+// saveState persists the outcome of jobID's run started at start.
+func (s *Scheduler) saveState(jobID string, start time.Time, interval time.Duration, runErr error) {
+	next := start.Add(interval)
+
+	s.mu.RLock()
+	if entryID, found := s.jobs[jobID]; found {
+		if entry := s.cron.Entry(entryID); !entry.Next.IsZero() {
+			next = entry.Next
+		}
+	}
+	s.mu.RUnlock()
+
+	err := s.state.update(jobID, func(st *JobState) {
+		st.LastRun = start
+		st.NextDue = next
+		st.LastError = ""
+		if runErr != nil {
+			st.LastError = runErr.Error()
+		} else {
+			st.LastSuccess = start
+		}
+	})
+	if err != nil {
+		logger.Warningf("Failed to persist the state of job %q: %v", jobID, err)
+	}
+}
+
+func (s *Scheduler) setEntryID(jobID string, entryID cron.EntryID, runner *jobRunner) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.jobs[jobID] = entryID
+	s.runners[jobID] = runner
 }
 
 // jobInit adds job to the schedule to run at specified interval.
-// Setting startImmediately to true executes first run immediately, otherwise
-// first run will be after interval (at now+interval).
-// If startImmediately and synchronous both are true, init method will block
+// If first is not in the future the first run is executed immediately and the
+// following ones every interval, otherwise the first run is at first.
+// If the job starts immediately and synchronous is true, init method will block
 // until job is completed.
-func (s *Scheduler) jobInit(jobID string, interval time.Duration, job func(), startImmediately, synchronous bool) error {
+func (s *Scheduler) jobInit(jobID string, interval time.Duration, runner *jobRunner, first time.Time, synchronous bool) error {
 	logger.Infof("Scheduling job %q to run at %f hr interval", jobID, interval.Hours())
 
+	if interval <= 0 {
+		return fmt.Errorf("unable to schedule %q: invalid interval %s", jobID, interval)
+	}
+
 	_, found := s.jobs[jobID]
 	// If found, job is already running, return.
 	if found {
@@ -118,15 +337,19 @@ func (s *Scheduler) jobInit(jobID string, interval time.Duration, job func(), st
 		return nil
 	}
 
-	entry, err := s.cron.AddFunc(fmt.Sprintf("@every %ds", int(interval.Seconds())), job)
-	if err != nil {
-		return fmt.Errorf("unable to schedule %q: %w", jobID, err)
+	startImmediately := !first.After(time.Now())
+	schedule := resumeSchedule{every: cron.Every(interval)}
+	if !startImmediately {
+		schedule.first = first
 	}
-	s.setEntryID(jobID, entry)
+
+	job := func() { runner.run(false, false) }
+	entry := s.cron.Schedule(schedule, cron.FuncJob(job))
+	s.setEntryID(jobID, entry, runner)
 
 	if startImmediately {
 		if synchronous {
-			job()
+			runner.run(false, true)
 		} else {
 			// Start job in a go routine to not block the caller.
 			go job()
@@ -136,18 +359,139 @@ func (s *Scheduler) jobInit(jobID string, interval time.Duration, job func(), st
 	return nil
 }
 
-// UnscheduleJob removes the job from schedule.
-func (s *Scheduler) UnscheduleJob(jobID string) {
+// reschedule moves the next run of the job jobID scheduled with runner to next,
+// the following runs are every interval.
+func (s *Scheduler) reschedule(jobID string, runner *jobRunner, next time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entryID, found := s.jobs[jobID]
+	if !found || s.runners[jobID] != runner {
+		return
+	}
+
+	s.cron.Remove(entryID)
+	schedule := resumeSchedule{first: next, every: cron.Every(interval)}
+	s.jobs[jobID] = s.cron.Schedule(schedule, cron.FuncJob(func() { runner.run(false, false) }))
+}
+
+// JobStatus describes a scheduled job.
+type JobStatus struct {
+	// ID is the job id.
+	ID string
+	// Next is the time of the job's next run.
+	Next time.Time
+	// Prev is the time of the job's last run, including the runs before the agent
+	// restarted, zero if it didn't run yet.
+	Prev time.Time
+	// LastSuccess is the time of the job's last successful run, including the
+	// runs before the agent restarted.
+	LastSuccess time.Time
+	// LastError is the error of the job's last run, empty if it succeeded.
+	LastError string
+	// Paused is true if the job's scheduled runs are skipped.
+	Paused bool
+	// Running is true if the job is currently running.
+	Running bool
+}
+
+// Jobs returns the scheduled jobs sorted by id.
+func (s *Scheduler) Jobs() []JobStatus {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	var res []JobStatus
+	for id, entryID := range s.jobs {
+		entry := s.cron.Entry(entryID)
+		state, _ := s.state.get(id)
+
+		status := JobStatus{
+			ID:          id,
+			Next:        entry.Next,
+			Prev:        entry.Prev,
+			LastSuccess: state.LastSuccess,
+			LastError:   state.LastError,
+			Paused:      state.Paused,
+			Running:     s.runners[id].running.Load(),
+		}
+		if state.LastRun.After(status.Prev) {
+			status.Prev = state.LastRun
+		}
+
+		res = append(res, status)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// UnscheduleJob removes the job from schedule.
+func (s *Scheduler) UnscheduleJob(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	logger.Infof("Unscheduling job %q", jobID)
 
 	entry, found := s.jobs[jobID]
 	if found {
 		s.cron.Remove(entry)
 		delete(s.jobs, jobID)
+		delete(s.runners, jobID)
+	}
+}
+
+// runner returns the runner of the scheduled job jobID.
+func (s *Scheduler) runner(jobID string) (*jobRunner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runner, found := s.runners[jobID]
+	if !found {
+		return nil, fmt.Errorf("job %q is not scheduled", jobID)
+	}
+	return runner, nil
+}
+
+// RunNow runs the scheduled job jobID right away, even if it's paused, and waits
+// for it to complete. It doesn't change the job's schedule and fails if the job is
+// already running.
+func (s *Scheduler) RunNow(jobID string) error {
+	runner, err := s.runner(jobID)
+	if err != nil {
+		return err
 	}
+
+	logger.Infof("Running job %q on demand", jobID)
+	return runner.run(true, false)
+}
+
+// PauseJob pauses the scheduled job jobID, its scheduled runs are skipped until
+// it's resumed. The pause is persisted across agent restarts.
+func (s *Scheduler) PauseJob(jobID string) error {
+	return s.setPaused(jobID, true)
+}
+
+// ResumeJob resumes the paused job jobID, it runs again on its next scheduled run.
+func (s *Scheduler) ResumeJob(jobID string) error {
+	return s.setPaused(jobID, false)
+}
+
+// setPaused pauses or resumes the scheduled job jobID.
+func (s *Scheduler) setPaused(jobID string, paused bool) error {
+	if _, err := s.runner(jobID); err != nil {
+		return err
+	}
+
+	if paused {
+		logger.Infof("Pausing job %q", jobID)
+	} else {
+		logger.Infof("Resuming job %q", jobID)
+	}
+
+	if err := s.state.update(jobID, func(st *JobState) { st.Paused = paused }); err != nil {
+		return fmt.Errorf("failed to persist the state of job %q: %w", jobID, err)
+	}
+	return nil
 }
 
 // start begins executing each job at defined interval.
@@ -16,10 +16,30 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "scheduler_test")
+	if err != nil {
+		os.Exit(1)
+	}
+
+	// Don't persist the test jobs state in the default location.
+	scheduler.state = newStateStore(filepath.Join(dir, "scheduler-state.json"))
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 type testJob struct {
 	interval     time.Duration
 	shouldEnable bool
@@ -209,3 +229,288 @@ func TestScheduleJobsNoWait(t *testing.T) {
 		t.Errorf("ScheduleJobs(ctx, job1, true) returned after %f seconds, expected no wait", got.Seconds())
 	}
 }
+
+func TestJobs(t *testing.T) {
+	job := &testJob{
+		interval:     time.Hour,
+		id:           "test_jobs_listing",
+		shouldEnable: true,
+	}
+	s := Get()
+
+	if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+		t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	var found *JobStatus
+	jobs := s.Jobs()
+	for i := range jobs {
+		if jobs[i].ID == job.ID() {
+			found = &jobs[i]
+		}
+		if i > 0 && jobs[i-1].ID > jobs[i].ID {
+			t.Errorf("Jobs() = %+v, expected it sorted by id", jobs)
+		}
+	}
+
+	if found == nil {
+		t.Fatalf("Jobs() = %+v, expected it to contain %s", jobs, job.ID())
+	}
+
+	if !found.Prev.IsZero() {
+		t.Errorf("Jobs() returned last run %s for a job that didn't run yet", found.Prev)
+	}
+
+	if until := time.Until(found.Next); until <= 0 || until > time.Hour {
+		t.Errorf("Jobs() returned next run in %s, expected within the job's interval", until)
+	}
+}
+
+type testOptionsJob struct {
+	id       string
+	opts     JobOptions
+	failures int
+	schedule bool
+	runs     atomic.Int32
+	block    chan struct{}
+}
+
+func (j *testOptionsJob) Run(ctx context.Context) (bool, error) {
+	run := int(j.runs.Add(1))
+
+	if j.block != nil {
+		select {
+		case <-j.block:
+		case <-ctx.Done():
+			return j.schedule, ctx.Err()
+		}
+	}
+
+	if run <= j.failures {
+		return j.schedule, fmt.Errorf("run %d failed", run)
+	}
+	return j.schedule, nil
+}
+
+func (j *testOptionsJob) ID() string {
+	return j.id
+}
+
+func (j *testOptionsJob) Interval() (time.Duration, bool) {
+	return time.Hour, true
+}
+
+func (j *testOptionsJob) ShouldEnable(_ context.Context) bool {
+	return true
+}
+
+func (j *testOptionsJob) Options() JobOptions {
+	return j.opts
+}
+
+func TestRunJob(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3, BackoffFactor: 1, Jitter: time.Millisecond}
+
+	var tests = []struct {
+		name     string
+		job      *testOptionsJob
+		wantRuns int32
+		wantErr  bool
+	}{
+		{"single_attempt", &testOptionsJob{failures: 1, schedule: true}, 1, true},
+		{"retried", &testOptionsJob{failures: 2, schedule: true, opts: JobOptions{Retry: policy}}, 3, false},
+		{"retries_exhausted", &testOptionsJob{failures: 5, schedule: true, opts: JobOptions{Retry: policy}}, 3, true},
+		{"unscheduled_not_retried", &testOptionsJob{failures: 5, schedule: false, opts: JobOptions{Retry: policy}}, 1, true},
+		{"timeout", &testOptionsJob{schedule: true, block: make(chan struct{}), opts: JobOptions{Timeout: 10 * time.Millisecond}}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := runJob(context.Background(), tt.job, tt.job.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runJob() = %v, want error: %t", err, tt.wantErr)
+			}
+
+			if schedule != tt.job.schedule {
+				t.Errorf("runJob() = %t, want %t", schedule, tt.job.schedule)
+			}
+
+			if got := tt.job.runs.Load(); got != tt.wantRuns {
+				t.Errorf("runJob() ran the job %d time(s), want %d", got, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestNoOverlappingRuns(t *testing.T) {
+	s := Get()
+	job := &testOptionsJob{id: "test_overlapping_job", schedule: true, block: make(chan struct{})}
+
+	runner := s.newRunner(context.Background(), job, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		runner.run(false, false)
+		close(done)
+	}()
+
+	// Wait for the first run to start before starting another one.
+	for job.runs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := runner.run(true, false); err == nil {
+		t.Errorf("run() succeeded while a run was in progress, want error")
+	}
+	close(job.block)
+	<-done
+
+	if got := job.runs.Load(); got != 1 {
+		t.Errorf("job ran %d time(s) while a run was in progress, want 1", got)
+	}
+}
+
+func TestScheduleJobStartJitter(t *testing.T) {
+	s := Get()
+	job := &testOptionsJob{id: "test_jitter_job", schedule: true, opts: JobOptions{StartJitter: time.Hour}}
+
+	start := time.Now()
+	if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+		t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	var next time.Time
+	for _, curr := range s.Jobs() {
+		if curr.ID == job.ID() {
+			next = curr.Next
+		}
+	}
+
+	if next.Before(start) || next.After(start.Add(time.Hour)) {
+		t.Errorf("Jobs() returned next run %s for %s, want within the start jitter", next, job.ID())
+	}
+}
+
+func TestJobFailureBackoff(t *testing.T) {
+	s := Get()
+	job := &testOptionsJob{id: "test_backoff_job", failures: 3, schedule: true, opts: JobOptions{FailureBackoff: 20 * time.Minute}}
+
+	next := func() time.Time {
+		for _, curr := range s.Jobs() {
+			if curr.ID == job.ID() {
+				return curr.Next
+			}
+		}
+		t.Fatalf("Jobs() doesn't contain %s", job.ID())
+		return time.Time{}
+	}
+
+	// The synchronous first run fails.
+	start := time.Now()
+	if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+		t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	// The backoff doubles with each failure, up to the job's interval.
+	for i, want := range []time.Duration{20 * time.Minute, 40 * time.Minute, time.Hour} {
+		if i > 0 {
+			start = time.Now()
+			s.runners[job.ID()].run(false, false)
+		}
+
+		if got := next(); got.Before(start.Add(want)) || got.After(time.Now().Add(want)) {
+			t.Errorf("Jobs() returned next run %s after failure %d, want %s later", got, i+1, want)
+		}
+	}
+
+	s.runners[job.ID()].run(false, false)
+	if got := s.runners[job.ID()].failures; got != 0 {
+		t.Errorf("runner has %d failures after a successful run, want 0", got)
+	}
+}
+
+func TestSynchronousRunNotRetried(t *testing.T) {
+	s := Get()
+	policy := retry.Policy{MaxAttempts: 3, BackoffFactor: 1, Jitter: time.Millisecond}
+	job := &testOptionsJob{id: "test_synchronous_retry_job", failures: 1, schedule: true, opts: JobOptions{Retry: policy}}
+
+	if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+		t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	if got := job.runs.Load(); got != 1 {
+		t.Errorf("synchronous first run ran the job %d time(s), want 1", got)
+	}
+
+	// The following scheduled runs are retried.
+	job.failures = 2
+	if err := s.runners[job.ID()].run(false, false); err != nil {
+		t.Errorf("scheduled run failed unexpectedly with error: %v", err)
+	}
+	if got := job.runs.Load(); got != 3 {
+		t.Errorf("job ran %d time(s) in total, want 3", got)
+	}
+}
+
+func TestJobControl(t *testing.T) {
+	s := Get()
+	job := &testOptionsJob{id: "test_control_job", schedule: true}
+
+	for _, f := range []func(string) error{s.RunNow, s.PauseJob, s.ResumeJob} {
+		if err := f(job.ID()); err == nil {
+			t.Errorf("controlling an unscheduled job succeeded, want error")
+		}
+	}
+
+	if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+		t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	status := func() JobStatus {
+		for _, curr := range s.Jobs() {
+			if curr.ID == job.ID() {
+				return curr
+			}
+		}
+		t.Fatalf("Jobs() doesn't contain %s", job.ID())
+		return JobStatus{}
+	}
+
+	if err := s.PauseJob(job.ID()); err != nil {
+		t.Fatalf("PauseJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+
+	if !status().Paused {
+		t.Errorf("Jobs() returned %s not paused after PauseJob()", job.ID())
+	}
+
+	runs := job.runs.Load()
+	s.runners[job.ID()].run(false, false)
+	if got := job.runs.Load(); got != runs {
+		t.Errorf("paused job %s ran on schedule, want skipped", job.ID())
+	}
+
+	// Manual runs aren't affected by the pause.
+	before := time.Now()
+	if err := s.RunNow(job.ID()); err != nil {
+		t.Errorf("RunNow(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	if got := job.runs.Load(); got != runs+1 {
+		t.Errorf("RunNow(%s) ran the job %d time(s), want 1", job.ID(), got-runs)
+	}
+	if prev := status().Prev; prev.Before(before) {
+		t.Errorf("Jobs() returned last run %s for %s, want after %s", prev, job.ID(), before)
+	}
+
+	if err := s.ResumeJob(job.ID()); err != nil {
+		t.Fatalf("ResumeJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+
+	if status().Paused {
+		t.Errorf("Jobs() returned %s paused after ResumeJob()", job.ID())
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// JobState is the state of a job persisted across agent restarts.
+type JobState struct {
+	// LastRun is when the job last ran.
+	LastRun time.Time
+	// LastSuccess is when the job last ran without error.
+	LastSuccess time.Time
+	// LastError is the error of the last run, empty if it succeeded.
+	LastError string
+	// NextDue is when the job is due to run next.
+	NextDue time.Time
+	// Paused is true if the job's scheduled runs are skipped.
+	Paused bool `json:",omitempty"`
+}
+
+// stateStore persists the jobs state to a file, the file is loaded on first
+// access and rewritten on every update.
+type stateStore struct {
+	path   string
+	mu     sync.Mutex
+	loaded bool
+	jobs   map[string]JobState
+}
+
+// newStateStore allocates a stateStore backed by path.
+func newStateStore(path string) *stateStore {
+	return &stateStore{path: path, jobs: make(map[string]JobState)}
+}
+
+// load reads the state file, a missing or invalid file is treated as empty.
+// Must be called with mu held.
+func (st *stateStore) load() {
+	if st.loaded {
+		return
+	}
+	st.loaded = true
+
+	data, err := os.ReadFile(st.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warningf("Failed to read scheduler state %q: %v", st.path, err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &st.jobs); err != nil {
+		logger.Warningf("Failed to unmarshal scheduler state %q, ignoring it: %v", st.path, err)
+		st.jobs = make(map[string]JobState)
+	}
+}
+
+// get returns the persisted state of jobID and whether there's one.
+func (st *stateStore) get(jobID string) (JobState, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.load()
+	state, found := st.jobs[jobID]
+	return state, found
+}
+
+// update applies f to the state of jobID and persists it.
+func (st *stateStore) update(jobID string, f func(*JobState)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.load()
+	state := st.jobs[jobID]
+	f(&state)
+	st.jobs[jobID] = state
+
+	data, err := json.Marshal(st.jobs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduler state: %w", err)
+	}
+
+	// SaferWriteFile creates missing directories with the file's mode, which lacks
+	// the execute bit, create it beforehand.
+	dir := filepath.Dir(st.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create scheduler state directory %q: %w", dir, err)
+	}
+
+	return utils.SaferWriteFile(data, st.path, 0600)
+}
+
+// resumeAt returns when a job running every interval should first run given its
+// persisted state. A job that's due or overdue runs now, a job without a usable
+// state runs now if startNow is true or at now+interval otherwise. The persisted
+// due time is capped to now+interval in case the interval was shortened.
+func resumeAt(state JobState, found bool, interval time.Duration, startNow bool, now time.Time) time.Time {
+	if !found || state.NextDue.IsZero() {
+		if startNow {
+			return now
+		}
+		return now.Add(interval)
+	}
+
+	if !state.NextDue.After(now) {
+		return now
+	}
+
+	if max := now.Add(interval); state.NextDue.After(max) {
+		return max
+	}
+
+	return state.NextDue
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResumeAt(t *testing.T) {
+	now := time.Now()
+	interval := time.Hour
+
+	var tests = []struct {
+		name     string
+		state    JobState
+		found    bool
+		startNow bool
+		want     time.Time
+	}{
+		{"no_state_start_now", JobState{}, false, true, now},
+		{"no_state", JobState{}, false, false, now.Add(interval)},
+		{"no_due_time", JobState{LastRun: now.Add(-time.Minute)}, true, false, now.Add(interval)},
+		{"due", JobState{NextDue: now}, true, false, now},
+		{"overdue", JobState{NextDue: now.Add(-time.Minute)}, true, false, now},
+		{"not_due", JobState{NextDue: now.Add(time.Minute)}, true, true, now.Add(time.Minute)},
+		{"capped", JobState{NextDue: now.Add(2 * interval)}, true, true, now.Add(interval)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resumeAt(tt.state, tt.found, interval, tt.startNow, now); !got.Equal(tt.want) {
+				t.Errorf("resumeAt(%+v, %t, %s, %t) = %s, want %s", tt.state, tt.found, interval, tt.startNow, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := newStateStore(path)
+
+	if _, found := st.get("job"); found {
+		t.Fatalf("get(job) found a state in an empty store")
+	}
+
+	due := time.Now().Add(time.Hour).Round(0)
+	if err := st.update("job", func(s *JobState) { s.NextDue = due; s.LastError = "failed" }); err != nil {
+		t.Fatalf("update(job) failed unexpectedly with error: %v", err)
+	}
+
+	// A new store must load the persisted state.
+	got, found := newStateStore(path).get("job")
+	if !found {
+		t.Fatalf("get(job) didn't find the persisted state")
+	}
+
+	if !got.NextDue.Equal(due) || got.LastError != "failed" {
+		t.Errorf("get(job) = %+v, want next due %s and last error failed", got, due)
+	}
+}
+
+func TestStateStoreCreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "google-guest-agent")
+	st := newStateStore(filepath.Join(dir, "scheduler-state.json"))
+
+	if err := st.update("job", func(s *JobState) { s.Paused = true }); err != nil {
+		t.Fatalf("update(job) failed unexpectedly with error: %v", err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("update(job) didn't create the state directory %q: %v", dir, err)
+	}
+
+	// The directory is shared with the metadata cache, it must be traversable.
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("State directory %q has permissions %v, want the execute bit set", dir, info.Mode().Perm())
+	}
+}
+
+type testResumableJob struct {
+	testJob
+	canResume bool
+}
+
+func (j *testResumableJob) CanResume(_ context.Context) bool {
+	return j.canResume
+}
+
+func TestScheduleJobResume(t *testing.T) {
+	s := Get()
+	due := time.Now().Add(30 * time.Minute)
+
+	for _, canResume := range []bool{true, false} {
+		t.Run(fmt.Sprintf("can_resume_%t", canResume), func(t *testing.T) {
+			job := &testResumableJob{
+				testJob: testJob{
+					interval:     time.Hour,
+					id:           fmt.Sprintf("test_resume_job_%t", canResume),
+					shouldEnable: true,
+					startingNow:  true,
+				},
+				canResume: canResume,
+			}
+
+			if err := s.state.update(job.ID(), func(st *JobState) { st.NextDue = due }); err != nil {
+				t.Fatalf("update(%s) failed unexpectedly with error: %v", job.ID(), err)
+			}
+
+			if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+				t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+			}
+			defer s.UnscheduleJob(job.ID())
+
+			// A resumed job must not run before its persisted due time.
+			if ran := job.ctr > 0; ran == canResume {
+				t.Errorf("ScheduleJob(%s) ran the job: %t, want: %t", job.ID(), ran, !canResume)
+			}
+
+			var next time.Time
+			for _, curr := range s.Jobs() {
+				if curr.ID == job.ID() {
+					next = curr.Next
+				}
+			}
+
+			if resumed := next.Equal(due); resumed != canResume {
+				t.Errorf("Jobs() returned next run %s for %s, want resumed at %s: %t", next, job.ID(), due, canResume)
+			}
+
+			state, _ := s.state.get(job.ID())
+			if canResume != state.LastSuccess.IsZero() {
+				t.Errorf("persisted state of %s = %+v, want last success set: %t", job.ID(), state, !canResume)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/sshtrustedca"
@@ -39,31 +40,51 @@ type TrustedCert struct {
 var (
 	// mdsClient is the metadata's client, used to query oslogin certificates.
 	mdsClient *metadata.Client
+	// subscription is writeFile's subscription to the pipe watcher's events.
+	subscription *events.Subscription
+)
+
+const (
+	// writeTimeout is the deadline of a single certificate write to the pipe.
+	writeTimeout = 30 * time.Second
 )
 
 // Init initializes the sshca's event handler callback.
 func Init() {
 	mdsClient = metadata.New()
-	events.Get().Subscribe(sshtrustedca.ReadEvent, nil, writeFile)
+	// writeFile may block on the pipe until sshd reads it, the timeout keeps a stuck
+	// write from holding back the following requests.
+	var err error
+	opts := events.SubscribeOptions{Timeout: writeTimeout}
+	if subscription, err = events.SubscribeWithOptions(events.Get(), sshtrustedca.Read, writeFile, opts); err != nil {
+		logger.Errorf("Failed to subscribe to ssh trusted ca pipe events: %v", err)
+	}
 }
 
 // Close finishes the sshca module, deallocating everything allocated with Init().
 func Close() {
-	events.Get().Unsubscribe(sshtrustedca.ReadEvent, writeFile)
+	if subscription != nil {
+		subscription.Unsubscribe()
+		subscription = nil
+	}
 	mdsClient = nil
 }
 
 // writeFile is an event handler callback and writes the actual sshca content to the pipe
 // used by openssh to grant access based on ssh ca.
-func writeFile(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+func writeFile(ctx context.Context, evType string, pipeData *sshtrustedca.PipeData, err error) bool {
 	// There was some error on the pipe watcher, just ignore it.
-	if evData.Error != nil {
-		logger.Debugf("Not handling ssh trusted ca cert event, we got an error: %+v", evData.Error)
+	if err != nil {
+		logger.Debugf("Not handling ssh trusted ca cert event, we got an error: %+v", err)
+		return true
+	}
+
+	if pipeData == nil {
+		logger.Debugf("Not handling ssh trusted ca cert event, got no pipe data.")
 		return true
 	}
 
 	// Make sure we close the pipe after we've done writing to it.
-	pipeData := evData.Data.(*sshtrustedca.PipeData)
 	defer func() {
 		if err := pipeData.File.Close(); err != nil {
 			logger.Errorf("Failed to close pipe: %+v", err)
@@ -0,0 +1,173 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	mdsEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// reloadAndReconcile reloads the configuration files and runs a full reconciliation,
+// the reconciliation runs even if the configuration is invalid and kept as is.
+func reloadAndReconcile(ctx context.Context) {
+	logger.Infof("Reloading configuration")
+
+	changes, err := cfg.Reload()
+	if err != nil {
+		logger.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+	}
+
+	applyConfigChanges(ctx, changes)
+}
+
+// applyConfigChanges applies the configuration changes that need more than the next
+// cfg.Get() call to take effect and runs a full reconciliation. It's shared by the
+// configuration watcher and the SIGHUP reloads, the watcher doesn't report again the
+// changes a SIGHUP already applied.
+func applyConfigChanges(ctx context.Context, changes []cfg.Change) {
+	for _, change := range changes {
+		logger.Infof("Configuration changed: %s", change)
+	}
+
+	if command.ConfigChanged(changes) {
+		logger.Infof("Command monitor configuration changed, restarting the command monitor")
+		command.Reload(ctx)
+	}
+
+	if metadataEndpointsChanged(changes) {
+		// The clients share the endpoint list, the new one applies to their next request.
+		if err := metadata.SetEndpoints(strings.Split(cfg.Get().MDS.Endpoints, ",")); err != nil {
+			logger.Errorf("Invalid metadata endpoints configuration, keeping the current endpoints: %v", err)
+		}
+	}
+
+	fullReconcile(ctx)
+}
+
+// dumpState logs the agent's internal state: configuration, metadata etag, managers
+// status, scheduled jobs, event watchers and subscribers.
+func dumpState(ctx context.Context) {
+	logger.Infof("State dump:\n%s", strings.Join(stateLines(ctx), "\n"))
+}
+
+// stateLines returns the agent's internal state as printable lines.
+func stateLines(ctx context.Context) []string {
+	var lines []string
+	section := func(name string) {
+		lines = append(lines, name+":")
+	}
+	line := func(format string, args ...interface{}) {
+		lines = append(lines, "  "+fmt.Sprintf(format, args...))
+	}
+
+	section("Configuration")
+	for _, curr := range cfg.Dump() {
+		line("%s", curr)
+	}
+
+	eventManager := events.Get()
+	watchers := eventManager.Watchers()
+
+	section("Metadata")
+	etag := "unknown"
+	for _, curr := range watchers {
+		if watcher, ok := curr.(*mdsEvent.Watcher); ok && watcher.ETag() != "" {
+			etag = watcher.ETag()
+		}
+	}
+	line("last etag: %s", etag)
+
+	section("Managers")
+	// Managers read the metadata, don't wait for an ongoing update to finish.
+	if updateMutex.TryLock() {
+		if newMetadata == nil {
+			line("no metadata available yet")
+		} else {
+			for _, mgr := range availableManagers() {
+				status := "enabled"
+				disabled, err := mgr.Disabled(ctx)
+				if err != nil {
+					status = fmt.Sprintf("unknown (%v)", err)
+				} else if disabled {
+					status = "disabled"
+				} else if !newMetadataVerified && requiresVerifiedMetadata(mgr) {
+					status = "waiting for verified metadata"
+				}
+				line("%T: %s", mgr, status)
+			}
+		}
+		updateMutex.Unlock()
+	} else {
+		line("update in progress, status not available")
+	}
+
+	section("Scheduled jobs")
+	for _, job := range scheduler.Get().Jobs() {
+		status := fmt.Sprintf("next run %s, last run %s, last success %s", formatTime(job.Next), formatTime(job.Prev), formatTime(job.LastSuccess))
+		if job.LastError != "" {
+			status += ", last error: " + job.LastError
+		}
+		if job.Paused {
+			status += ", paused"
+		}
+		line("%s: %s", job.ID, status)
+	}
+
+	section("Watchers")
+	for _, curr := range watchers {
+		line("%s: %s", curr.ID(), strings.Join(curr.Events(), ", "))
+	}
+
+	section("Watchers health")
+	for _, curr := range eventManager.WatchersHealth() {
+		if curr.State == events.Healthy {
+			line("%s: %s since %s", curr.EventType, curr.State, formatTime(curr.Since))
+		} else {
+			line("%s: %s since %s, %d consecutive failure(s), last error: %s", curr.EventType, curr.State, formatTime(curr.Since), curr.Failures, curr.LastError)
+		}
+	}
+
+	section("Subscribers")
+	subscribers := eventManager.Subscribers()
+	var evTypes []string
+	for evType := range subscribers {
+		evTypes = append(evTypes, evType)
+	}
+	sort.Strings(evTypes)
+	for _, evType := range evTypes {
+		line("%s: %s", evType, strings.Join(subscribers[evType], ", "))
+	}
+
+	return lines
+}
+
+// formatTime formats t as RFC 3339, "never" if t is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
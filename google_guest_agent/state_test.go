@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateLines(t *testing.T) {
+	reloadConfig(t, nil)
+	newMetadata = nil
+
+	got := strings.Join(stateLines(context.Background()), "\n")
+	for _, want := range []string{
+		"Configuration:",
+		"[Daemons] network_daemon = true",
+		"Metadata:\n  last etag: unknown",
+		"Managers:\n  no metadata available yet",
+		"Scheduled jobs:",
+		"Watchers:",
+		"Watchers health:",
+		"Subscribers:",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stateLines() = %s\nwant it to contain %q", got, want)
+		}
+	}
+}
+
+func TestStateLinesUpdateInProgress(t *testing.T) {
+	reloadConfig(t, nil)
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	got := strings.Join(stateLines(context.Background()), "\n")
+	if want := "update in progress"; !strings.Contains(got, want) {
+		t.Errorf("stateLines() = %s\nwant it to contain %q", got, want)
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	if got := formatTime(time.Time{}); got != "never" {
+		t.Errorf("formatTime(zero) = %q, want never", got)
+	}
+
+	ts := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	if got := formatTime(ts); got != "2023-05-01T10:00:00Z" {
+		t.Errorf("formatTime(%v) = %q, want 2023-05-01T10:00:00Z", ts, got)
+	}
+}
+
+func TestRequiresVerifiedMetadata(t *testing.T) {
+	tests := []struct {
+		mgr  manager
+		want bool
+	}{
+		{addressManager, false},
+		{&clockskewMgr{}, false},
+		{&accountsMgr{}, true},
+		{&winAccountsMgr{}, true},
+		{&osloginMgr{}, true},
+	}
+
+	for _, tc := range tests {
+		if got := requiresVerifiedMetadata(tc.mgr); got != tc.want {
+			t.Errorf("requiresVerifiedMetadata(%T) = %t, want %t", tc.mgr, got, tc.want)
+		}
+	}
+}
@@ -25,12 +25,17 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/osinfo"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
 	tpb "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/telemetry/proto"
 )
 
 var (
 	telemetryJobID    = "telemetryJobID"
 	telemetryInterval = 24 * time.Hour
+	// telemetryTimeout bounds each telemetry recording.
+	telemetryTimeout = time.Minute
+	// telemetryStartJitter spreads the first recording of instances started together.
+	telemetryStartJitter = time.Minute
 )
 
 // Data is telemetry data on the current agent and OS.
@@ -143,6 +148,11 @@ func (j *Job) Interval() (time.Duration, bool) {
 	return telemetryInterval, true
 }
 
+// Options returns the job's run options, telemetry is best effort and never retried.
+func (j *Job) Options() scheduler.JobOptions {
+	return scheduler.JobOptions{Timeout: telemetryTimeout, StartJitter: telemetryStartJitter}
+}
+
 // ShouldEnable returns true as long as DisableTelemetry is not set in metadata.
 func (j *Job) ShouldEnable(ctx context.Context) bool {
 	md, err := j.client.Get(ctx)
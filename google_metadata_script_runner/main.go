@@ -439,6 +439,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := metadata.SetEndpoints(strings.Split(cfg.Get().MDS.Endpoints, ",")); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid metadata endpoints configuration, using the default endpoint: %+v\n", err)
+	}
+
 	// The keys to check vary based on the argument and the OS. Also functions to validate arguments.
 	wantedKeys, err := getWantedKeys(os.Args, runtime.GOOS)
 	if err != nil {
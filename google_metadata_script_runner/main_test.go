@@ -187,10 +187,26 @@ func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
 	return nil, fmt.Errorf("Watch() not yet implemented")
 }
 
+func (mds *mdsClient) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	return "", "", fmt.Errorf("WatchKey() not yet implemented")
+}
+
 func (mds *mdsClient) WriteGuestAttributes(ctx context.Context, key string, value string) error {
 	return fmt.Errorf("WriteGuestattributes() not yet implemented")
 }
 
+func (mds *mdsClient) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("GetGuestAttribute() not yet implemented")
+}
+
+func (mds *mdsClient) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("ListGuestAttributes() not yet implemented")
+}
+
+func (mds *mdsClient) DeleteGuestAttribute(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteGuestAttribute() not yet implemented")
+}
+
 func TestGetMetadata(t *testing.T) {
 	ctx := context.Background()
 	client = &mdsClient{}
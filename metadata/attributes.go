@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Get returns the unparsed value of the attribute key and whether it's set.
+func (a *Attributes) Get(key string) (string, bool) {
+	value, found := a.Raw[key]
+	return value, found
+}
+
+// lookup resolves key with instance over project precedence, the instance's value is
+// used if it's set and valid according to parse, otherwise the project's value is used.
+func lookup[T any](m *Descriptor, key string, parse func(string) (T, error)) (T, bool) {
+	var zero T
+
+	for _, attrs := range []*Attributes{&m.Instance.Attributes, &m.Project.Attributes} {
+		raw, found := attrs.Get(key)
+		if !found {
+			continue
+		}
+
+		value, err := parse(raw)
+		if err != nil {
+			continue
+		}
+
+		return value, true
+	}
+
+	return zero, false
+}
+
+// GetString returns the value of the attribute key, instance attributes take precedence
+// over project attributes. The returned bool reports whether key is set at all.
+func (m *Descriptor) GetString(key string) (string, bool) {
+	return lookup(m, key, func(value string) (string, error) {
+		return value, nil
+	})
+}
+
+// GetBool returns the boolean value of the attribute key, instance attributes take
+// precedence over project attributes. Values that can't be parsed as a bool are ignored,
+// the returned bool reports whether a valid value was found.
+func (m *Descriptor) GetBool(key string) (bool, bool) {
+	return lookup(m, key, strconv.ParseBool)
+}
+
+// GetDuration returns the duration value of the attribute key, i.e. "10s" or "1h30m",
+// instance attributes take precedence over project attributes. Values that can't be parsed
+// as a duration are ignored, the returned bool reports whether a valid value was found.
+func (m *Descriptor) GetDuration(key string) (time.Duration, bool) {
+	return lookup(m, key, time.ParseDuration)
+}
+
+// GetList returns the list value of the attribute key, instance attributes take precedence
+// over project attributes. Multiline values are split by lines, single line values are split
+// by commas. Elements are trimmed and empty ones are dropped.
+func (m *Descriptor) GetList(key string) ([]string, bool) {
+	return lookup(m, key, func(value string) ([]string, error) {
+		sep := ","
+		if strings.Contains(value, "\n") {
+			sep = "\n"
+		}
+
+		var res []string
+		for _, curr := range strings.Split(value, sep) {
+			if curr = strings.TrimSpace(curr); curr != "" {
+				res = append(res, curr)
+			}
+		}
+		return res, nil
+	})
+}
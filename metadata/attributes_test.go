@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTypedAttributes(t *testing.T) {
+	mds := `{"instance": {"attributes": {"myteam-feature-x": "true", "myteam-bool": "invalid", "myteam-interval": "90s", "myteam-list": "a, b,,c", "myteam-lines": "a,1\nb,2\n"}},
+		"project": {"attributes": {"myteam-feature-x": "false", "myteam-bool": "false", "myteam-project": "project", "myteam-interval-invalid": "10"}}}`
+
+	var desc Descriptor
+	if err := json.Unmarshal([]byte(mds), &desc); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed unexpectedly with error: %v", mds, err)
+	}
+
+	boolTests := []struct {
+		key       string
+		want      bool
+		wantFound bool
+	}{
+		{key: "myteam-feature-x", want: true, wantFound: true},
+		// Invalid instance value, project value should be used.
+		{key: "myteam-bool", want: false, wantFound: true},
+		{key: "myteam-project", want: false, wantFound: false},
+		{key: "unknown", want: false, wantFound: false},
+	}
+
+	for _, test := range boolTests {
+		got, found := desc.GetBool(test.key)
+		if got != test.want || found != test.wantFound {
+			t.Errorf("GetBool(%s) = (%t, %t), want (%t, %t)", test.key, got, found, test.want, test.wantFound)
+		}
+	}
+
+	stringTests := []struct {
+		key       string
+		want      string
+		wantFound bool
+	}{
+		{key: "myteam-feature-x", want: "true", wantFound: true},
+		{key: "myteam-project", want: "project", wantFound: true},
+		{key: "unknown", want: "", wantFound: false},
+	}
+
+	for _, test := range stringTests {
+		got, found := desc.GetString(test.key)
+		if got != test.want || found != test.wantFound {
+			t.Errorf("GetString(%s) = (%q, %t), want (%q, %t)", test.key, got, found, test.want, test.wantFound)
+		}
+	}
+
+	durationTests := []struct {
+		key       string
+		want      time.Duration
+		wantFound bool
+	}{
+		{key: "myteam-interval", want: 90 * time.Second, wantFound: true},
+		{key: "myteam-interval-invalid", want: 0, wantFound: false},
+	}
+
+	for _, test := range durationTests {
+		got, found := desc.GetDuration(test.key)
+		if got != test.want || found != test.wantFound {
+			t.Errorf("GetDuration(%s) = (%s, %t), want (%s, %t)", test.key, got, found, test.want, test.wantFound)
+		}
+	}
+
+	listTests := []struct {
+		key       string
+		want      []string
+		wantFound bool
+	}{
+		{key: "myteam-list", want: []string{"a", "b", "c"}, wantFound: true},
+		{key: "myteam-lines", want: []string{"a,1", "b,2"}, wantFound: true},
+		{key: "unknown", want: nil, wantFound: false},
+	}
+
+	for _, test := range listTests {
+		got, found := desc.GetList(test.key)
+		if !reflect.DeepEqual(got, test.want) || found != test.wantFound {
+			t.Errorf("GetList(%s) = (%v, %t), want (%v, %t)", test.key, got, found, test.want, test.wantFound)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+// ErrCacheInstanceMismatch is returned when the cached descriptor belongs to another
+// instance, i.e. the disk was cloned or the image was created from an instance.
+var ErrCacheInstanceMismatch = errors.New("cached metadata belongs to another instance")
+
+// Cache persists the last successfully fetched metadata descriptor to disk, so it
+// can be used when the metadata server is unreachable, i.e. early at boot. The
+// descriptor is stored along with the instance ID it was fetched for.
+type Cache struct {
+	// path is the cache file location.
+	path string
+
+	// mu serializes writes to the cache file.
+	mu sync.Mutex
+}
+
+// cacheEntry is the on-disk representation of the cached descriptor. The descriptor
+// is kept in the metadata server's own json format so it goes through the same
+// unmarshaling process as a live response when read back.
+type cacheEntry struct {
+	Timestamp  time.Time       `json:"timestamp"`
+	Etag       string          `json:"etag"`
+	InstanceID string          `json:"instanceId,omitempty"`
+	Descriptor json.RawMessage `json:"descriptor"`
+}
+
+// CachedDescriptor is a descriptor read back from the cache.
+type CachedDescriptor struct {
+	// Descriptor is the cached metadata descriptor.
+	Descriptor *Descriptor
+	// Etag is the etag of the metadata server response the descriptor was read from.
+	Etag string
+	// Timestamp is when the descriptor was fetched from the metadata server.
+	Timestamp time.Time
+	// InstanceID is the ID of the instance the descriptor was fetched for, empty for
+	// caches written before it was recorded.
+	InstanceID string
+	// Verified is true if the descriptor was verified to belong to the instance ID
+	// passed to Read(). Unverified descriptors must not be trusted for security
+	// relevant settings, i.e. user accounts and SSH keys.
+	Verified bool
+}
+
+// Age returns how long ago the cached descriptor was fetched.
+func (cd *CachedDescriptor) Age() time.Duration {
+	return time.Since(cd.Timestamp)
+}
+
+// NewCache allocates a new Cache backed by path, if path is empty the default
+// platform specific location is used.
+func NewCache(path string) *Cache {
+	if path == "" {
+		path = defaultCacheFile
+	}
+	return &Cache{path: path}
+}
+
+// write stores the raw metadata server response body and its etag, instanceID is
+// the ID of the instance the response was fetched for.
+func (c *Cache) write(body []byte, etag string, instanceID string) error {
+	entry := cacheEntry{
+		Timestamp:  time.Now(),
+		Etag:       etag,
+		InstanceID: instanceID,
+		Descriptor: body,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// SaferWriteFile creates missing directories with the file's mode, which lacks
+	// the execute bit, create it beforehand.
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory %q: %w", dir, err)
+	}
+
+	// Metadata may contain sensitive data (i.e. startup scripts), keep it readable by root only.
+	return utils.SaferWriteFile(data, c.path, 0600)
+}
+
+// Read reads the last known good descriptor from the cache. instanceID is the ID of
+// the current instance, if known. The descriptor is ignored if it belongs to another
+// instance and is only marked as verified if its instance ID matches instanceID.
+func (c *Cache) Read(instanceID string) (*CachedDescriptor, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata cache %q: %w", c.path, err)
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata cache %q: %w", c.path, err)
+	}
+
+	if instanceID != "" && entry.InstanceID != "" && entry.InstanceID != instanceID {
+		return nil, fmt.Errorf("%w: cached for instance %s, current instance is %s", ErrCacheInstanceMismatch, entry.InstanceID, instanceID)
+	}
+
+	var desc Descriptor
+	if err := json.Unmarshal(entry.Descriptor, &desc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cached descriptor: %w", err)
+	}
+
+	return &CachedDescriptor{
+		Descriptor: &desc,
+		Etag:       entry.Etag,
+		Timestamp:  entry.Timestamp,
+		InstanceID: entry.InstanceID,
+		Verified:   instanceID != "" && entry.InstanceID == instanceID,
+	}, nil
+}
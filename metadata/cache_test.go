@@ -0,0 +1,183 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheFallback(t *testing.T) {
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			// Non-retriable status code, fail fast.
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("etag", "foo")
+		fmt.Fprint(w, `{"instance":{"id":123,"attributes":{"ssh-keys":"name:ssh-rsa [KEY] hostname"}},"project":{"projectId":"test-project"}}`)
+	}))
+	defer ts.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "metadata-cache.json")
+	client := &Client{
+		endpoints: newEndpoints(ts.URL),
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+	client.SetCache(NewCache(cacheFile))
+
+	if _, err := client.Get(context.Background()); err != nil {
+		t.Fatalf("Get(ctx) failed unexpectedly with error: %v", err)
+	}
+
+	info, err := os.Stat(cacheFile)
+	if err != nil {
+		t.Fatalf("Get(ctx) didn't write cache file %q: %v", cacheFile, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Cache file %q has permissions %v, want %v", cacheFile, info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	fail = true
+	if _, err := client.Get(context.Background()); err == nil {
+		t.Fatalf("Get(ctx) succeeded, want error")
+	}
+
+	got, verified, err := client.GetWithFallback(context.Background())
+	if err != nil {
+		t.Fatalf("GetWithFallback(ctx) failed unexpectedly with error: %v", err)
+	}
+
+	// The client fetched the descriptor of the same instance before.
+	if !verified {
+		t.Errorf("GetWithFallback(ctx) returned an unverified descriptor, want it verified against instance 123")
+	}
+
+	if got.Instance.ID.String() != "123" || got.Project.ProjectID != "test-project" {
+		t.Errorf("GetWithFallback(ctx) = %+v, want instance id 123 and project test-project", got)
+	}
+
+	if len(got.Instance.Attributes.SSHKeys) != 1 {
+		t.Errorf("GetWithFallback(ctx) returned %d ssh keys, want 1", len(got.Instance.Attributes.SSHKeys))
+	}
+
+	cached, err := NewCache(cacheFile).Read("")
+	if err != nil {
+		t.Fatalf("Read() failed unexpectedly with error: %v", err)
+	}
+	if cached.Etag != "foo" || cached.InstanceID != "123" || cached.Verified {
+		t.Errorf("Read() = %+v, want etag foo, instance 123 and not verified", cached)
+	}
+	if cached.Age() < 0 || cached.Age() > time.Minute {
+		t.Errorf("Read() returned unexpected age %s", cached.Age())
+	}
+}
+
+func TestCacheFallbackError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		endpoints: newEndpoints(ts.URL),
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	// No cache configured.
+	if _, _, err := client.GetWithFallback(context.Background()); err == nil {
+		t.Errorf("GetWithFallback(ctx) succeeded without cache, want error")
+	}
+
+	// Cache configured but never written.
+	client.SetCache(NewCache(filepath.Join(t.TempDir(), "metadata-cache.json")))
+	if _, _, err := client.GetWithFallback(context.Background()); err == nil {
+		t.Errorf("GetWithFallback(ctx) succeeded with empty cache, want error")
+	}
+}
+
+func TestCacheWriteCreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "google-guest-agent")
+	cache := NewCache(filepath.Join(dir, "metadata-cache.json"))
+
+	if err := cache.write([]byte(`{}`), "foo", ""); err != nil {
+		t.Fatalf("write() failed unexpectedly with error: %v", err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("write() didn't create the cache directory %q: %v", dir, err)
+	}
+
+	// The directory must be traversable for the cache to be read back.
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Cache directory %q has permissions %v, want the execute bit set", dir, info.Mode().Perm())
+	}
+
+	if _, err := cache.Read(""); err != nil {
+		t.Errorf("Read() failed unexpectedly with error: %v", err)
+	}
+}
+
+func TestCacheInstanceID(t *testing.T) {
+	tests := []struct {
+		desc         string
+		cachedID     string
+		instanceID   string
+		wantMismatch bool
+		wantVerified bool
+	}{
+		{desc: "same_instance", cachedID: "123", instanceID: "123", wantVerified: true},
+		{desc: "other_instance", cachedID: "123", instanceID: "456", wantMismatch: true},
+		{desc: "unknown_instance", cachedID: "123"},
+		{desc: "legacy_cache", instanceID: "123"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			cache := NewCache(filepath.Join(t.TempDir(), "metadata-cache.json"))
+			if err := cache.write([]byte(`{"instance":{"id":123}}`), "foo", tc.cachedID); err != nil {
+				t.Fatalf("write() failed unexpectedly with error: %v", err)
+			}
+
+			cached, err := cache.Read(tc.instanceID)
+			if tc.wantMismatch {
+				if !errors.Is(err, ErrCacheInstanceMismatch) {
+					t.Errorf("Read(%q) = %v, want ErrCacheInstanceMismatch", tc.instanceID, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Read(%q) failed unexpectedly with error: %v", tc.instanceID, err)
+			}
+			if cached.Verified != tc.wantVerified {
+				t.Errorf("Read(%q) verified = %t, want %t", tc.instanceID, cached.Verified, tc.wantVerified)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// modeledAttributes are the keys of the attributes modeled by Attributes' typed fields.
+var modeledAttributes = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(attributesJSON{})
+	for i := 0; i < t.NumField(); i++ {
+		keys[t.Field(i).Tag.Get("json")] = true
+	}
+	return keys
+}()
+
+// attributesType is the type of Attributes, its Raw map is diffed without the
+// modeled attributes.
+var attributesType = reflect.TypeOf(Attributes{})
+
+// Change describes a single difference between two descriptors.
+type Change struct {
+	// Path identifies the changed value by its field names, map keys and slice
+	// indexes, i.e. Instance.Attributes.Raw[startup-script] or
+	// Instance.NetworkInterfaces[0].ForwardedIps.
+	Path string
+	// Old is the value before the change, nil if it was added.
+	Old interface{}
+	// New is the value after the change, nil if it was removed.
+	New interface{}
+}
+
+// String returns a human readable representation of c.
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Path, c.Old, c.New)
+}
+
+// Summary returns the changed path without the values, which may be sensitive,
+// i.e. startup scripts.
+func (c Change) Summary() string {
+	return c.Path + " changed"
+}
+
+// Diff returns the changes from m to other sorted by path. Slices of plain values,
+// i.e. ssh keys or forwarded ips, are reported as a single change of the whole slice
+// while slices of structs and maps are compared element by element. Pointers are
+// dereferenced, so changes to optional attributes report their values. Attributes
+// modeled by a typed field are only reported through that field, not as a change
+// of Raw. A nil descriptor is handled as an empty one.
+func (m *Descriptor) Diff(other *Descriptor) []Change {
+	if m == nil {
+		m = &Descriptor{}
+	}
+	if other == nil {
+		other = &Descriptor{}
+	}
+
+	var changes []Change
+	diffValues("", reflect.ValueOf(*m), reflect.ValueOf(*other), &changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// valueOf returns the interface value of v or nil if v is not valid.
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// diffValues walks a and b appending their differences to changes, a or b are invalid
+// values if missing on either side (i.e. a map key or slice element was added).
+func diffValues(path string, a, b reflect.Value, changes *[]Change) {
+	if !a.IsValid() || !b.IsValid() {
+		if a.IsValid() || b.IsValid() {
+			*changes = append(*changes, Change{Path: path, Old: valueOf(a), New: valueOf(b)})
+		}
+		return
+	}
+
+	switch a.Kind() {
+	case reflect.Pointer:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				*changes = append(*changes, Change{Path: path, Old: nilOrElem(a), New: nilOrElem(b)})
+			}
+			return
+		}
+		diffValues(path, a.Elem(), b.Elem(), changes)
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fa, fb := a.Field(i), b.Field(i)
+			if a.Type() == attributesType && field.Name == "Raw" {
+				fa, fb = unmodeledAttributes(fa), unmodeledAttributes(fb)
+			}
+			diffValues(joinPath(path, field.Name), fa, fb, changes)
+		}
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, k := range append(a.MapKeys(), b.MapKeys()...) {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			k := keys[name]
+			diffValues(fmt.Sprintf("%s[%s]", path, name), a.MapIndex(k), b.MapIndex(k), changes)
+		}
+	case reflect.Slice:
+		elem := a.Type().Elem().Kind()
+		if elem != reflect.Struct && elem != reflect.Map {
+			if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+				*changes = append(*changes, Change{Path: path, Old: a.Interface(), New: b.Interface()})
+			}
+			return
+		}
+
+		for i := 0; i < a.Len() || i < b.Len(); i++ {
+			var ea, eb reflect.Value
+			if i < a.Len() {
+				ea = a.Index(i)
+			}
+			if i < b.Len() {
+				eb = b.Index(i)
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), ea, eb, changes)
+		}
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changes = append(*changes, Change{Path: path, Old: a.Interface(), New: b.Interface()})
+		}
+	}
+}
+
+// unmodeledAttributes returns a copy of the Raw attributes map raw without the
+// attributes modeled by a typed field.
+func unmodeledAttributes(raw reflect.Value) reflect.Value {
+	res := make(map[string]string)
+	for k, v := range raw.Interface().(map[string]string) {
+		if !modeledAttributes[k] {
+			res[k] = v
+		}
+	}
+	return reflect.ValueOf(res)
+}
+
+// nilOrElem returns the value pointed by v or nil if v is a nil pointer.
+func nilOrElem(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
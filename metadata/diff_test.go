@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	mkbool := func(b bool) *bool { return &b }
+
+	tests := []struct {
+		desc string
+		old  *Descriptor
+		new  *Descriptor
+		want []Change
+	}{
+		{
+			desc: "no_changes",
+			old:  &Descriptor{Instance: Instance{ID: json.Number("1"), Attributes: Attributes{SSHKeys: []string{"key"}}}},
+			new:  &Descriptor{Instance: Instance{ID: json.Number("1"), Attributes: Attributes{SSHKeys: []string{"key"}}}},
+		},
+		{
+			desc: "nil_descriptors",
+		},
+		{
+			desc: "plain_slice",
+			old:  &Descriptor{Instance: Instance{Attributes: Attributes{SSHKeys: []string{"key1"}}}},
+			new:  &Descriptor{Instance: Instance{Attributes: Attributes{SSHKeys: []string{"key1", "key2"}}}},
+			want: []Change{{Path: "Instance.Attributes.SSHKeys", Old: []string{"key1"}, New: []string{"key1", "key2"}}},
+		},
+		{
+			desc: "pointers",
+			old:  &Descriptor{Project: Project{Attributes: Attributes{EnableOSLogin: mkbool(false)}}},
+			new:  &Descriptor{Project: Project{Attributes: Attributes{EnableOSLogin: mkbool(true), TwoFactor: mkbool(true)}}},
+			want: []Change{
+				{Path: "Project.Attributes.EnableOSLogin", Old: false, New: true},
+				{Path: "Project.Attributes.TwoFactor", Old: nil, New: true},
+			},
+		},
+		{
+			desc: "maps",
+			old:  &Descriptor{Instance: Instance{Attributes: Attributes{Raw: map[string]string{"a": "1", "b": "2"}}}},
+			new:  &Descriptor{Instance: Instance{Attributes: Attributes{Raw: map[string]string{"a": "1", "c": "3"}}}},
+			want: []Change{
+				{Path: "Instance.Attributes.Raw[b]", Old: "2", New: nil},
+				{Path: "Instance.Attributes.Raw[c]", Old: nil, New: "3"},
+			},
+		},
+		{
+			desc: "modeled_attributes",
+			old: &Descriptor{Instance: Instance{Attributes: Attributes{EnableOSLogin: mkbool(false),
+				Raw: map[string]string{"enable-oslogin": "false", "startup-script": "echo 1"}}}},
+			new: &Descriptor{Instance: Instance{Attributes: Attributes{EnableOSLogin: mkbool(true),
+				Raw: map[string]string{"enable-oslogin": "true", "startup-script": "echo 2"}}}},
+			want: []Change{
+				{Path: "Instance.Attributes.EnableOSLogin", Old: false, New: true},
+				{Path: "Instance.Attributes.Raw[startup-script]", Old: "echo 1", New: "echo 2"},
+			},
+		},
+		{
+			desc: "struct_slices",
+			old: &Descriptor{Instance: Instance{NetworkInterfaces: []NetworkInterfaces{
+				{Mac: "mac0", ForwardedIps: []string{"1.1.1.1"}},
+			}}},
+			new: &Descriptor{Instance: Instance{NetworkInterfaces: []NetworkInterfaces{
+				{Mac: "mac0", ForwardedIps: []string{"2.2.2.2"}},
+				{Mac: "mac1"},
+			}}},
+			want: []Change{
+				{Path: "Instance.NetworkInterfaces[0].ForwardedIps", Old: []string{"1.1.1.1"}, New: []string{"2.2.2.2"}},
+				{Path: "Instance.NetworkInterfaces[1]", Old: nil, New: NetworkInterfaces{Mac: "mac1"}},
+			},
+		},
+		{
+			desc: "vlan_interfaces",
+			old:  &Descriptor{Instance: Instance{VlanNetworkInterfaces: []map[int]VlanInterface{{5: {Vlan: 5, MTU: 1460}}}}},
+			new:  &Descriptor{Instance: Instance{VlanNetworkInterfaces: []map[int]VlanInterface{{5: {Vlan: 5, MTU: 1500}}}}},
+			want: []Change{{Path: "Instance.VlanNetworkInterfaces[0][5].MTU", Old: 1460, New: 1500}},
+		},
+		{
+			desc: "drift_token",
+			old:  &Descriptor{},
+			new:  &Descriptor{Instance: Instance{VirtualClock: virtualClock{DriftToken: 2}}},
+			want: []Change{{Path: "Instance.VirtualClock.DriftToken", Old: 0, New: 2}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := tc.old.Diff(tc.new)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChangeSummary(t *testing.T) {
+	change := Change{Path: "Instance.Attributes.Raw[startup-script]", Old: "echo secret", New: "echo other-secret"}
+	if got, want := change.Summary(), "Instance.Attributes.Raw[startup-script] changed"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
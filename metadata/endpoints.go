@@ -0,0 +1,189 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// metadataHostEnv is the environment variable overriding the metadata server host,
+	// when set it takes precedence over any configured endpoint.
+	metadataHostEnv = "GCE_METADATA_HOST"
+
+	// metadataPath is the path appended to endpoints configured without one.
+	metadataPath = "/computeMetadata/v1/"
+
+	// endpointRetryInterval is how long a failing endpoint is skipped before
+	// being tried again.
+	endpointRetryInterval = 30 * time.Second
+)
+
+// defaultEndpoints is the endpoint list shared by all clients created with New(),
+// it's initialized from metadataHostEnv and can be overridden with SetEndpoints().
+var defaultEndpoints = newEndpoints(envEndpoint())
+
+// endpoint is a metadata server base URL and its health state.
+type endpoint struct {
+	// url is the base URL, always terminated with a slash.
+	url string
+	// mtls is true if the endpoint supports the mTLS HTTPS scheme, only the
+	// default metadata server does.
+	mtls bool
+	// failures is the number of consecutive transport failures.
+	failures int
+	// lastFailure is the time of the last transport failure.
+	lastFailure time.Time
+}
+
+// endpoints is an ordered list of metadata server endpoints, requests go to the
+// first healthy endpoint falling back to the following ones on failure.
+type endpoints struct {
+	mu   sync.Mutex
+	list []*endpoint
+}
+
+// envEndpoint returns the endpoint defined by metadataHostEnv or the default
+// metadata server URL if it's not set.
+func envEndpoint() string {
+	if host := os.Getenv(metadataHostEnv); host != "" {
+		return "http://" + host + metadataPath
+	}
+	return defaultMetadataURL
+}
+
+// newEndpoints allocates an endpoint list for urls, urls are expected to be
+// already normalized.
+func newEndpoints(urls ...string) *endpoints {
+	e := &endpoints{}
+	e.set(urls)
+	return e
+}
+
+func (e *endpoints) set(urls []string) {
+	var list []*endpoint
+	for _, u := range urls {
+		list = append(list, &endpoint{url: u, mtls: u == defaultMetadataURL})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = list
+}
+
+// normalizeEndpoint validates rawURL and makes sure it carries the metadata path
+// and a trailing slash.
+func normalizeEndpoint(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse endpoint %q: %w", rawURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid endpoint %q: scheme must be http or https", rawURL)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid endpoint %q: missing host", rawURL)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = metadataPath
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u.String(), nil
+}
+
+// SetEndpoints sets the metadata server endpoints used by all clients created with
+// New(), urls are tried in order and blank entries are ignored. An empty list restores
+// the default endpoint. If GCE_METADATA_HOST is set it takes precedence and urls are
+// ignored.
+func SetEndpoints(urls []string) error {
+	if host := os.Getenv(metadataHostEnv); host != "" {
+		logger.Infof("%s is set, ignoring configured metadata endpoints", metadataHostEnv)
+		return nil
+	}
+
+	var normalized []string
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		n, err := normalizeEndpoint(u)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, n)
+	}
+
+	if len(normalized) == 0 {
+		normalized = []string{defaultMetadataURL}
+	}
+
+	defaultEndpoints.set(normalized)
+	return nil
+}
+
+// pick returns the endpoint the next request should go to: the first one that's
+// healthy or whose retry interval has elapsed, or the one that failed the longest
+// ago if all of them are failing.
+func (e *endpoints) pick() *endpoint {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var oldest *endpoint
+	for _, ep := range e.list {
+		if ep.failures == 0 || time.Since(ep.lastFailure) >= endpointRetryInterval {
+			return ep
+		}
+		if oldest == nil || ep.lastFailure.Before(oldest.lastFailure) {
+			oldest = ep
+		}
+	}
+	return oldest
+}
+
+// report records the outcome of a request sent to ep, err must only be set for
+// transport errors so that valid server responses don't mark ep as failing.
+func (e *endpoints) report(ep *endpoint, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err == nil {
+		if ep.failures > 0 {
+			logger.Infof("Metadata endpoint %s recovered after %d failure(s)", ep.url, ep.failures)
+		}
+		ep.failures = 0
+		return
+	}
+
+	if ep.failures == 0 && len(e.list) > 1 {
+		logger.Warningf("Metadata endpoint %s failed (%v), falling back to the next endpoint", ep.url, err)
+	}
+	ep.failures++
+	ep.lastFailure = time.Now()
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		desc    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{desc: "host_only", url: "http://localhost:8080", want: "http://localhost:8080/computeMetadata/v1/"},
+		{desc: "root_path", url: "http://localhost/", want: "http://localhost/computeMetadata/v1/"},
+		{desc: "custom_path", url: "https://mds.example.com/v1", want: "https://mds.example.com/v1/"},
+		{desc: "full_url", url: defaultMetadataURL, want: defaultMetadataURL},
+		{desc: "invalid_scheme", url: "ftp://localhost", wantErr: true},
+		{desc: "missing_host", url: "http:///computeMetadata/v1/", wantErr: true},
+		{desc: "no_scheme", url: "localhost:8080", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := normalizeEndpoint(tc.url)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("normalizeEndpoint(%q) = error %v, want error: %t", tc.url, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("normalizeEndpoint(%q) = %q, want %q", tc.url, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetEndpoints(t *testing.T) {
+	orig := defaultEndpoints.list
+	t.Cleanup(func() { defaultEndpoints.list = orig })
+
+	if err := SetEndpoints([]string{"http://localhost:8080", "http://localhost:8081"}); err != nil {
+		t.Fatalf("SetEndpoints() failed unexpectedly with error: %v", err)
+	}
+	if got := New().endpoints.pick().url; got != "http://localhost:8080/computeMetadata/v1/" {
+		t.Errorf("New().endpoints.pick() = %q, want first configured endpoint", got)
+	}
+
+	if err := SetEndpoints([]string{"ftp://localhost"}); err == nil {
+		t.Errorf("SetEndpoints(ftp://localhost) succeeded, want error")
+	}
+
+	if err := SetEndpoints(nil); err != nil {
+		t.Fatalf("SetEndpoints(nil) failed unexpectedly with error: %v", err)
+	}
+	ep := New().endpoints.pick()
+	if ep.url != defaultMetadataURL || !ep.mtls {
+		t.Errorf("New().endpoints.pick() = {%q, mtls: %t}, want {%q, mtls: true}", ep.url, ep.mtls, defaultMetadataURL)
+	}
+
+	// Environment variable takes precedence over the configuration.
+	t.Setenv(metadataHostEnv, "localhost:9090")
+	if got := envEndpoint(); got != "http://localhost:9090/computeMetadata/v1/" {
+		t.Errorf("envEndpoint() = %q, want %q", got, "http://localhost:9090/computeMetadata/v1/")
+	}
+	if err := SetEndpoints([]string{"http://localhost:8080"}); err != nil {
+		t.Fatalf("SetEndpoints() failed unexpectedly with error: %v", err)
+	}
+	if got := New().endpoints.pick().url; got != defaultMetadataURL {
+		t.Errorf("New().endpoints.pick() = %q, want %q with %s set", got, defaultMetadataURL, metadataHostEnv)
+	}
+}
+
+func TestEndpointFallback(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downURL := down.URL + "/"
+	// Closing the server makes connections to it fail.
+	down.Close()
+
+	var hits int
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, "value")
+	}))
+	defer up.Close()
+
+	client := &Client{
+		endpoints: newEndpoints(downURL, up.URL+"/"),
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := client.GetKey(context.Background(), "key", nil)
+		if err != nil {
+			t.Fatalf("GetKey(ctx, key) failed unexpectedly with error: %v", err)
+		}
+		if got != "value" {
+			t.Errorf("GetKey(ctx, key) = %q, want %q", got, "value")
+		}
+	}
+
+	if hits != 2 {
+		t.Errorf("Fallback endpoint got %d requests, want 2", hits)
+	}
+	if failures := client.endpoints.list[0].failures; failures != 1 {
+		t.Errorf("Failing endpoint has %d failures, want 1 (should be skipped once failing)", failures)
+	}
+
+	// Once the retry interval elapses the first endpoint is tried again.
+	client.endpoints.list[0].lastFailure = time.Now().Add(-endpointRetryInterval)
+	if got := client.endpoints.pick(); got != client.endpoints.list[0] {
+		t.Errorf("pick() = %q, want %q after retry interval", got.url, downURL)
+	}
+}
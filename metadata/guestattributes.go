@@ -0,0 +1,444 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// guestAttributesKey is the metadata key guest attributes are stored under.
+	guestAttributesKey = "instance/guest-attributes/"
+
+	// guestAttributeQueueMaxLen is the maximum number of pending operations, the
+	// oldest ones are dropped when it's exceeded.
+	guestAttributeQueueMaxLen = 128
+
+	// guestAttributeQueueMaxAge is how long a pending operation is retried before
+	// it's dropped.
+	guestAttributeQueueMaxAge = 24 * time.Hour
+)
+
+// ErrGuestAttributeQueued is returned, wrapped with the cause, when a guest attribute
+// write or delete failed to reach the metadata server and was queued for retry.
+var ErrGuestAttributeQueued = errors.New("guest attribute operation queued for retry")
+
+// GuestAttributeQueue persists guest attribute writes and deletes that failed to reach
+// the metadata server, so they can be retried later instead of being lost. Operations
+// are kept in order and only the latest operation of a given key is retained.
+type GuestAttributeQueue struct {
+	// path is the queue file location.
+	path string
+
+	// mu protects ops, seq and keys and serializes writes to the queue file.
+	mu sync.Mutex
+	// ops are the pending operations, nil until loaded from path.
+	ops []guestAttributeOp
+	// seq is the sequence number of the latest queued operation.
+	seq uint64
+	// keys serialize the requests of each key, so a flushed operation never
+	// overrides a newer operation of the same key.
+	keys map[string]*sync.Mutex
+}
+
+// guestAttributeOp is a pending guest attribute operation.
+type guestAttributeOp struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value,omitempty"`
+	Delete    bool      `json:"delete,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Seq identifies the operation, a newer operation of the same key gets a
+	// higher sequence number.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// NewGuestAttributeQueue allocates a new GuestAttributeQueue backed by path, if path
+// is empty the default platform specific location is used.
+func NewGuestAttributeQueue(path string) *GuestAttributeQueue {
+	if path == "" {
+		path = defaultGuestAttributeQueueFile
+	}
+	return &GuestAttributeQueue{path: path}
+}
+
+// load reads the pending operations from disk if not yet loaded, must be called
+// with q.mu held.
+func (q *GuestAttributeQueue) load() error {
+	if q.ops != nil {
+		return nil
+	}
+
+	q.ops = []guestAttributeOp{}
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read guest attribute queue %q: %w", q.path, err)
+	}
+
+	if err := json.Unmarshal(data, &q.ops); err != nil {
+		return fmt.Errorf("failed to unmarshal guest attribute queue %q: %w", q.path, err)
+	}
+
+	for _, op := range q.ops {
+		if op.Seq > q.seq {
+			q.seq = op.Seq
+		}
+	}
+	return nil
+}
+
+// save writes the pending operations to disk, must be called with q.mu held.
+func (q *GuestAttributeQueue) save() error {
+	if len(q.ops) == 0 {
+		if err := os.Remove(q.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove guest attribute queue %q: %w", q.path, err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(q.ops)
+	if err != nil {
+		return fmt.Errorf("failed to marshal guest attribute queue: %w", err)
+	}
+
+	// SaferWriteFile creates missing directories with the file's mode, which lacks
+	// the execute bit, create it beforehand.
+	dir := filepath.Dir(q.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create guest attribute queue directory %q: %w", dir, err)
+	}
+	return utils.SaferWriteFile(data, q.path, 0600)
+}
+
+// push queues op, replacing any pending operation of the same key.
+func (q *GuestAttributeQueue) push(op guestAttributeOp) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		return err
+	}
+	q.seq++
+	op.Seq = q.seq
+	q.ops = append(q.removeKey(op.Key), op)
+
+	if dropped := len(q.ops) - guestAttributeQueueMaxLen; dropped > 0 {
+		logger.Warningf("Guest attribute queue is full, dropping the %d oldest operation(s)", dropped)
+		q.ops = q.ops[dropped:]
+	}
+	return q.save()
+}
+
+// pending returns a copy of the pending operations, the operations queued for
+// longer than guestAttributeQueueMaxAge are dropped.
+func (q *GuestAttributeQueue) pending() ([]guestAttributeOp, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	ops := []guestAttributeOp{}
+	for _, op := range q.ops {
+		if time.Since(op.Timestamp) > guestAttributeQueueMaxAge {
+			logger.Warningf("Dropping guest attribute %q queued at %s, it expired", op.Key, op.Timestamp.Format(time.RFC3339))
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	if len(ops) != len(q.ops) {
+		q.ops = ops
+		if err := q.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return append([]guestAttributeOp{}, ops...), nil
+}
+
+// remove drops the pending operation op, if it wasn't superseded by a newer
+// operation of the same key in the meantime.
+func (q *GuestAttributeQueue) remove(op guestAttributeOp) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		return err
+	}
+
+	ops := []guestAttributeOp{}
+	for _, curr := range q.ops {
+		if curr.Key != op.Key || curr.Seq != op.Seq {
+			ops = append(ops, curr)
+		}
+	}
+
+	if len(ops) == len(q.ops) {
+		return nil
+	}
+	q.ops = ops
+	return q.save()
+}
+
+// queued reports whether op is still pending, that is it wasn't superseded by a
+// newer operation of the same key nor settled.
+func (q *GuestAttributeQueue) queued(op guestAttributeOp) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		return false, err
+	}
+
+	for _, curr := range q.ops {
+		if curr.Key == op.Key && curr.Seq == op.Seq {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lockKey serializes the requests of key, the returned function unlocks it.
+func (q *GuestAttributeQueue) lockKey(key string) func() {
+	q.mu.Lock()
+	if q.keys == nil {
+		q.keys = make(map[string]*sync.Mutex)
+	}
+	mu, ok := q.keys[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		q.keys[key] = mu
+	}
+	q.mu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// settle drops any pending operation of key, it's called once a newer operation
+// of key reached the metadata server so that stale values are not replayed.
+func (q *GuestAttributeQueue) settle(key string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		return err
+	}
+
+	ops := q.removeKey(key)
+	if len(ops) == len(q.ops) {
+		return nil
+	}
+	q.ops = ops
+	return q.save()
+}
+
+// removeKey returns the pending operations without the ones of key, must be called
+// with q.mu held.
+func (q *GuestAttributeQueue) removeKey(key string) []guestAttributeOp {
+	ops := []guestAttributeOp{}
+	for _, op := range q.ops {
+		if op.Key != key {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Len returns the number of pending operations.
+func (q *GuestAttributeQueue) Len() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		return 0, err
+	}
+	return len(q.ops), nil
+}
+
+// SetGuestAttributeQueue enables queuing guest attribute writes and deletes that
+// failed to reach the metadata server, see FlushGuestAttributes.
+func (c *Client) SetGuestAttributeQueue(queue *GuestAttributeQueue) {
+	c.gaQueue = queue
+}
+
+// FlushGuestAttributes retries the queued guest attribute operations in order. It
+// stops at the first transient failure, leaving it and the following operations
+// queued. Operations rejected by the metadata server are dropped.
+func (c *Client) FlushGuestAttributes(ctx context.Context) error {
+	if c.gaQueue == nil {
+		return nil
+	}
+
+	ops, err := c.gaQueue.pending()
+	if err != nil {
+		return err
+	}
+
+	// The queue isn't locked while the requests are in flight, so writes aren't
+	// blocked by a slow metadata server.
+	for _, op := range ops {
+		if err := c.flushGuestAttribute(ctx, op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flushGuestAttribute sends the queued operation op and drops it from the queue. op
+// is skipped if a newer operation of the same key superseded or settled it since
+// the queue was read. An error is returned if op is still pending.
+func (c *Client) flushGuestAttribute(ctx context.Context, op guestAttributeOp) error {
+	// The check and the request are serialized with the newer operations of the key,
+	// so a stale value never reaches the metadata server after a newer one.
+	defer c.gaQueue.lockKey(op.Key)()
+
+	queued, err := c.gaQueue.queued(op)
+	if err != nil {
+		return err
+	}
+	if !queued {
+		logger.Debugf("Skipping queued guest attribute %q, superseded by a newer operation", op.Key)
+		return nil
+	}
+
+	status, err := c.guestAttributeRequest(ctx, op)
+	if err != nil && retriableGuestAttributeStatus(status) {
+		return fmt.Errorf("failed to flush guest attribute %q queued at %s: %w", op.Key, op.Timestamp.Format(time.RFC3339), err)
+	}
+
+	if err != nil {
+		logger.Warningf("Dropping queued guest attribute %q, rejected by the metadata server: %v", op.Key, err)
+	} else {
+		logger.Debugf("Flushed queued guest attribute %q", op.Key)
+	}
+
+	return c.gaQueue.remove(op)
+}
+
+// retriableGuestAttributeStatus reports whether a guest attribute request failing
+// with the HTTP status code status is worth retrying, status is zero if the metadata
+// server couldn't be reached. Other 4xx errors, i.e. 403 when guest attributes are
+// disabled, are permanent.
+func retriableGuestAttributeStatus(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// guestAttributeRequest sends op to the metadata server, it's not retried. It returns
+// the response's status code, zero if there's no response.
+func (c *Client) guestAttributeRequest(ctx context.Context, op guestAttributeOp) (int, error) {
+	cfg := requestConfig{
+		key:    guestAttributesKey + op.Key,
+		method: http.MethodPut,
+		body:   op.Value,
+	}
+	if op.Delete {
+		cfg.method = http.MethodDelete
+		cfg.body = ""
+	}
+
+	var status int
+	resp, err := c.do(ctx, cfg)
+	if resp != nil {
+		status = resp.StatusCode
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return status, err
+}
+
+// queueGuestAttribute sends op to the metadata server, if that fails with a transient
+// error and a queue is configured op is queued for later retries and the error is
+// returned wrapped with ErrGuestAttributeQueued. Permanent errors are returned as is.
+func (c *Client) queueGuestAttribute(ctx context.Context, op guestAttributeOp) error {
+	if c.gaQueue == nil {
+		_, err := c.guestAttributeRequest(ctx, op)
+		return err
+	}
+
+	// Serialized with the flush of a queued operation of the same key.
+	defer c.gaQueue.lockKey(op.Key)()
+
+	status, err := c.guestAttributeRequest(ctx, op)
+	if err == nil || !retriableGuestAttributeStatus(status) {
+		// Either way a pending operation of the same key is stale.
+		if err := c.gaQueue.settle(op.Key); err != nil {
+			logger.Warningf("Failed to update guest attribute queue: %v", err)
+		}
+		return err
+	}
+
+	op.Timestamp = time.Now()
+	if qErr := c.gaQueue.push(op); qErr != nil {
+		return errors.Join(err, qErr)
+	}
+
+	return fmt.Errorf("%w: %w", ErrGuestAttributeQueued, err)
+}
+
+// WriteGuestAttributes does a put call to mds changing a guest attribute value. If
+// the write fails and a guest attribute queue is set it's queued for retry, the
+// returned error wraps ErrGuestAttributeQueued.
+func (c *Client) WriteGuestAttributes(ctx context.Context, key, value string) error {
+	logger.Debugf("write guest attribute %q", key)
+	return c.queueGuestAttribute(ctx, guestAttributeOp{Key: key, Value: value})
+}
+
+// DeleteGuestAttribute deletes the guest attribute key, key is in the namespace/key
+// format. If the delete fails and a guest attribute queue is set it's queued for retry,
+// the returned error wraps ErrGuestAttributeQueued.
+func (c *Client) DeleteGuestAttribute(ctx context.Context, key string) error {
+	logger.Debugf("delete guest attribute %q", key)
+	return c.queueGuestAttribute(ctx, guestAttributeOp{Key: key, Delete: true})
+}
+
+// GetGuestAttribute gets the value of the guest attribute key, key is in the
+// namespace/key format.
+func (c *Client) GetGuestAttribute(ctx context.Context, key string) (string, error) {
+	return c.GetKey(ctx, guestAttributesKey+key, nil)
+}
+
+// ListGuestAttributes returns all the guest attributes of namespace mapped by key.
+func (c *Client) ListGuestAttributes(ctx context.Context, namespace string) (map[string]string, error) {
+	namespace = strings.Trim(namespace, "/")
+	resp, err := c.GetKeyRecursive(ctx, guestAttributesKey+namespace+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string)
+	if err := json.Unmarshal([]byte(resp), &attrs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal guest attributes of namespace %q: %w", namespace, err)
+	}
+	return attrs, nil
+}
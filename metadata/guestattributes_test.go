@@ -0,0 +1,343 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGuestAttributes is a minimal guest attributes metadata server.
+type fakeGuestAttributes struct {
+	mu    sync.Mutex
+	attrs map[string]string
+	down  bool
+	// status, if set, is the status code of every write and delete.
+	status int
+}
+
+func (f *fakeGuestAttributes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if f.status != 0 && r.Method != http.MethodGet {
+		w.WriteHeader(f.status)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+guestAttributesKey)
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.attrs[key] = string(body)
+	case http.MethodDelete:
+		delete(f.attrs, key)
+	case http.MethodGet:
+		if strings.HasSuffix(key, "/") {
+			var resp []string
+			for k, v := range f.attrs {
+				if strings.HasPrefix(k, key) {
+					resp = append(resp, `"`+strings.TrimPrefix(k, key)+`":"`+v+`"`)
+				}
+			}
+			io.WriteString(w, "{"+strings.Join(resp, ",")+"}")
+			return
+		}
+		v, ok := f.attrs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, v)
+	}
+}
+
+func (f *fakeGuestAttributes) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeGuestAttributes) setStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func TestGuestAttributes(t *testing.T) {
+	srv := &fakeGuestAttributes{attrs: map[string]string{"other/key": "value"}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 1 * time.Second},
+	}
+
+	for k, v := range map[string]string{"hostkeys/ssh-rsa": "rsa-key", "hostkeys/ssh-ed25519": "ed25519-key"} {
+		if err := client.WriteGuestAttributes(ctx, k, v); err != nil {
+			t.Fatalf("WriteGuestAttributes(ctx, %q, %q) failed unexpectedly with error: %v", k, v, err)
+		}
+	}
+
+	got, err := client.GetGuestAttribute(ctx, "hostkeys/ssh-rsa")
+	if err != nil {
+		t.Fatalf("GetGuestAttribute(ctx, hostkeys/ssh-rsa) failed unexpectedly with error: %v", err)
+	}
+	if got != "rsa-key" {
+		t.Errorf("GetGuestAttribute(ctx, hostkeys/ssh-rsa) = %q, want %q", got, "rsa-key")
+	}
+
+	if err := client.DeleteGuestAttribute(ctx, "hostkeys/ssh-ed25519"); err != nil {
+		t.Fatalf("DeleteGuestAttribute(ctx, hostkeys/ssh-ed25519) failed unexpectedly with error: %v", err)
+	}
+
+	list, err := client.ListGuestAttributes(ctx, "hostkeys")
+	if err != nil {
+		t.Fatalf("ListGuestAttributes(ctx, hostkeys) failed unexpectedly with error: %v", err)
+	}
+	if want := map[string]string{"ssh-rsa": "rsa-key"}; !reflect.DeepEqual(list, want) {
+		t.Errorf("ListGuestAttributes(ctx, hostkeys) = %v, want %v", list, want)
+	}
+
+	if _, err := client.GetGuestAttribute(ctx, "hostkeys/ssh-ed25519"); err == nil {
+		t.Errorf("GetGuestAttribute(ctx, hostkeys/ssh-ed25519) succeeded for deleted attribute, want error")
+	}
+
+	// Without a queue write failures are returned.
+	srv.setDown(true)
+	if err := client.WriteGuestAttributes(ctx, "hostkeys/ssh-rsa", "new-key"); err == nil {
+		t.Errorf("WriteGuestAttributes(ctx, hostkeys/ssh-rsa, new-key) succeeded with metadata server down, want error")
+	}
+}
+
+func TestGuestAttributeQueue(t *testing.T) {
+	srv := &fakeGuestAttributes{attrs: map[string]string{"hostkeys/ssh-dsa": "dsa-key"}, down: true}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 1 * time.Second},
+	}
+	client.SetGuestAttributeQueue(NewGuestAttributeQueue(queueFile))
+
+	ops := []struct {
+		key, value string
+		delete     bool
+	}{
+		{key: "guest-agent/sshable", value: "1"},
+		{key: "hostkeys/ssh-rsa", value: "rsa-key"},
+		{key: "hostkeys/ssh-dsa", delete: true},
+		// Supersedes the first write.
+		{key: "guest-agent/sshable", value: "2"},
+	}
+	for _, op := range ops {
+		var err error
+		if op.delete {
+			err = client.DeleteGuestAttribute(ctx, op.key)
+		} else {
+			err = client.WriteGuestAttributes(ctx, op.key, op.value)
+		}
+		if !errors.Is(err, ErrGuestAttributeQueued) {
+			t.Fatalf("Operation on guest attribute %q returned error %v, want ErrGuestAttributeQueued", op.key, err)
+		}
+	}
+
+	// A new queue reading the same file should find the pending operations.
+	if n, err := NewGuestAttributeQueue(queueFile).Len(); err != nil || n != 3 {
+		t.Errorf("GuestAttributeQueue.Len() = (%d, %v), want (3, nil)", n, err)
+	}
+
+	if err := client.FlushGuestAttributes(ctx); err == nil {
+		t.Errorf("FlushGuestAttributes(ctx) succeeded with metadata server down, want error")
+	}
+
+	// A successful write drops the pending operation of the same key.
+	srv.setDown(false)
+	if err := client.WriteGuestAttributes(ctx, "hostkeys/ssh-rsa", "new-rsa-key"); err != nil {
+		t.Fatalf("WriteGuestAttributes(ctx, hostkeys/ssh-rsa, new-rsa-key) failed unexpectedly with error: %v", err)
+	}
+
+	if err := client.FlushGuestAttributes(ctx); err != nil {
+		t.Fatalf("FlushGuestAttributes(ctx) failed unexpectedly with error: %v", err)
+	}
+
+	want := map[string]string{"guest-agent/sshable": "2", "hostkeys/ssh-rsa": "new-rsa-key"}
+	if !reflect.DeepEqual(srv.attrs, want) {
+		t.Errorf("FlushGuestAttributes(ctx) left guest attributes %v, want %v", srv.attrs, want)
+	}
+
+	if n, err := NewGuestAttributeQueue(queueFile).Len(); err != nil || n != 0 {
+		t.Errorf("GuestAttributeQueue.Len() after flush = (%d, %v), want (0, nil)", n, err)
+	}
+}
+
+func TestGuestAttributeQueuePermanentErrors(t *testing.T) {
+	srv := &fakeGuestAttributes{attrs: map[string]string{}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 1 * time.Second},
+	}
+	client.SetGuestAttributeQueue(NewGuestAttributeQueue(queueFile))
+
+	var tests = []struct {
+		name      string
+		status    int
+		wantQueue int
+	}{
+		{"forbidden", http.StatusForbidden, 0},
+		{"bad_request", http.StatusBadRequest, 0},
+		{"too_many_requests", http.StatusTooManyRequests, 1},
+		{"unavailable", http.StatusServiceUnavailable, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.setStatus(tt.status)
+			err := client.WriteGuestAttributes(ctx, "guest-agent/"+tt.name, "value")
+			if err == nil {
+				t.Errorf("WriteGuestAttributes(ctx, guest-agent/%s, value) succeeded, want error", tt.name)
+			}
+			if queued := errors.Is(err, ErrGuestAttributeQueued); queued != (tt.wantQueue > 0) {
+				t.Errorf("WriteGuestAttributes(ctx, guest-agent/%s, value) = %v, want queued: %t", tt.name, err, tt.wantQueue > 0)
+			}
+
+			if n, err := client.gaQueue.Len(); err != nil || n != tt.wantQueue {
+				t.Errorf("GuestAttributeQueue.Len() = (%d, %v), want (%d, nil)", n, err, tt.wantQueue)
+			}
+
+			// Queued operations rejected when flushed are dropped.
+			srv.setStatus(http.StatusForbidden)
+			if err := client.FlushGuestAttributes(ctx); err != nil {
+				t.Errorf("FlushGuestAttributes(ctx) failed unexpectedly with error: %v", err)
+			}
+
+			if n, err := client.gaQueue.Len(); err != nil || n != 0 {
+				t.Errorf("GuestAttributeQueue.Len() after flush = (%d, %v), want (0, nil)", n, err)
+			}
+		})
+	}
+}
+
+func TestGuestAttributeQueueStaleFlush(t *testing.T) {
+	srv := &fakeGuestAttributes{attrs: map[string]string{}, down: true}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 1 * time.Second},
+	}
+	client.SetGuestAttributeQueue(NewGuestAttributeQueue(filepath.Join(t.TempDir(), "queue.json")))
+
+	for _, key := range []string{"guest-agent/settled", "guest-agent/superseded"} {
+		if err := client.WriteGuestAttributes(ctx, key, "old"); !errors.Is(err, ErrGuestAttributeQueued) {
+			t.Fatalf("WriteGuestAttributes(ctx, %s, old) returned error %v, want ErrGuestAttributeQueued", key, err)
+		}
+	}
+
+	// The flush reads the queue before the newer operations of the keys.
+	ops, err := client.gaQueue.pending()
+	if err != nil {
+		t.Fatalf("pending() failed unexpectedly with error: %v", err)
+	}
+
+	if err := client.WriteGuestAttributes(ctx, "guest-agent/superseded", "new"); !errors.Is(err, ErrGuestAttributeQueued) {
+		t.Fatalf("WriteGuestAttributes(ctx, guest-agent/superseded, new) returned error %v, want ErrGuestAttributeQueued", err)
+	}
+	srv.setDown(false)
+	if err := client.WriteGuestAttributes(ctx, "guest-agent/settled", "new"); err != nil {
+		t.Fatalf("WriteGuestAttributes(ctx, guest-agent/settled, new) failed unexpectedly with error: %v", err)
+	}
+
+	for _, op := range ops {
+		if err := client.flushGuestAttribute(ctx, op); err != nil {
+			t.Fatalf("flushGuestAttribute(ctx, %s) failed unexpectedly with error: %v", op.Key, err)
+		}
+	}
+
+	if want := map[string]string{"guest-agent/settled": "new"}; !reflect.DeepEqual(srv.attrs, want) {
+		t.Errorf("Flushing stale operations left guest attributes %v, want %v", srv.attrs, want)
+	}
+
+	// The newer queued operation is still flushed.
+	if err := client.FlushGuestAttributes(ctx); err != nil {
+		t.Fatalf("FlushGuestAttributes(ctx) failed unexpectedly with error: %v", err)
+	}
+	if want := map[string]string{"guest-agent/settled": "new", "guest-agent/superseded": "new"}; !reflect.DeepEqual(srv.attrs, want) {
+		t.Errorf("FlushGuestAttributes(ctx) left guest attributes %v, want %v", srv.attrs, want)
+	}
+}
+
+func TestGuestAttributeQueueLimits(t *testing.T) {
+	q := NewGuestAttributeQueue(filepath.Join(t.TempDir(), "queue.json"))
+
+	for i := 0; i < guestAttributeQueueMaxLen+10; i++ {
+		op := guestAttributeOp{Key: fmt.Sprintf("key-%d", i), Value: "value", Timestamp: time.Now()}
+		if err := q.push(op); err != nil {
+			t.Fatalf("push(%s) failed unexpectedly with error: %v", op.Key, err)
+		}
+	}
+
+	ops, err := q.pending()
+	if err != nil {
+		t.Fatalf("pending() failed unexpectedly with error: %v", err)
+	}
+
+	if len(ops) != guestAttributeQueueMaxLen || ops[0].Key != "key-10" {
+		t.Errorf("pending() returned %d operations starting with %s, want %d starting with key-10", len(ops), ops[0].Key, guestAttributeQueueMaxLen)
+	}
+
+	// Expire all but the newest operation.
+	q.mu.Lock()
+	for i := range q.ops[:len(q.ops)-1] {
+		q.ops[i].Timestamp = time.Now().Add(-guestAttributeQueueMaxAge - time.Minute)
+	}
+	q.mu.Unlock()
+
+	ops, err = q.pending()
+	if err != nil {
+		t.Fatalf("pending() failed unexpectedly with error: %v", err)
+	}
+
+	if len(ops) != 1 {
+		t.Errorf("pending() returned %d operations, want the expired ones dropped", len(ops))
+	}
+}
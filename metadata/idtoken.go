@@ -0,0 +1,203 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// identityTokenLeeway is the clock skew tolerated when checking identity token times.
+const identityTokenLeeway = 30 * time.Second
+
+// identityTokenIssuers are the accepted issuers of identity tokens.
+var identityTokenIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// IdentityClaims are the claims of an identity token.
+type IdentityClaims struct {
+	Issuer  string `json:"iss"`
+	Subject string `json:"sub"`
+	// Audience are the token's audiences, the aud claim is either a single string
+	// or an array of them.
+	Audience        []string `json:"-"`
+	AuthorizedParty string   `json:"azp"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	// IssuedAt is when the token was issued.
+	IssuedAt time.Time `json:"-"`
+	// ExpiresAt is when the token expires.
+	ExpiresAt time.Time `json:"-"`
+	// Google holds the instance's details, only set for tokens requested with the
+	// "full" format.
+	Google *GoogleClaims `json:"google,omitempty"`
+}
+
+// GoogleClaims are the Google specific claims of an identity token.
+type GoogleClaims struct {
+	ComputeEngine ComputeEngineClaims `json:"compute_engine"`
+}
+
+// ComputeEngineClaims describes the instance an identity token was issued to.
+type ComputeEngineClaims struct {
+	InstanceCreationTimestamp int64  `json:"instance_creation_timestamp"`
+	InstanceID                string `json:"instance_id"`
+	InstanceName              string `json:"instance_name"`
+	ProjectID                 string `json:"project_id"`
+	ProjectNumber             int64  `json:"project_number"`
+	Zone                      string `json:"zone"`
+}
+
+// UnmarshalJSON unmarshals b into IdentityClaims.
+func (ic *IdentityClaims) UnmarshalJSON(b []byte) error {
+	// We can't unmarshal into claims directly as it would create an infinite loop.
+	type temp IdentityClaims
+	t := struct {
+		*temp
+		Audience  json.RawMessage `json:"aud"`
+		IssuedAt  int64           `json:"iat"`
+		ExpiresAt int64           `json:"exp"`
+	}{temp: (*temp)(ic)}
+
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+
+	ic.Audience = nil
+	if len(t.Audience) > 0 {
+		var audience string
+		if err := json.Unmarshal(t.Audience, &audience); err == nil {
+			ic.Audience = []string{audience}
+		} else if err := json.Unmarshal(t.Audience, &ic.Audience); err != nil {
+			return fmt.Errorf("failed to unmarshal aud claim, want a string or an array of strings: %w", err)
+		}
+	}
+
+	ic.IssuedAt = time.Unix(t.IssuedAt, 0)
+	ic.ExpiresAt = time.Unix(t.ExpiresAt, 0)
+	return nil
+}
+
+// jsonWebKey is a RSA public key of a JSON Web Key Set.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// publicKey decodes the jwk's RSA public key.
+func (jwk jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode modulus of key %q: %w", jwk.Kid, err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exponent of key %q: %w", jwk.Kid, err)
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// VerifyIdentityToken verifies an identity token against the JSON Web Key Set jwks,
+// i.e. the one published at https://www.googleapis.com/oauth2/v3/certs, and returns
+// its claims. The token must be signed with RS256 by one of the jwks keys, issued by
+// Google for audience and not expired.
+func VerifyIdentityToken(token string, jwks []byte, audience string) (*IdentityClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token: want 3 parts, got %d", len(parts))
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token header: %w", err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token header: %w", err)
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported token signing algorithm %q", header.Alg)
+	}
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(jwks, &keySet); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JWKS: %w", err)
+	}
+
+	var key *rsa.PublicKey
+	for _, jwk := range keySet.Keys {
+		if jwk.Kty == "RSA" && jwk.Kid == header.Kid {
+			if key, err = jwk.publicKey(); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no JWKS key found for token key id %q", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token signature: %w", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+
+	claims, err := unverifiedClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(identityTokenIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+
+	if !slices.Contains(claims.Audience, audience) {
+		return nil, fmt.Errorf("token audience %q doesn't match %q", claims.Audience, audience)
+	}
+
+	now := time.Now()
+	if now.After(claims.ExpiresAt.Add(identityTokenLeeway)) {
+		return nil, fmt.Errorf("token expired at %s", claims.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if now.Add(identityTokenLeeway).Before(claims.IssuedAt) {
+		return nil, fmt.Errorf("token issued in the future at %s", claims.IssuedAt.Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestToken returns a RS256 JWT of claims signed by key.
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("Failed to marshal token header: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to marshal token claims: %v", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testJWKS returns a JWKS document with key's public key.
+func testJWKS(t *testing.T, key *rsa.PrivateKey, kid string) []byte {
+	t.Helper()
+
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal JWKS: %v", err)
+	}
+	return jwks
+}
+
+func TestVerifyIdentityToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	jwks := testJWKS(t, key, "kid1")
+	now := time.Now()
+
+	tests := []struct {
+		desc         string
+		iss          string
+		aud          interface{}
+		wantAudience []string
+	}{
+		{desc: "string_audience", iss: "https://accounts.google.com", aud: "aud", wantAudience: []string{"aud"}},
+		{desc: "array_audience", iss: "https://accounts.google.com", aud: []string{"other", "aud"}, wantAudience: []string{"other", "aud"}},
+		{desc: "issuer_without_scheme", iss: "accounts.google.com", aud: "aud", wantAudience: []string{"aud"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			token := signTestToken(t, key, "kid1", map[string]interface{}{"iss": tc.iss, "sub": "123", "aud": tc.aud, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+			claims, err := VerifyIdentityToken(token, jwks, "aud")
+			if err != nil {
+				t.Fatalf("VerifyIdentityToken(%q) failed unexpectedly with error: %v", token, err)
+			}
+			if claims.Issuer != tc.iss || claims.Subject != "123" || !reflect.DeepEqual(claims.Audience, tc.wantAudience) {
+				t.Errorf("VerifyIdentityToken(%q) = %+v, want issuer %q, subject 123 and audience %v", token, claims, tc.iss, tc.wantAudience)
+			}
+			if !claims.ExpiresAt.Equal(time.Unix(now.Add(time.Hour).Unix(), 0)) {
+				t.Errorf("VerifyIdentityToken(%q) expiry = %s, want %s", token, claims.ExpiresAt, now.Add(time.Hour))
+			}
+		})
+	}
+}
+
+func TestVerifyIdentityTokenFailures(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	jwks := testJWKS(t, key, "kid1")
+
+	now := time.Now()
+	iss := "https://accounts.google.com"
+	valid := map[string]interface{}{"iss": iss, "aud": "aud", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+
+	tests := []struct {
+		desc  string
+		token string
+	}{
+		{desc: "malformed", token: "header.payload"},
+		{desc: "unknown_kid", token: signTestToken(t, key, "kid2", valid)},
+		{desc: "bad_signature", token: signTestToken(t, otherKey, "kid1", valid)},
+		{desc: "wrong_audience", token: signTestToken(t, key, "kid1", map[string]interface{}{"iss": iss, "aud": "other", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})},
+		{desc: "expired", token: signTestToken(t, key, "kid1", map[string]interface{}{"iss": iss, "aud": "aud", "iat": now.Add(-2 * time.Hour).Unix(), "exp": now.Add(-time.Hour).Unix()})},
+		{desc: "issued_in_future", token: signTestToken(t, key, "kid1", map[string]interface{}{"iss": iss, "aud": "aud", "iat": now.Add(time.Hour).Unix(), "exp": now.Add(2 * time.Hour).Unix()})},
+		{desc: "missing_issuer", token: signTestToken(t, key, "kid1", map[string]interface{}{"aud": "aud", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})},
+		{desc: "wrong_issuer", token: signTestToken(t, key, "kid1", map[string]interface{}{"iss": "https://example.com", "aud": "aud", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})},
+		{desc: "wrong_audiences", token: signTestToken(t, key, "kid1", map[string]interface{}{"iss": iss, "aud": []string{"other", "another"}, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})},
+		{desc: "invalid_audience", token: signTestToken(t, key, "kid1", map[string]interface{}{"iss": iss, "aud": 1, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})},
+		{desc: "tampered_payload", token: func() string {
+			parts := strings.Split(signTestToken(t, key, "kid1", valid), ".")
+			payload, _ := json.Marshal(map[string]interface{}{"iss": iss, "aud": "aud", "iat": now.Unix(), "exp": now.Add(48 * time.Hour).Unix()})
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}()},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := VerifyIdentityToken(tc.token, jwks, "aud"); err == nil {
+				t.Errorf("VerifyIdentityToken(%q) succeeded, want error", tc.token)
+			}
+		})
+	}
+
+	if _, err := VerifyIdentityToken(signTestToken(t, key, "kid1", valid), jwks, "aud"); err != nil {
+		t.Errorf("VerifyIdentityToken() failed unexpectedly with error: %v", err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/retry"
@@ -56,38 +57,93 @@ type MDSClientInterface interface {
 	GetKey(context.Context, string, map[string]string) (string, error)
 	GetKeyRecursive(context.Context, string) (string, error)
 	Watch(context.Context) (*Descriptor, error)
+	WatchKey(context.Context, string, string) (string, string, error)
 	WriteGuestAttributes(context.Context, string, string) error
+	GetGuestAttribute(context.Context, string) (string, error)
+	ListGuestAttributes(context.Context, string) (map[string]string, error)
+	DeleteGuestAttribute(context.Context, string) error
 }
 
 // requestConfig is used internally to configure an http request given its context.
 type requestConfig struct {
-	baseURL    string
+	// key is the requested path relative to the metadata endpoint.
+	key string
+	// method is the http method of the request, defaults to GET.
+	method string
+	// body is the request body, only used by PUT requests.
+	body string
+	// query are additional query parameters of the request.
+	query      url.Values
 	hang       bool
 	recursive  bool
 	jsonOutput bool
 	timeout    int
 	headers    map[string]string
+	// etag is the last known etag used as the last_etag parameter of hanging requests.
+	etag string
 }
 
 // Client defines the public interface between the core guest agent and
 // the metadata layer.
 type Client struct {
-	metadataURL string
-	etag        string
-	httpClient  *http.Client
+	// endpoints is the ordered list of metadata server endpoints requests are sent to.
+	endpoints  *endpoints
+	httpClient *http.Client
+
+	// etag is the etag of the last Watch() call's response, it's protected by etagMutex.
+	etag      string
+	etagMutex sync.Mutex
+
+	// mtls manages the MDS mTLS credentials, when valid credentials are available
+	// requests are sent to the HTTPS endpoint instead. It's nil if mTLS is not in use.
+	mtls *mtlsCredentials
+
+	// cache is the on-disk cache of the last successfully fetched descriptor, it's nil
+	// if caching was not enabled with SetCache().
+	cache *Cache
+
+	// instanceID is the instance ID of the last descriptor fetched from the metadata
+	// server, it's protected by instanceIDMutex.
+	instanceID      string
+	instanceIDMutex sync.Mutex
+
+	// gaQueue persists the guest attribute operations that failed to reach the metadata
+	// server, it's nil if queuing was not enabled with SetGuestAttributeQueue().
+	gaQueue *GuestAttributeQueue
+
+	// tokens caches the service account access and identity tokens.
+	tokens tokenCache
 }
 
 // New allocates and configures a new Client instance.
 func New() *Client {
 	return &Client{
-		metadataURL: defaultMetadataURL,
-		etag:        defaultEtag,
+		endpoints: defaultEndpoints,
+		etag:      defaultEtag,
 		httpClient: &http.Client{
 			Timeout: defaultClientTimeout * time.Second,
 		},
+		mtls: newMTLSCredentials(),
 	}
 }
 
+// transport returns the http client to be used for requesting u on ep. If ep supports
+// mTLS and valid credentials are available u is switched to the HTTPS endpoint,
+// otherwise the plain HTTP endpoint is used.
+func (c *Client) transport(ep *endpoint, u *url.URL) *http.Client {
+	if c.mtls == nil || !ep.mtls {
+		return c.httpClient
+	}
+
+	client := c.mtls.httpClient()
+	if client == nil {
+		return c.httpClient
+	}
+
+	u.Scheme = "https"
+	return client
+}
+
 // Descriptor wraps/holds all the metadata keys, the structure reflects the json
 // descriptor returned with metadata call with alt=jason.
 type Descriptor struct {
@@ -138,9 +194,32 @@ type Instance struct {
 	// ID is the instance ID.
 	ID json.Number
 
-	// MachineType represents the instance's machine type.
+	// MachineType represents the instance's machine type, in the
+	// projects/<project-number>/machineTypes/<machine-type> format.
 	MachineType string
 
+	// Name is the instance's name.
+	Name string
+
+	// Hostname is the instance's fully qualified hostname.
+	Hostname string
+
+	// Zone is the instance's zone, in the projects/<project-number>/zones/<zone> format.
+	Zone string
+
+	// Tags are the instance's network tags.
+	Tags []string
+
+	// Disks are the disks attached to the instance.
+	Disks []Disk
+
+	// Scheduling contains the instance's scheduling options.
+	Scheduling Scheduling
+
+	// ServiceAccounts maps the instance's service accounts by their email, the default
+	// service account is additionally available with the "default" key.
+	ServiceAccounts map[string]ServiceAccount
+
 	// Attributes are the instance's attributes.
 	Attributes Attributes
 
@@ -154,6 +233,103 @@ type Instance struct {
 	VirtualClock virtualClock
 }
 
+// MachineTypeName returns the short machine type name, i.e. e2-medium.
+func (i Instance) MachineTypeName() string {
+	return lastPathElement(i.MachineType)
+}
+
+// ZoneName returns the short zone name, i.e. us-central1-a.
+func (i Instance) ZoneName() string {
+	return lastPathElement(i.Zone)
+}
+
+// Region returns the region of the instance's zone, i.e. us-central1.
+func (i Instance) Region() string {
+	zone := i.ZoneName()
+	if idx := strings.LastIndex(zone, "-"); idx > 0 {
+		return zone[:idx]
+	}
+	return zone
+}
+
+// lastPathElement returns the last element of a slash separated resource path.
+func lastPathElement(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// Disk describes a disk attached to the instance.
+type Disk struct {
+	// DeviceName is the disk's device name, the disk is exposed to the guest as
+	// /dev/disk/by-id/google-<DeviceName>.
+	DeviceName string
+
+	// Index is the disk's attachment index.
+	Index int
+
+	// Interface is the disk's interface, i.e. SCSI or NVME.
+	Interface string
+
+	// Mode is the disk's access mode, i.e. READ_WRITE or READ_ONLY.
+	Mode string
+
+	// Type is the disk's type, i.e. PERSISTENT or LOCAL-SSD.
+	Type string
+}
+
+// Scheduling describes the instance's scheduling options.
+type Scheduling struct {
+	// AutomaticRestart is true if the instance is restarted when terminated by the system.
+	AutomaticRestart bool
+
+	// OnHostMaintenance is the instance's maintenance behavior, i.e. MIGRATE or TERMINATE.
+	OnHostMaintenance string
+
+	// Preemptible is true for preemptible and spot instances.
+	Preemptible bool
+
+	// ProvisioningModel is the instance's provisioning model, i.e. STANDARD or SPOT.
+	ProvisioningModel string
+}
+
+// UnmarshalJSON unmarshals b into Scheduling, the metadata server represents booleans
+// as TRUE/FALSE strings.
+func (s *Scheduling) UnmarshalJSON(b []byte) error {
+	var temp struct {
+		AutomaticRestart  string `json:"automaticRestart"`
+		OnHostMaintenance string `json:"onHostMaintenance"`
+		Preemptible       string `json:"preemptible"`
+		ProvisioningModel string `json:"provisioningModel"`
+	}
+	if err := json.Unmarshal(b, &temp); err != nil {
+		return err
+	}
+
+	s.OnHostMaintenance = temp.OnHostMaintenance
+	s.ProvisioningModel = temp.ProvisioningModel
+
+	value, err := strconv.ParseBool(temp.AutomaticRestart)
+	if err == nil {
+		s.AutomaticRestart = value
+	}
+	value, err = strconv.ParseBool(temp.Preemptible)
+	if err == nil {
+		s.Preemptible = value
+	}
+	return nil
+}
+
+// ServiceAccount describes a service account available to the instance.
+type ServiceAccount struct {
+	// Aliases are the service account's aliases, i.e. default.
+	Aliases []string
+
+	// Email is the service account's email.
+	Email string
+
+	// Scopes are the service account's OAuth2 scopes.
+	Scopes []string
+}
+
 // NetworkInterfaces describes the instances network interfaces configurations.
 type NetworkInterfaces struct {
 	ForwardedIps      []string
@@ -162,6 +338,18 @@ type NetworkInterfaces struct {
 	IPAliases         []string
 	Mac               string
 	DHCPv6Refresh     string
+	// IP is the interface's primary internal ip address.
+	IP string
+	// Network is the interface's network, in the projects/<project-number>/networks/<network> format.
+	Network string
+	// MTU is the interface's MTU.
+	MTU int
+	// Gateway is the interface's gateway address.
+	Gateway string
+	// Subnetmask is the interface's subnet mask.
+	Subnetmask string
+	// DNSServers are the interface's DNS server addresses.
+	DNSServers []string
 }
 
 // VlanInterface describes the instances vlan network interfaces configurations.
@@ -216,6 +404,30 @@ type Attributes struct {
 	WSFCAddresses         string
 	WSFCAgentPort         string
 	DisableTelemetry      bool
+
+	// Raw maps all the attribute keys to their unparsed values, including the ones not
+	// modeled above. See Descriptor's typed accessors i.e. GetBool(), GetString() etc.
+	Raw map[string]string
+}
+
+// attributesJSON are the literal JSON types of the attributes modeled by Attributes.
+type attributesJSON struct {
+	BlockProjectKeys      string      `json:"block-project-ssh-keys"`
+	Diagnostics           string      `json:"diagnostics"`
+	DisableAccountManager string      `json:"disable-account-manager"`
+	DisableAddressManager string      `json:"disable-address-manager"`
+	EnableDiagnostics     string      `json:"enable-diagnostics"`
+	EnableOSLogin         string      `json:"enable-oslogin"`
+	EnableWindowsSSH      string      `json:"enable-windows-ssh"`
+	EnableWSFC            string      `json:"enable-wsfc"`
+	OldSSHKeys            string      `json:"sshKeys"`
+	SSHKeys               string      `json:"ssh-keys"`
+	TwoFactor             string      `json:"enable-oslogin-2fa"`
+	SecurityKey           string      `json:"enable-oslogin-sk"`
+	WindowsKeys           WindowsKeys `json:"windows-keys"`
+	WSFCAddresses         string      `json:"wsfc-addrs"`
+	WSFCAgentPort         string      `json:"wsfc-agent-port"`
+	DisableTelemetry      string      `json:"disable-guest-telemetry"`
 }
 
 // UnmarshalJSON unmarshals b into Attribute.
@@ -226,28 +438,25 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 		return res
 	}
 	// Unmarshal to literal JSON types before doing anything else.
-	type inner struct {
-		BlockProjectKeys      string      `json:"block-project-ssh-keys"`
-		Diagnostics           string      `json:"diagnostics"`
-		DisableAccountManager string      `json:"disable-account-manager"`
-		DisableAddressManager string      `json:"disable-address-manager"`
-		EnableDiagnostics     string      `json:"enable-diagnostics"`
-		EnableOSLogin         string      `json:"enable-oslogin"`
-		EnableWindowsSSH      string      `json:"enable-windows-ssh"`
-		EnableWSFC            string      `json:"enable-wsfc"`
-		OldSSHKeys            string      `json:"sshKeys"`
-		SSHKeys               string      `json:"ssh-keys"`
-		TwoFactor             string      `json:"enable-oslogin-2fa"`
-		SecurityKey           string      `json:"enable-oslogin-sk"`
-		WindowsKeys           WindowsKeys `json:"windows-keys"`
-		WSFCAddresses         string      `json:"wsfc-addrs"`
-		WSFCAgentPort         string      `json:"wsfc-agent-port"`
-		DisableTelemetry      string      `json:"disable-guest-telemetry"`
-	}
-	var temp inner
+	var temp attributesJSON
 	if err := json.Unmarshal(b, &temp); err != nil {
 		return err
 	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	a.Raw = make(map[string]string, len(raw))
+	for k, v := range raw {
+		// Attribute values are strings, keep the json representation of anything else.
+		var str string
+		if err := json.Unmarshal(v, &str); err != nil {
+			str = string(v)
+		}
+		a.Raw[k] = str
+	}
 	a.Diagnostics = temp.Diagnostics
 	a.WSFCAddresses = temp.WSFCAddresses
 	a.WSFCAgentPort = temp.WSFCAgentPort
@@ -304,15 +513,29 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (c *Client) updateEtag(resp *http.Response) bool {
+func (c *Client) updateEtag(etag string) bool {
+	c.etagMutex.Lock()
+	defer c.etagMutex.Unlock()
+
 	oldEtag := c.etag
-	c.etag = resp.Header.Get("etag")
+	c.etag = etag
 	if c.etag == "" {
 		c.etag = defaultEtag
 	}
 	return c.etag != oldEtag
 }
 
+func (c *Client) currentEtag() string {
+	c.etagMutex.Lock()
+	defer c.etagMutex.Unlock()
+	return c.etag
+}
+
+// ETag returns the etag of the last Watch() call's response.
+func (c *Client) ETag() string {
+	return c.currentEtag()
+}
+
 // MDSReqError represents custom error produced by HTTP requests made on MDS. It captures
 // error and HTTP response for inspecting status code.
 type MDSReqError struct {
@@ -339,26 +562,38 @@ func shouldRetry(err error) bool {
 	return !slices.Contains(codes, e.status)
 }
 
+// mdsResponse wraps the body and the etag of a metadata server response.
+type mdsResponse struct {
+	body string
+	etag string
+}
+
 func (c *Client) retry(ctx context.Context, cfg requestConfig) (string, error) {
+	resp, err := c.retryResponse(ctx, cfg)
+	return resp.body, err
+}
+
+// retryResponse is like retry but also returns the response's etag.
+func (c *Client) retryResponse(ctx context.Context, cfg requestConfig) (mdsResponse, error) {
 	policy := retry.Policy{MaxAttempts: backoffAttempts, Jitter: backoffDuration, BackoffFactor: 1, ShouldRetry: shouldRetry}
 
-	fn := func() (string, error) {
+	fn := func() (mdsResponse, error) {
 		resp, err := c.do(ctx, cfg)
 		if err != nil {
 			statusCode := -1
 			if resp != nil {
 				statusCode = resp.StatusCode
 			}
-			return "", &MDSReqError{statusCode, err}
+			return mdsResponse{}, &MDSReqError{statusCode, err}
 		}
 		defer resp.Body.Close()
 
 		md, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return "", fmt.Errorf("failed to read metadata server response bytes: %+v", err)
+			return mdsResponse{}, fmt.Errorf("failed to read metadata server response bytes: %+v", err)
 		}
 
-		return string(md), nil
+		return mdsResponse{body: string(md), etag: resp.Header.Get("etag")}, nil
 	}
 
 	return retry.RunWithResponse(ctx, policy, fn)
@@ -366,13 +601,8 @@ func (c *Client) retry(ctx context.Context, cfg requestConfig) (string, error) {
 
 // GetKey gets a specific metadata key.
 func (c *Client) GetKey(ctx context.Context, key string, headers map[string]string) (string, error) {
-	reqURL, err := url.JoinPath(c.metadataURL, key)
-	if err != nil {
-		return "", fmt.Errorf("failed to form metadata url: %+v", err)
-	}
-
 	cfg := requestConfig{
-		baseURL: reqURL,
+		key:     key,
 		headers: headers,
 	}
 	return c.retry(ctx, cfg)
@@ -380,13 +610,8 @@ func (c *Client) GetKey(ctx context.Context, key string, headers map[string]stri
 
 // GetKeyRecursive gets a specific metadata key recursively and returns JSON output.
 func (c *Client) GetKeyRecursive(ctx context.Context, key string) (string, error) {
-	reqURL, err := url.JoinPath(c.metadataURL, key)
-	if err != nil {
-		return "", fmt.Errorf("failed to form metadata url: %+v", err)
-	}
-
 	cfg := requestConfig{
-		baseURL:    reqURL,
+		key:        key,
 		jsonOutput: true,
 		recursive:  true,
 	}
@@ -398,6 +623,38 @@ func (c *Client) Watch(ctx context.Context) (*Descriptor, error) {
 	return c.get(ctx, true)
 }
 
+// WatchKey runs a longpoll on a given metadata key and its subtree, returning its JSON
+// output and the response's etag. The call blocks until the key's etag differs from
+// lastEtag or the hang timeout expires, in which case the unchanged value and etag are
+// returned. An empty lastEtag returns the key's current value right away. Unlike Watch()
+// the etag is kept by the caller, so multiple keys can be watched concurrently.
+func (c *Client) WatchKey(ctx context.Context, key string, lastEtag string) (string, string, error) {
+	if lastEtag == "" {
+		lastEtag = defaultEtag
+	}
+
+	cfg := requestConfig{
+		key:        key,
+		hang:       true,
+		etag:       lastEtag,
+		timeout:    defaultHangTimeout,
+		recursive:  true,
+		jsonOutput: true,
+	}
+
+	resp, err := c.retryResponse(ctx, cfg)
+	if err != nil {
+		return "", "", err
+	}
+
+	etag := resp.etag
+	if etag == "" {
+		etag = defaultEtag
+	}
+
+	return resp.body, etag, nil
+}
+
 // Get does a metadata call, if hang is set to true then it will do a longpoll.
 func (c *Client) Get(ctx context.Context) (*Descriptor, error) {
 	return c.get(ctx, false)
@@ -405,7 +662,6 @@ func (c *Client) Get(ctx context.Context) (*Descriptor, error) {
 
 func (c *Client) get(ctx context.Context, hang bool) (*Descriptor, error) {
 	cfg := requestConfig{
-		baseURL:    c.metadataURL,
 		timeout:    defaultHangTimeout,
 		recursive:  true,
 		jsonOutput: true,
@@ -413,55 +669,98 @@ func (c *Client) get(ctx context.Context, hang bool) (*Descriptor, error) {
 
 	if hang {
 		cfg.hang = true
+		cfg.etag = c.currentEtag()
 	}
 
-	resp, err := c.retry(ctx, cfg)
+	resp, err := c.retryResponse(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	if hang {
+		c.updateEtag(resp.etag)
+	}
+
 	var ret Descriptor
-	if err = json.Unmarshal([]byte(resp), &ret); err != nil {
+	if err = json.Unmarshal([]byte(resp.body), &ret); err != nil {
 		return nil, err
 	}
 
+	instanceID := ret.Instance.ID.String()
+	c.instanceIDMutex.Lock()
+	c.instanceID = instanceID
+	c.instanceIDMutex.Unlock()
+
+	if c.cache != nil {
+		if err := c.cache.write([]byte(resp.body), resp.etag, instanceID); err != nil {
+			logger.Warningf("Failed to update metadata cache: %+v", err)
+		}
+	}
+
 	return &ret, nil
 }
 
-// WriteGuestAttributes does a put call to mds changing a guest attribute value.
-func (c *Client) WriteGuestAttributes(ctx context.Context, key, value string) error {
-	logger.Debugf("write guest attribute %q", key)
-
-	finalURL, err := url.JoinPath(c.metadataURL, "instance/guest-attributes/", key)
-	if err != nil {
-		return fmt.Errorf("failed to form metadata url: %+v", err)
+// GetWithFallback does a metadata call like Get, if the metadata server can't be reached
+// the last known good descriptor is read from the client's cache instead (see SetCache).
+// The returned bool is false if the descriptor was read from the cache and couldn't be
+// verified to belong to this instance, such a descriptor must not be trusted for
+// security relevant settings, i.e. user accounts and SSH keys. The cache is verified
+// against the instance ID of the last descriptor this client fetched, it's ignored if
+// it belongs to another instance.
+func (c *Client) GetWithFallback(ctx context.Context) (*Descriptor, bool, error) {
+	desc, err := c.Get(ctx)
+	if err == nil || c.cache == nil {
+		return desc, err == nil, err
 	}
 
-	logger.Debugf("Requesting(PUT) MDS URL: %s", finalURL)
+	c.instanceIDMutex.Lock()
+	instanceID := c.instanceID
+	c.instanceIDMutex.Unlock()
 
-	req, err := http.NewRequest("PUT", finalURL, strings.NewReader(value))
-	if err != nil {
-		return err
+	cached, cacheErr := c.cache.Read(instanceID)
+	if cacheErr != nil {
+		return nil, false, fmt.Errorf("%w, and failed to read metadata cache: %v", err, cacheErr)
 	}
 
-	req.Header.Add("Metadata-Flavor", "Google")
-	req = req.WithContext(ctx)
+	logger.Warningf("Failed to reach metadata server (%v), using cached metadata fetched %s ago (at %s, etag %s, verified: %t)",
+		err, cached.Age().Round(time.Second), cached.Timestamp.Format(time.RFC3339), cached.Etag, cached.Verified)
+	return cached.Descriptor, cached.Verified, nil
+}
 
-	_, err = c.httpClient.Do(req)
-	return err
+// SetCache enables persisting every successfully fetched descriptor to cache, which can
+// later be used as a fallback when the metadata server is unreachable.
+func (c *Client) SetCache(cache *Cache) {
+	c.cache = cache
 }
 
 func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, error) {
-	finalURL, err := url.Parse(cfg.baseURL)
+	// Endpoints are picked on every attempt so retries fall back to the next
+	// endpoint once the current one is considered failing.
+	ep := c.endpoints.pick()
+
+	// JoinPath drops the endpoint's trailing slash when key is empty, the root of
+	// the metadata tree must be requested with it.
+	reqURL := ep.url
+	if cfg.key != "" {
+		var err error
+		if reqURL, err = url.JoinPath(ep.url, cfg.key); err != nil {
+			return nil, fmt.Errorf("failed to form metadata url: %+v", err)
+		}
+	}
+
+	finalURL, err := url.Parse(reqURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse url: %+v", err)
 	}
 
 	values := finalURL.Query()
+	for k, v := range cfg.query {
+		values[k] = append(values[k], v...)
+	}
 
 	if cfg.hang {
 		values.Add("wait_for_change", "true")
-		values.Add("last_etag", c.etag)
+		values.Add("last_etag", cfg.etag)
 	}
 
 	if cfg.timeout > 0 {
@@ -477,9 +776,15 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 	}
 
 	finalURL.RawQuery = values.Encode()
-	logger.Debugf("Requesting(GET) MDS URL: %s", finalURL.String())
+	httpClient := c.transport(ep, finalURL)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", finalURL.String(), nil)
+	method := cfg.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	logger.Debugf("Requesting(%s) MDS URL: %s", method, finalURL.String())
+
+	req, err := http.NewRequestWithContext(ctx, method, finalURL.String(), strings.NewReader(cfg.body))
 	if err != nil {
 		return nil, err
 	}
@@ -488,7 +793,7 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 	for k, v := range cfg.headers {
 		req.Header.Add(k, v)
 	}
-	resp, err := c.httpClient.Do(req)
+	resp, err := httpClient.Do(req)
 
 	// If we are canceling httpClient will also wrap the context's error so
 	// check first the context.
@@ -496,6 +801,7 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 		return resp, ctx.Err()
 	}
 
+	c.endpoints.report(ep, err)
 	if err != nil {
 		return resp, fmt.Errorf("error connecting to metadata server: %+v", err)
 	}
@@ -506,8 +812,9 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 		return resp, fmt.Errorf(statusCodeMsg, resp.StatusCode)
 	}
 
-	if cfg.hang {
-		c.updateEtag(resp)
+	// Writes have no other use of the response, make sure they were accepted.
+	if method != http.MethodGet && resp.StatusCode >= 300 {
+		return resp, fmt.Errorf(statusCodeMsg, resp.StatusCode)
 	}
 
 	return resp, nil
@@ -20,9 +20,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -43,7 +43,7 @@ func TestWatchMetadata(t *testing.T) {
 	defer ts.Close()
 
 	client := &Client{
-		metadataURL: ts.URL,
+		endpoints: newEndpoints(ts.URL),
 		httpClient: &http.Client{
 			Timeout: 1 * time.Second,
 		},
@@ -60,6 +60,12 @@ func TestWatchMetadata(t *testing.T) {
 		},
 		SSHKeys:          []string{"name:ssh-rsa [KEY] hostname", "name:ssh-rsa [KEY] hostname"},
 		DisableTelemetry: false,
+		Raw: map[string]string{
+			"enable-oslogin": "true",
+			"ssh-keys":       "name:ssh-rsa [KEY] hostname\nname:ssh-rsa [KEY] hostname",
+			"windows-keys":   fmt.Sprintf(`{}`+"\n"+`{"expireOn":"%[1]s","exponent":"exponent","modulus":"modulus","username":"username"}`+"\n"+`{"expireOn":"%[1]s","exponent":"exponent","modulus":"modulus","username":"username","addToAdministrators":true}`, et),
+			"wsfc-addrs":     "foo",
+		},
 	}
 	for _, e := range []string{etag1, etag2} {
 		got, err := client.Watch(context.Background())
@@ -78,6 +84,125 @@ func TestWatchMetadata(t *testing.T) {
 	}
 }
 
+func TestWatchKey(t *testing.T) {
+	var mu sync.Mutex
+	gotEtags := make(map[string][]string)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("wait_for_change") != "true" || query.Get("timeout_sec") == "" {
+			t.Errorf("WatchKey() request %q is not a longpoll request", r.RequestURI)
+		}
+
+		mu.Lock()
+		gotEtags[r.URL.Path] = append(gotEtags[r.URL.Path], query.Get("last_etag"))
+		mu.Unlock()
+
+		w.Header().Set("etag", "etag"+strings.ReplaceAll(r.URL.Path, "/", "-"))
+		fmt.Fprintf(w, `{"key":%q}`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		endpoints: newEndpoints(ts.URL),
+		etag:      defaultEtag,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			wantEtag := "etag-" + key
+			wantValue := fmt.Sprintf(`{"key":"/%s"}`, key)
+			lastEtag := ""
+
+			for j := 0; j < 2; j++ {
+				value, etag, err := client.WatchKey(context.Background(), key, lastEtag)
+				if err != nil {
+					t.Errorf("WatchKey(ctx, %s, %s) failed unexpectedly with error: %v", key, lastEtag, err)
+					return
+				}
+				if value != wantValue || etag != wantEtag {
+					t.Errorf("WatchKey(ctx, %s, %s) = (%s, %s), want (%s, %s)", key, lastEtag, value, etag, wantValue, wantEtag)
+				}
+				lastEtag = etag
+			}
+		}(fmt.Sprintf("key%d", i))
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("/key%d", i)
+		want := []string{defaultEtag, "etag" + strings.ReplaceAll(path, "/", "-")}
+		if !reflect.DeepEqual(gotEtags[path], want) {
+			t.Errorf("WatchKey() sent last_etag %v for %s, want %v", gotEtags[path], path, want)
+		}
+	}
+
+	if client.etag != defaultEtag {
+		t.Errorf("WatchKey() changed the client's shared etag to %q, want %q", client.etag, defaultEtag)
+	}
+}
+
+func TestInstanceDescriptor(t *testing.T) {
+	data := `{"instance":{
+		"id":123,
+		"name":"test-instance",
+		"hostname":"test-instance.c.test-project.internal",
+		"machineType":"projects/123/machineTypes/e2-medium",
+		"zone":"projects/123/zones/us-central1-a",
+		"tags":["http-server","ssh"],
+		"disks":[{"deviceName":"persistent-disk-0","index":0,"interface":"SCSI","mode":"READ_WRITE","type":"PERSISTENT"},
+			{"deviceName":"local-ssd-0","index":1,"interface":"NVME","mode":"READ_WRITE","type":"LOCAL-SSD"}],
+		"scheduling":{"automaticRestart":"FALSE","onHostMaintenance":"TERMINATE","preemptible":"TRUE","provisioningModel":"SPOT"},
+		"serviceAccounts":{"default":{"aliases":["default"],"email":"sa@test-project.iam.gserviceaccount.com","scopes":["https://www.googleapis.com/auth/cloud-platform"]}},
+		"networkInterfaces":[{"ip":"10.128.0.2","mac":"42:01:0a:80:00:02","mtu":1460,"gateway":"10.128.0.1","subnetmask":"255.255.240.0","dnsServers":["169.254.169.254"],"network":"projects/123/networks/default"}]
+	}}`
+
+	var desc Descriptor
+	if err := json.Unmarshal([]byte(data), &desc); err != nil {
+		t.Fatalf("json.Unmarshal() failed unexpectedly with error: %v", err)
+	}
+
+	want := Instance{
+		ID:          json.Number("123"),
+		Name:        "test-instance",
+		Hostname:    "test-instance.c.test-project.internal",
+		MachineType: "projects/123/machineTypes/e2-medium",
+		Zone:        "projects/123/zones/us-central1-a",
+		Tags:        []string{"http-server", "ssh"},
+		Disks: []Disk{
+			{DeviceName: "persistent-disk-0", Index: 0, Interface: "SCSI", Mode: "READ_WRITE", Type: "PERSISTENT"},
+			{DeviceName: "local-ssd-0", Index: 1, Interface: "NVME", Mode: "READ_WRITE", Type: "LOCAL-SSD"},
+		},
+		Scheduling: Scheduling{AutomaticRestart: false, OnHostMaintenance: "TERMINATE", Preemptible: true, ProvisioningModel: "SPOT"},
+		ServiceAccounts: map[string]ServiceAccount{
+			"default": {Aliases: []string{"default"}, Email: "sa@test-project.iam.gserviceaccount.com", Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}},
+		},
+		NetworkInterfaces: []NetworkInterfaces{
+			{IP: "10.128.0.2", Mac: "42:01:0a:80:00:02", MTU: 1460, Gateway: "10.128.0.1", Subnetmask: "255.255.240.0", DNSServers: []string{"169.254.169.254"}, Network: "projects/123/networks/default"},
+		},
+	}
+	if !reflect.DeepEqual(desc.Instance, want) {
+		t.Errorf("json.Unmarshal() = %+v, want %+v", desc.Instance, want)
+	}
+
+	if got := desc.Instance.MachineTypeName(); got != "e2-medium" {
+		t.Errorf("MachineTypeName() = %q, want %q", got, "e2-medium")
+	}
+	if got := desc.Instance.ZoneName(); got != "us-central1-a" {
+		t.Errorf("ZoneName() = %q, want %q", got, "us-central1-a")
+	}
+	if got := desc.Instance.Region(); got != "us-central1" {
+		t.Errorf("Region() = %q, want %q", got, "us-central1")
+	}
+}
+
 func TestBlockProjectKeys(t *testing.T) {
 	tests := []struct {
 		json string
@@ -120,7 +245,7 @@ func TestGetKey(t *testing.T) {
 	defer testsrv.Close()
 
 	client := New()
-	client.metadataURL = testsrv.URL
+	client.endpoints = newEndpoints(testsrv.URL)
 
 	key := "key"
 	wantURI := "/" + key
@@ -157,7 +282,7 @@ func TestGetKeyRecursive(t *testing.T) {
 	defer testsrv.Close()
 
 	client := New()
-	client.metadataURL = testsrv.URL
+	client.endpoints = newEndpoints(testsrv.URL)
 
 	key := "key"
 	wantURI := fmt.Sprintf("/%s?alt=json&recursive=true", key)
@@ -228,17 +353,13 @@ func TestRetry(t *testing.T) {
 	defer ts.Close()
 
 	client := &Client{
-		metadataURL: ts.URL,
+		endpoints: newEndpoints(ts.URL),
 		httpClient: &http.Client{
 			Timeout: 1 * time.Second,
 		},
 	}
 
-	reqURL, err := url.JoinPath(ts.URL, "key")
-	if err != nil {
-		t.Fatalf("Failed to setup mock URL: %v", err)
-	}
-	req := requestConfig{baseURL: reqURL}
+	req := requestConfig{key: "key"}
 
 	got, err := client.retry(context.Background(), req)
 	if err != nil {
@@ -270,7 +391,7 @@ func TestRetryError(t *testing.T) {
 	defer ts.Close()
 
 	client := &Client{
-		metadataURL: ts.URL,
+		endpoints: newEndpoints(ts.URL),
 		httpClient: &http.Client{
 			Timeout: 1 * time.Second,
 		},
@@ -295,13 +416,9 @@ func TestRetryError(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			reqURL, err := url.JoinPath(ts.URL, test.mdsKey)
-			if err != nil {
-				t.Fatalf("Failed to setup mock URL: %v", err)
-			}
-			req := requestConfig{baseURL: reqURL}
+			req := requestConfig{key: test.mdsKey}
 
-			_, err = client.retry(ctx, req)
+			_, err := client.retry(ctx, req)
 			if err == nil {
 				t.Errorf("retry(ctx, %+v) succeeded, want error", req)
 			}
@@ -311,3 +428,42 @@ func TestRetryError(t *testing.T) {
 		})
 	}
 }
+
+func TestRootRequestPath(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("etag", "foo")
+		fmt.Fprint(w, `{"instance":{"id":123}}`)
+	}))
+	defer ts.Close()
+
+	endpoint, err := normalizeEndpoint(ts.URL)
+	if err != nil {
+		t.Fatalf("normalizeEndpoint(%s) failed unexpectedly with error: %v", ts.URL, err)
+	}
+
+	client := &Client{
+		endpoints:  newEndpoints(endpoint),
+		httpClient: &http.Client{Timeout: time.Second},
+	}
+
+	if _, err := client.Get(context.Background()); err != nil {
+		t.Fatalf("Get(ctx) failed unexpectedly with error: %v", err)
+	}
+
+	if _, err := client.Watch(context.Background()); err != nil {
+		t.Fatalf("Watch(ctx) failed unexpectedly with error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{metadataPath, metadataPath}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Get(ctx) and Watch(ctx) requested paths %v, want %v", paths, want)
+	}
+}
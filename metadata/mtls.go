@@ -0,0 +1,169 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// mtlsCredentials tracks the MDS mTLS credentials bootstrapped by the guest agent
+// and manages the HTTPS client configured with them. Credentials are reloaded
+// whenever the files are rotated on disk.
+type mtlsCredentials struct {
+	// rootCACertPath is the path of the MDS root CA certificate.
+	rootCACertPath string
+
+	// clientCredsPath is the path of the client credentials file, it contains
+	// the client certificate and its private key concatenated.
+	clientCredsPath string
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// rootCAModTime and clientCredsModTime are the modification times of the
+	// credential files when they were last loaded.
+	rootCAModTime      time.Time
+	clientCredsModTime time.Time
+
+	// notAfter is the expiration time of the loaded client certificate.
+	notAfter time.Time
+
+	// client is the HTTPS client, it's nil if no valid credentials are available.
+	client *http.Client
+}
+
+// newMTLSCredentials allocates a mtlsCredentials for the default credential paths.
+func newMTLSCredentials() *mtlsCredentials {
+	return &mtlsCredentials{
+		rootCACertPath:  defaultRootCACertPath,
+		clientCredsPath: defaultClientCredsPath,
+	}
+}
+
+// httpClient returns the HTTPS client to be used with the mTLS endpoint, or nil
+// if no valid credentials are available and the HTTP endpoint should be used instead.
+func (m *mtlsCredentials) httpClient() *http.Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rootInfo, rootErr := os.Stat(m.rootCACertPath)
+	credsInfo, credsErr := os.Stat(m.clientCredsPath)
+	if rootErr != nil || credsErr != nil {
+		if m.client != nil {
+			logger.Infof("MDS mTLS credentials are no longer available, falling back to HTTP endpoint")
+		}
+		m.reset()
+		return nil
+	}
+
+	// Credentials were not rotated, keep using the current client while the
+	// certificate is still valid.
+	if rootInfo.ModTime().Equal(m.rootCAModTime) && credsInfo.ModTime().Equal(m.clientCredsModTime) {
+		if m.client != nil && time.Now().After(m.notAfter) {
+			logger.Warningf("MDS mTLS client certificate expired at %s, falling back to HTTP endpoint", m.notAfter)
+			m.setClient(nil)
+		}
+		return m.client
+	}
+
+	m.rootCAModTime = rootInfo.ModTime()
+	m.clientCredsModTime = credsInfo.ModTime()
+
+	tlsConfig, notAfter, err := loadMTLSConfig(m.rootCACertPath, m.clientCredsPath)
+	if err != nil {
+		logger.Warningf("Failed to load MDS mTLS credentials, using HTTP endpoint: %v", err)
+		m.setClient(nil)
+		return nil
+	}
+
+	logger.Infof("Loaded MDS mTLS credentials (valid until %s), using HTTPS endpoint", notAfter)
+	m.notAfter = notAfter
+	m.setClient(&http.Client{
+		Timeout: defaultClientTimeout * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	})
+
+	return m.client
+}
+
+// setClient replaces the current client releasing the idle connections of the
+// previous one.
+func (m *mtlsCredentials) setClient(client *http.Client) {
+	if m.client != nil {
+		m.client.CloseIdleConnections()
+	}
+	m.client = client
+}
+
+// reset forgets the previously loaded credentials.
+func (m *mtlsCredentials) reset() {
+	m.setClient(nil)
+	m.rootCAModTime = time.Time{}
+	m.clientCredsModTime = time.Time{}
+	m.notAfter = time.Time{}
+}
+
+// loadMTLSConfig reads and validates the root CA certificate and client credentials,
+// it returns the resulting tls config and the client certificate's expiration time.
+func loadMTLSConfig(rootCACertPath, clientCredsPath string) (*tls.Config, time.Time, error) {
+	rootPEM, err := os.ReadFile(rootCACertPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read root CA cert %q: %w", rootCACertPath, err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootPEM) {
+		return nil, time.Time{}, fmt.Errorf("no valid certificate found in %q", rootCACertPath)
+	}
+
+	// Client credentials file has both the certificate and the private key.
+	cert, err := tls.LoadX509KeyPair(clientCredsPath, clientCredsPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to load client credentials %q: %w", clientCredsPath, err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse client certificate %q: %w", clientCredsPath, err)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if _, err := leaf.Verify(opts); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to verify client certificate against root CA %q: %w", rootCACertPath, err)
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return tlsConfig, leaf.NotAfter, nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() failed unexpectedly with error: %v", err)
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() failed unexpectedly with error: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() failed unexpectedly with error: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() failed unexpectedly with error: %v", err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newTestPKI creates a root CA and a server and client certificates signed by it.
+func newTestPKI(t *testing.T, notAfter time.Time) (*testCert, *testCert, *testCert) {
+	t.Helper()
+
+	root := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "google.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	server := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "metadata"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root)
+
+	client := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "instance"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root)
+
+	return root, server, client
+}
+
+func writeTestCreds(t *testing.T, creds *mtlsCredentials, root, client *testCert, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(creds.rootCACertPath, root.certPEM, 0644); err != nil {
+		t.Fatalf("Failed to write root CA cert: %v", err)
+	}
+
+	if err := os.WriteFile(creds.clientCredsPath, append(client.certPEM, client.keyPEM...), 0644); err != nil {
+		t.Fatalf("Failed to write client credentials: %v", err)
+	}
+
+	for _, f := range []string{creds.rootCACertPath, creds.clientCredsPath} {
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time of %q: %v", f, err)
+		}
+	}
+}
+
+func TestMTLSEndpoint(t *testing.T) {
+	root, server, client := newTestPKI(t, time.Now().Add(time.Hour))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.cert.Raw}, PrivateKey: server.key}},
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	creds := &mtlsCredentials{
+		rootCACertPath:  filepath.Join(dir, "root.crt"),
+		clientCredsPath: filepath.Join(dir, "client.key"),
+	}
+
+	c := New()
+	c.endpoints = newEndpoints("http://" + ts.Listener.Addr().String())
+	ep := c.endpoints.pick()
+	ep.mtls = true
+	c.mtls = creds
+
+	// No credentials available, should use the HTTP endpoint.
+	u, _ := url.Parse(ep.url)
+	if got := c.transport(ep, u); got != c.httpClient || u.Scheme != "http" {
+		t.Errorf("transport(%s) = (%p, %s), want (%p, http)", ep.url, got, u.Scheme, c.httpClient)
+	}
+
+	writeTestCreds(t, creds, root, client, time.Now().Add(-time.Minute))
+
+	got, err := c.GetKey(context.Background(), "key", nil)
+	if err != nil {
+		t.Fatalf("GetKey(ctx, key) failed unexpectedly with error: %v", err)
+	}
+	if got != "instance" {
+		t.Errorf("GetKey(ctx, key) = %q, want %q", got, "instance")
+	}
+
+	first := creds.httpClient()
+	if first == nil {
+		t.Fatalf("httpClient() = nil, want non-nil client after credentials are loaded")
+	}
+
+	// Rotate the credentials, a new client should be configured.
+	rotated := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "rotated"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root)
+	writeTestCreds(t, creds, root, rotated, time.Now())
+
+	got, err = c.GetKey(context.Background(), "key", nil)
+	if err != nil {
+		t.Fatalf("GetKey(ctx, key) failed unexpectedly with error: %v", err)
+	}
+	if got != "rotated" {
+		t.Errorf("GetKey(ctx, key) = %q, want %q after credential rotation", got, "rotated")
+	}
+
+	if creds.httpClient() == first {
+		t.Errorf("httpClient() returned the same client after credential rotation")
+	}
+
+	// Credentials removed, should fall back to HTTP.
+	if err := os.Remove(creds.clientCredsPath); err != nil {
+		t.Fatalf("Failed to remove client credentials: %v", err)
+	}
+	if creds.httpClient() != nil {
+		t.Errorf("httpClient() = non-nil, want nil after credentials were removed")
+	}
+}
+
+func TestMTLSInvalidCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		desc     string
+		notAfter time.Time
+		otherCA  bool
+	}{
+		{
+			desc:     "expired_client_cert",
+			notAfter: time.Now().Add(-time.Minute),
+		},
+		{
+			desc:     "unknown_issuer",
+			notAfter: time.Now().Add(time.Hour),
+			otherCA:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			creds := &mtlsCredentials{
+				rootCACertPath:  filepath.Join(dir, test.desc+"-root.crt"),
+				clientCredsPath: filepath.Join(dir, test.desc+"-client.key"),
+			}
+
+			root, _, client := newTestPKI(t, test.notAfter)
+			if test.otherCA {
+				root, _, _ = newTestPKI(t, test.notAfter)
+			}
+			writeTestCreds(t, creds, root, client, time.Now())
+
+			if creds.httpClient() != nil {
+				t.Errorf("httpClient() = non-nil, want nil for invalid credentials")
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package metadata
+
+const (
+	// defaultRootCACertPath is the MDS root CA certificate written by the guest agent.
+	defaultRootCACertPath = "/run/google-mds-mtls/root.crt"
+	// defaultClientCredsPath is the MDS client credentials written by the guest agent.
+	defaultClientCredsPath = "/run/google-mds-mtls/client.key"
+)
@@ -0,0 +1,212 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// serviceAccountKey is the metadata key of the default service account.
+	serviceAccountKey = "instance/service-accounts/default/"
+
+	// tokenExpiryDelta is how long before their expiry cached tokens are refreshed.
+	tokenExpiryDelta = time.Minute
+
+	// tokenFetchTimeout bounds a token fetch shared by concurrent callers.
+	tokenFetchTimeout = time.Minute
+)
+
+// AccessToken is an OAuth2 access token of the instance's default service account.
+type AccessToken struct {
+	// Token is the access token.
+	Token string
+	// TokenType is the token type, usually Bearer.
+	TokenType string
+	// Expiry is when the token expires.
+	Expiry time.Time
+}
+
+// tokenCache caches tokens in memory until shortly before their expiry and coalesces
+// concurrent fetches of the same token. The zero value is ready to use.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]*tokenCall
+}
+
+// tokenCall is a cached or in-flight token fetch.
+type tokenCall struct {
+	// done is closed once the fetch finishes, value, expiry and err are only valid
+	// after that.
+	done   chan struct{}
+	value  interface{}
+	expiry time.Time
+	err    error
+}
+
+// get returns the cached token of key, fetching it with fetch if not cached or about
+// to expire. Concurrent callers of the same key share a single fetch, it's not tied
+// to the cancellation of the caller starting it but bounded by tokenFetchTimeout.
+// Each caller stops waiting for it once its own ctx is done.
+func (tc *tokenCache) get(ctx context.Context, key string, fetch func(context.Context) (interface{}, time.Time, error)) (interface{}, error) {
+	tc.mu.Lock()
+	if tc.entries == nil {
+		tc.entries = make(map[string]*tokenCall)
+	}
+
+	call, found := tc.entries[key]
+	if found {
+		select {
+		case <-call.done:
+			// Expired, fetch it again.
+			if time.Until(call.expiry) <= tokenExpiryDelta {
+				found = false
+			}
+		default:
+			// In-flight, wait for it below.
+		}
+	}
+
+	if !found {
+		call = &tokenCall{done: make(chan struct{})}
+		tc.entries[key] = call
+
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenFetchTimeout)
+		go func() {
+			defer cancel()
+			call.value, call.expiry, call.err = fetch(fetchCtx)
+			if call.err != nil {
+				tc.mu.Lock()
+				// Don't cache failures, the next caller tries again.
+				if tc.entries[key] == call {
+					delete(tc.entries, key)
+				}
+				tc.mu.Unlock()
+			}
+			close(call.done)
+		}()
+	}
+	tc.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.value, call.err
+	}
+}
+
+// GetAccessToken returns an access token of the default service account for scopes,
+// if scopes is empty the token has the service account's default scopes. Tokens are
+// cached until shortly before they expire.
+func (c *Client) GetAccessToken(ctx context.Context, scopes []string) (*AccessToken, error) {
+	scopes = append([]string{}, scopes...)
+	sort.Strings(scopes)
+	scope := strings.Join(scopes, ",")
+
+	token, err := c.tokens.get(ctx, "access:"+scope, func(ctx context.Context) (interface{}, time.Time, error) {
+		cfg := requestConfig{key: serviceAccountKey + "token"}
+		if scope != "" {
+			cfg.query = url.Values{"scopes": {scope}}
+		}
+
+		resp, err := c.retry(ctx, cfg)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to get access token: %w", err)
+		}
+
+		var res struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+			TokenType   string `json:"token_type"`
+		}
+		if err := json.Unmarshal([]byte(resp), &res); err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to unmarshal access token: %w", err)
+		}
+
+		token := &AccessToken{
+			Token:     res.AccessToken,
+			TokenType: res.TokenType,
+			Expiry:    time.Now().Add(time.Duration(res.ExpiresIn) * time.Second),
+		}
+		return token, token.Expiry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token.(*AccessToken), nil
+}
+
+// GetIdentityToken returns an identity token (a signed JWT) of the default service
+// account for audience. format is either "standard" or "full", the latter includes
+// the instance's details in the token, empty defaults to the metadata server's default.
+// Tokens are cached until shortly before they expire.
+func (c *Client) GetIdentityToken(ctx context.Context, audience, format string) (string, error) {
+	if audience == "" {
+		return "", fmt.Errorf("audience is required")
+	}
+
+	token, err := c.tokens.get(ctx, "identity:"+format+":"+audience, func(ctx context.Context) (interface{}, time.Time, error) {
+		cfg := requestConfig{
+			key:   serviceAccountKey + "identity",
+			query: url.Values{"audience": {audience}},
+		}
+		if format != "" {
+			cfg.query.Set("format", format)
+		}
+
+		token, err := c.retry(ctx, cfg)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to get identity token: %w", err)
+		}
+
+		claims, err := unverifiedClaims(token)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return token, claims.ExpiresAt, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.(string), nil
+}
+
+// unverifiedClaims decodes the claims of the JWT token without verifying it.
+func unverifiedClaims(token string) (*IdentityClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token: want 3 parts, got %d", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	var claims IdentityClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token claims: %w", err)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetAccessToken(t *testing.T) {
+	var hits atomic.Int32
+	expiresIn := 3600
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		if r.URL.Path != "/"+serviceAccountKey+"token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Give concurrent callers time to pile up.
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(w, `{"access_token":"token-%d-%s","expires_in":%d,"token_type":"Bearer"}`, n, r.URL.Query().Get("scopes"), expiresIn)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 1 * time.Second},
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := client.GetAccessToken(ctx, []string{"scope-b", "scope-a"})
+			if err != nil {
+				t.Errorf("GetAccessToken(ctx, [scope-b scope-a]) failed unexpectedly with error: %v", err)
+				return
+			}
+			if token.Token != "token-1-scope-a,scope-b" || token.TokenType != "Bearer" {
+				t.Errorf("GetAccessToken(ctx, [scope-b scope-a]) = %+v, want token-1-scope-a,scope-b Bearer token", token)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Concurrent GetAccessToken() calls made %d requests, want 1", got)
+	}
+
+	// Cached, same scopes in a different order.
+	if token, err := client.GetAccessToken(ctx, []string{"scope-a", "scope-b"}); err != nil || token.Token != "token-1-scope-a,scope-b" {
+		t.Errorf("GetAccessToken(ctx, [scope-a scope-b]) = (%+v, %v), want cached token", token, err)
+	}
+
+	// Different scopes aren't served from the cache.
+	if token, err := client.GetAccessToken(ctx, nil); err != nil || token.Token != "token-2-" {
+		t.Errorf("GetAccessToken(ctx, nil) = (%+v, %v), want token-2-", token, err)
+	}
+
+	// Tokens about to expire are refreshed.
+	expiresIn = 30
+	client.tokens = tokenCache{}
+	for i := 3; i < 5; i++ {
+		want := fmt.Sprintf("token-%d-", i)
+		if token, err := client.GetAccessToken(ctx, nil); err != nil || token.Token != want {
+			t.Errorf("GetAccessToken(ctx, nil) = (%+v, %v), want %s", token, err, want)
+		}
+	}
+}
+
+func TestIdentityToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	jwks := testJWKS(t, key, "kid1")
+
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		q := r.URL.Query()
+		claims := map[string]interface{}{
+			"iss": "https://accounts.google.com",
+			"aud": q.Get("audience"),
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if q.Get("format") == "full" {
+			claims["google"] = map[string]interface{}{"compute_engine": map[string]interface{}{"instance_id": "123", "zone": "us-central1-a"}}
+		}
+		fmt.Fprint(w, signTestToken(t, key, "kid1", claims))
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 1 * time.Second},
+	}
+	ctx := context.Background()
+
+	if _, err := client.GetIdentityToken(ctx, "", ""); err == nil {
+		t.Errorf("GetIdentityToken(ctx, \"\", \"\") succeeded, want error")
+	}
+
+	token, err := client.GetIdentityToken(ctx, "https://service", "full")
+	if err != nil {
+		t.Fatalf("GetIdentityToken(ctx, https://service, full) failed unexpectedly with error: %v", err)
+	}
+	if cached, err := client.GetIdentityToken(ctx, "https://service", "full"); err != nil || cached != token {
+		t.Errorf("GetIdentityToken(ctx, https://service, full) = (%q, %v), want cached token", cached, err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("GetIdentityToken() made %d requests, want 1", got)
+	}
+
+	claims, err := VerifyIdentityToken(token, jwks, "https://service")
+	if err != nil {
+		t.Fatalf("VerifyIdentityToken(%q) failed unexpectedly with error: %v", token, err)
+	}
+	if claims.Google == nil || claims.Google.ComputeEngine.InstanceID != "123" || claims.Google.ComputeEngine.Zone != "us-central1-a" {
+		t.Errorf("VerifyIdentityToken(%q) = %+v, want compute engine claims", token, claims)
+	}
+}
+
+func TestGetAccessTokenCanceledCaller(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600,"token_type":"Bearer"}`)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		endpoints:  newEndpoints(ts.URL + "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+
+	// The first caller starts the shared fetch and gives up on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := client.GetAccessToken(ctx, nil)
+		first <- err
+	}()
+	for hits.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan error)
+	go func() {
+		token, err := client.GetAccessToken(context.Background(), nil)
+		if err == nil && token.Token != "token" {
+			err = fmt.Errorf("got token %q, want token", token.Token)
+		}
+		second <- err
+	}()
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("GetAccessToken(ctx, nil) with a canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("GetAccessToken(ctx, nil) waiting for the shared fetch failed unexpectedly with error: %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("GetAccessToken() calls made %d requests, want 1", got)
+	}
+}